@@ -0,0 +1,103 @@
+package pcfg
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Parameters of sampling sentences in estimateAmbiguity
+const (
+	_AmbiguitySamples = 100
+	_AmbiguitySeed = 1
+	_AmbiguityMaxDepth = 32
+	_AmbiguityMaxLength = 8
+)
+
+// MaxAmbiguity sets the maximum ambiguity of grammar accepted by
+// NewParserFromGrammar, 0 for no limit. The ambiguity is estimated as the
+// average number of parsing trees of short sentences sampled from grammar
+func (g *Grammar) MaxAmbiguity(limit float64) {
+	g.maxAmbiguity = limit
+}
+
+// CountParses counts the number of parsing trees of query derived from <root>.
+// The count is float64 since it grows exponentially in ambiguous grammars.
+// Tokens match the terminal rules the same way as CYK, including the regex
+// terminals and <?unk>
+func CountParses(grammar *CNFGrammar, query []string) float64 {
+	rootId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || len(query) == 0 {
+		return 0
+	}
+	derivesRoot := func(rule *CNFRuleBase) bool {
+		return rule.Source == rootId || indexOfSymbol(rule.Path, rootId) >= 0
+	}
+
+	// counts[length][start] maps symbol to the number of its derivations of
+	// span [start, start + length)
+	counts := make([][]map[int]float64, len(query) + 1)
+	roots := 0.0
+	counts[1] = make([]map[int]float64, len(query))
+	for i, tok := range query {
+		counts[1][i] = map[int]float64{}
+		for _, rule := range tokenRules(grammar, tok, "") {
+			counts[1][i][rule.Source]++
+			if len(query) == 1 && derivesRoot(&rule.CNFRuleBase) {
+				roots++
+			}
+		}
+	}
+	for length := 2; length <= len(query); length++ {
+		columns := len(query) - length + 1
+		counts[length] = make([]map[int]float64, columns)
+		for start := 0; start < columns; start++ {
+			cell := map[int]float64{}
+			for partition := 1; partition < length; partition++ {
+				for first, leftCount := range counts[partition][start] {
+					right := counts[length - partition][start + partition]
+					for second, rightCount := range right {
+						for _, rule := range grammar.lookupRules(first, second) {
+							count := leftCount * rightCount
+							cell[rule.Source] += count
+							if length == len(query) && derivesRoot(&rule.CNFRuleBase) {
+								roots += count
+							}
+						}
+					}
+				}
+			}
+			counts[length][start] = cell
+		}
+	}
+	return roots
+}
+
+// CountParsesInt counts the number of parsing trees of query like CountParses,
+// but returns int. The count saturates at math.MaxInt instead of overflowing
+func CountParsesInt(grammar *CNFGrammar, query []string) int {
+	count := CountParses(grammar, query)
+	if count >= math.MaxInt {
+		return math.MaxInt
+	}
+	return int(count)
+}
+
+// estimateAmbiguity estimates the ambiguity of cnfGrammar as the average
+// number of parsing trees of short sentences sampled from original
+func estimateAmbiguity(original *Grammar, cnfGrammar *CNFGrammar) float64 {
+	rng := rand.New(rand.NewSource(_AmbiguitySeed))
+	total := 0.0
+	sentences := 0
+	for i := 0; i < _AmbiguitySamples; i++ {
+		sentence, err := original.Generate(rng, _AmbiguityMaxDepth)
+		if err != nil || len(sentence) == 0 || len(sentence) > _AmbiguityMaxLength {
+			continue
+		}
+		total += CountParses(cnfGrammar, sentence)
+		sentences++
+	}
+	if sentences == 0 {
+		return 0
+	}
+	return total / float64(sentences)
+}
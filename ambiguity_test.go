@@ -0,0 +1,79 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCountParses(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<e> ::= <e> + <e> | x
+		<root> ::= <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+
+	// Number of binary trees with n leaves is the Catalan number C(n-1)
+	for query, expected := range map[string]float64{
+		"x": 1,
+		"x + x": 1,
+		"x + x + x": 2,
+		"x + x + x + x": 5,
+		"x + x + x + x + x": 14,
+		"x x": 0,
+	} {
+		count := CountParses(cnfGrammar, strings.Fields(query))
+		if count != expected {
+			t.Fatalf("CountParses('%s'): %f != %f", query, count, expected)
+		}
+	}
+
+	// TestCase-2: tokens matched like CYK, by regex terminals and <?unk>
+	grammar, err = ParseGrammar(`
+		<e> ::= <e> + <e> | /[0-9]+/ | <?unk> ; 0.1
+		<root> ::= <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar = grammar.ConvertToCNF()
+	if count := CountParses(cnfGrammar, strings.Fields("1 + 23 + y")); count != 2 {
+		t.Fatalf("%f != 2", count)
+	}
+	// TestCase-3: the int count saturates
+	if count := CountParsesInt(cnfGrammar, strings.Fields("1 + 23 + y")); count != 2 {
+		t.Fatalf("%d != 2", count)
+	}
+	query := strings.Fields(strings.Repeat("x + ", 40) + "x")
+	if count := CountParsesInt(cnfGrammar, query); count != math.MaxInt {
+		t.Fatalf("%d != %d", count, math.MaxInt)
+	}
+}
+
+func TestMaxAmbiguity(t *testing.T) {
+	// TestCase-1: ambiguous grammar
+	grammar, err := ParseGrammar(`
+		<e> ::= <e> <e> ; 0.6 | x ; 0.4
+		<root> ::= <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.MaxAmbiguity(2)
+	if _, err := NewParserFromGrammar(grammar); err == nil {
+		t.Fatal("err != nil expected")
+	}
+
+	// TestCase-2: unambiguous grammar
+	grammar, err = ParseGrammar(`
+		<city> ::= seattle | beijing
+		<s> ::= x | <s> x
+		<root> ::= weather in <city> | <s>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.MaxAmbiguity(2)
+	if _, err := NewParserFromGrammar(grammar); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package pcfg
+
+import (
+	"strings"
+)
+
+// annotatedSymbol returns symbol annotated with its parent, like <np^subject>.
+// The name of parent is kept as is rather than Symbol.Text, which maps
+// different symbols like <a-b>, <a_b> or non-ASCII ones to the same text
+func annotatedSymbol(symbol, parent Symbol) Symbol {
+	name := string(symbol)
+	parentName := string(parent)
+	return Symbol(name[: len(name) - 1] + "^" + parentName[1: len(parentName) - 1] + ">")
+}
+
+// baseSymbolName removes the parent annotation from symbol name, like
+// <np^subject> -> <np>. '^' is not allowed in symbols of grammar text, so that
+// it only occurs in annotated symbols
+func baseSymbolName(name string) string {
+	if i := strings.IndexByte(name, '^'); i >= 0 {
+		return name[: i] + ">"
+	}
+	return name
+}
+
+// AnnotateParents transforms grammar by annotating each non-terminal symbol
+// with its parent, so that rules of the same symbol could have different
+// weights in different contexts. For example
+//     <subject> ::= <np>
+//     <np> ::= <det> <noun>
+// is transformed to
+//     <subject^root> ::= <np^subject>
+//     <np^subject> ::= <det^np> <noun^np>
+// Rules are copied for each context with the same weights, which could be
+// changed by ApplyWeights or FitToSentences. Annotated symbols are exported
+// if the original ones are, and shown as the original ones in parsing tree.
+// It should be called before ConvertToCNF
+func (g *Grammar) AnnotateParents() {
+	occurs := g.occursLeft()
+	rules := []*Rule{}
+	exports := map[Symbol]bool{}
+
+	// Annotated symbols to expand and their original symbols. <root> has no
+	// parent, it's not annotated
+	type annotation struct {
+		symbol, base Symbol
+	}
+	todo := []annotation{{RootSymbol, RootSymbol}}
+	visited := map[Symbol]bool{RootSymbol: true}
+
+	// annotate returns the annotated right side of rule from parent, and adds
+	// the new annotated symbols into todo
+	annotate := func(right []Symbol, parent Symbol) []Symbol {
+		annotated := []Symbol{}
+		for _, symbol := range right {
+			if symbol.IsTerminal() {
+				annotated = append(annotated, symbol)
+				continue
+			}
+			a := annotatedSymbol(symbol, parent)
+			if !visited[a] {
+				visited[a] = true
+				todo = append(todo, annotation{a, symbol})
+			}
+			annotated = append(annotated, a)
+		}
+		return annotated
+	}
+
+	for len(todo) != 0 {
+		var current annotation
+		current, todo = todo[0], todo[1: ]
+		if g.Exports[current.base] {
+			exports[current.symbol] = true
+		}
+		for _, rule := range occurs[current.base] {
+			rules = append(rules, &Rule{
+				Left: current.symbol,
+				Right: annotate(rule.Right, current.base),
+				Weight: rule.Weight,
+				Line: rule.Line,
+				Order: rule.Order,
+			})
+		}
+	}
+
+	g.Rules = rules
+	g.Exports = exports
+}
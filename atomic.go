@@ -0,0 +1,59 @@
+package pcfg
+
+import (
+	"fmt"
+	"strings"
+	"github.com/pkg/errors"
+)
+
+// parseAtomics parses the atomic command, like
+//     ;!atomic: <date> <time>
+// The node of an atomic symbol in parsing tree has a single leaf of the tokens
+// it covers instead of its internal structure
+func parseAtomics(text string) ([]Symbol, error) {
+	symbols := []Symbol{}
+	for _, field := range strings.Fields(text) {
+		symbol := Symbol(field)
+		if symbol.IsTerminal() || !symbol.IsValid() {
+			return nil, errors.New(fmt.Sprintf(
+				"parseAtomics: unexpected atomic symbol: %s",
+				symbol))
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, nil
+}
+
+// AddAtomicSymbol marks s as atomic in grammar, see parseAtomics. Atomic
+// symbols are in parsing tree even if they're not exported
+func (g *CNFGrammar) AddAtomicSymbol(s Symbol) {
+	symbolId := g.getSymbolId(s)
+	if g.atomics == nil {
+		g.atomics = map[int]bool{}
+	}
+	g.atomics[symbolId] = true
+}
+
+// atomicLeaf returns the leaf of tokens in query covered by nodes, joined by
+// space. The inserted leaves are skipped and the substituted leaves are the
+// original tokens
+func atomicLeaf(nodes []*Node) *Node {
+	tokens := []string{}
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.Children != nil {
+			for _, child := range n.Children {
+				walk(child)
+			}
+		} else if n.Original != "" {
+			tokens = append(tokens, n.Original)
+		} else if !n.Inserted {
+			tokens = append(tokens, n.Symbol)
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+	start, end := spanOf(nodes)
+	return &Node{Symbol: strings.Join(tokens, " "), Start: start, End: end}
+}
@@ -0,0 +1,54 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAtomic(t *testing.T) {
+	grammarText := `
+		<month> ::= march | april
+		<day> ::= third | 3rd
+		<date> ::= <month> <day>
+		<root> ::= weather on <date> | <date>
+		;!exports: <month> <day>
+		;!atomic: <date>`
+	parser, err := NewParser(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: a single leaf of the covered tokens
+	tree := parser.Parse(strings.Fields("weather on march third"))
+	expected := "(<root> \n  weather \n  on \n  (<date> \n    march third))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+	date := tree.Children[2]
+	if len(date.Children) != 1 || date.Children[0].Children != nil {
+		t.Fatalf("one leaf expected, but got '%v'", date)
+	}
+
+	// TestCase-2: through the unit rule from <root>
+	tree = parser.Parse(strings.Fields("april 3rd"))
+	expected = "(<root> \n  (<date> \n    april 3rd))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: not atomic
+	parser, err = NewParser(strings.Replace(grammarText, ";!atomic: <date>", ";!exports: <date>", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.Parse(strings.Fields("april 3rd"))
+	expected = "(<root> \n  (<date> \n    (<month> \n      april) \n    (<day> \n      3rd)))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: invalid symbol
+	if _, err := ParseGrammar(";!atomic: date"); err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
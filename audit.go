@@ -0,0 +1,71 @@
+package pcfg
+
+// AuditAction is the change made to a rule in AuditEvent
+type AuditAction int
+
+const (
+	// A new rule is added into grammar
+	AuditAdded AuditAction = iota
+
+	// A rule is removed from grammar
+	AuditRemoved
+
+	// The weight of an existing rule is increased instead of adding a
+	// duplicate one
+	AuditReweighted
+)
+
+// String returns the name of action
+func (a AuditAction) String() string {
+	switch a {
+	case AuditAdded:
+		return "added"
+	case AuditRemoved:
+		return "removed"
+	case AuditReweighted:
+		return "reweighted"
+	}
+	return "unknown"
+}
+
+// Phases of converting grammar to CNF reported in AuditEvent
+const (
+	AuditPhaseNullRules = "removeNullRules"
+	AuditPhaseUnitRules = "removeUnitRules"
+)
+
+// AuditEvent records a rule changed when converting grammar to CNF
+type AuditEvent struct {
+	// Conversion phase, one of AuditPhase*
+	Phase string
+
+	Action AuditAction
+
+	// Text of the rule (see Rule.String) after the change, or before it if
+	// the rule is removed
+	Rule string
+
+	// Why the rule is changed, in human-readable text
+	Reason string
+}
+
+// AuditLog sets the function called with an event for each rule added,
+// removed or reweighted when removing null rules and unit rules in
+// ConvertToCNF. It's for diagnosing the conversion, set it to nil (default)
+// to disable
+func (g *Grammar) AuditLog(log func(event AuditEvent)) {
+	g.auditLog = log
+}
+
+// audit reports the change of rule to the audit log if it's enabled
+func (g *Grammar) audit(phase string, action AuditAction, rule *Rule, reason string) {
+	if g.auditLog == nil {
+		return
+	}
+	g.auditLog(AuditEvent{
+		Phase: phase,
+		Action: action,
+		Rule: rule.String(),
+		Reason: reason,
+	})
+}
@@ -0,0 +1,86 @@
+package pcfg
+
+import (
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<opt> ::= please | <nil>
+		<place> ::= seattle | beijing
+		<city> ::= <place>
+		<root> ::= <opt> <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []AuditEvent{}
+	grammar.AuditLog(func(event AuditEvent) {
+		events = append(events, event)
+	})
+	grammar.ConvertToCNF()
+
+	// TestCase-1: events of null rule and unit rules
+	expected := []AuditEvent{
+		{AuditPhaseNullRules, AuditAdded, "<root> ::= <city> ; 0.500",
+			"<opt> is nullable in '<root> ::= <opt> <city> ; 1.000'"},
+		{AuditPhaseNullRules, AuditRemoved, "<opt> ::= <nil> ; 0.500", "null rule"},
+		{AuditPhaseUnitRules, AuditAdded, "<city> ::= seattle ; 0.500 (<place>)",
+			"unit rule <city> ::= <place> applied to '<place> ::= seattle ; 0.500'"},
+		{AuditPhaseUnitRules, AuditAdded, "<city> ::= beijing ; 0.500 (<place>)",
+			"unit rule <city> ::= <place> applied to '<place> ::= beijing ; 0.500'"},
+		{AuditPhaseUnitRules, AuditRemoved, "<place> ::= seattle ; 0.500",
+			"<place> is only referenced by the removed unit rule"},
+		{AuditPhaseUnitRules, AuditRemoved, "<place> ::= beijing ; 0.500",
+			"<place> is only referenced by the removed unit rule"},
+		{AuditPhaseUnitRules, AuditRemoved, "<city> ::= <place> ; 1.000", "unit rule"},
+		{AuditPhaseUnitRules, AuditAdded, "<root> ::= seattle ; 0.250 (<city> <place>)",
+			"unit rule <root> ::= <city> applied to '<city> ::= seattle ; 0.500 (<place>)'"},
+		{AuditPhaseUnitRules, AuditAdded, "<root> ::= beijing ; 0.250 (<city> <place>)",
+			"unit rule <root> ::= <city> applied to '<city> ::= beijing ; 0.500 (<place>)'"},
+		{AuditPhaseUnitRules, AuditRemoved, "<root> ::= <city> ; 0.500", "unit rule"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("%d events expected, but got %d: %v", len(expected), len(events), events)
+	}
+	for i, event := range events {
+		if event != expected[i] {
+			t.Fatalf("'%v' != '%v'", event, expected[i])
+		}
+	}
+
+	// TestCase-2: disabled by nil, nothing logged
+	grammar, err = ParseGrammar("<root> ::= <nil> | a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = []AuditEvent{}
+	grammar.AuditLog(func(event AuditEvent) {
+		events = append(events, event)
+	})
+	grammar.AuditLog(nil)
+	grammar.ConvertToCNF()
+	if len(events) != 0 {
+		t.Fatalf("no events expected, but got %v", events)
+	}
+
+	// TestCase-3: the null rule of <root> is logged when enabled
+	grammar, err = ParseGrammar("<root> ::= <nil> | a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.AuditLog(func(event AuditEvent) {
+		events = append(events, event)
+	})
+	grammar.ConvertToCNF()
+	expected = []AuditEvent{
+		{AuditPhaseNullRules, AuditRemoved, "<root> ::= <nil> ; 0.500", "null rule"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("%d events expected, but got %d: %v", len(expected), len(events), events)
+	}
+	for i, event := range events {
+		if event != expected[i] {
+			t.Fatalf("'%v' != '%v'", event, expected[i])
+		}
+	}
+}
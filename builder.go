@@ -0,0 +1,98 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// GrammarBuilder builds a Grammar programmatically without the grammar text.
+// Rules and exports are validated when added, and the first error is returned
+// by Build
+type GrammarBuilder struct {
+	grammar *Grammar
+	err error
+}
+
+// NewGrammarBuilder creates a new instance of GrammarBuilder
+func NewGrammarBuilder() *GrammarBuilder {
+	return &GrammarBuilder{
+		grammar: &Grammar{
+			Rules: []*Rule{},
+			Exports: map[Symbol]bool{},
+		},
+	}
+}
+
+// Rule adds the rule left ::= right with weight. Use EpsilonSymbol in right
+// for the blank rule
+func (b *GrammarBuilder) Rule(left Symbol, right []Symbol, weight float64) *GrammarBuilder {
+	if b.err != nil {
+		return b
+	}
+	if !left.IsValid() || left.IsTerminal() {
+		b.err = errors.New(fmt.Sprintf(
+			"GrammarBuilder.Rule: unexpected symbol in the left: '%s'",
+			left))
+		return b
+	}
+	if len(right) == 0 {
+		b.err = errors.New(fmt.Sprintf(
+			"GrammarBuilder.Rule: empty right side of %s",
+			left))
+		return b
+	}
+	for _, symbol := range right {
+		if !symbol.IsValid() {
+			b.err = errors.New(fmt.Sprintf(
+				"GrammarBuilder.Rule: unexpected symbol in the right of %s: '%s'",
+				left,
+				symbol))
+			return b
+		}
+		if symbol.IsRegex() {
+			if _, err := compileRegexTerminal(symbol, false); err != nil {
+				b.err = errors.Wrapf(err, "GrammarBuilder.Rule: regex of %s", left)
+				return b
+			}
+		}
+	}
+	if weight < 0 {
+		b.err = errors.New(fmt.Sprintf(
+			"GrammarBuilder.Rule: negative weight %f of %s",
+			weight,
+			left))
+		return b
+	}
+
+	b.grammar.Rules = append(b.grammar.Rules, &Rule{
+		Left: left,
+		Right: append([]Symbol{}, right...),
+		Weight: weight,
+		Order: len(b.grammar.Rules),
+	})
+	return b
+}
+
+// Export adds s to the export symbols
+func (b *GrammarBuilder) Export(s Symbol) *GrammarBuilder {
+	if b.err != nil {
+		return b
+	}
+	if !s.IsValid() || s.IsTerminal() {
+		b.err = errors.New(fmt.Sprintf(
+			"GrammarBuilder.Export: unexpected export symbol: '%s'",
+			s))
+		return b
+	}
+	b.grammar.Exports[s] = true
+	return b
+}
+
+// Build returns the grammar built, or the first error found when adding rules
+// and exports. The builder should not be used after Build
+func (b *GrammarBuilder) Build() (*Grammar, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.grammar, nil
+}
@@ -0,0 +1,120 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGrammarBuilder(t *testing.T) {
+	grammar, err := NewGrammarBuilder().
+		Rule("<city>", []Symbol{"seattle"}, 1.0).
+		Rule("<city>", []Symbol{"beijing"}, 1.0).
+		Rule("<whats>", []Symbol{"what's", "the"}, 0.5).
+		Rule("<whats>", []Symbol{EpsilonSymbol}, 0.5).
+		Rule(RootSymbol, []Symbol{"<whats>", "weather", "in", "<city>"}, 1.0).
+		Export("<city>").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, err := NewParserFromGrammar(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	textParser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<whats> ::= what's the ; 0.5 | <nil> ; 0.5
+		<root> ::= <whats> weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: the same trees as text grammar
+	for _, query := range []string{
+		"what's the weather in seattle",
+		"weather in beijing",
+	} {
+		tree := parser.Parse(strings.Fields(query))
+		expected := textParser.Parse(strings.Fields(query))
+		if tree == nil || !tree.Equal(expected) {
+			t.Fatalf("'%v' != '%v'", tree, expected)
+		}
+	}
+
+	// TestCase-2: invalid rules and exports
+	builders := []*GrammarBuilder{
+		NewGrammarBuilder().Rule("city", []Symbol{"seattle"}, 1.0),
+		NewGrammarBuilder().Rule("<city>", []Symbol{}, 1.0),
+		NewGrammarBuilder().Rule("<city>", []Symbol{"<a|b>"}, 1.0),
+		NewGrammarBuilder().Rule("<city>", []Symbol{"seattle"}, -1.0),
+		NewGrammarBuilder().Export("city"),
+	}
+	for i, builder := range builders {
+		if _, err := builder.Build(); err == nil {
+			t.Fatalf("err != nil expected in builder %d", i)
+		}
+	}
+}
+
+func TestNormalizeSymbol(t *testing.T) {
+	build := func() *Grammar {
+		builder := NewGrammarBuilder()
+		for i, city := range []Symbol{"seattle", "beijing", "tokyo"} {
+			builder.Rule("<city>", []Symbol{city}, float64(i + 1))
+		}
+		grammar, err := builder.
+			Rule("<time>", []Symbol{"today"}, 3.0).
+			Rule(RootSymbol, []Symbol{"weather", "in", "<city>"}, 2.0).
+			Rule("<time>", []Symbol{"tomorrow"}, 1.0).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return grammar
+	}
+
+	// TestCase-1: only the rules of <city> are normalized
+	grammar := build()
+	grammar.NormalizeSymbol("<city>")
+	expected := []float64{1.0 / 6, 2.0 / 6, 3.0 / 6, 3.0, 2.0, 1.0}
+	for i, rule := range grammar.Rules {
+		if math.Abs(rule.Weight - expected[i]) > 1e-12 {
+			t.Fatalf("%s: %f != %f", rule.Id(), rule.Weight, expected[i])
+		}
+	}
+
+	// TestCase-2: the same as normalizeWeight after all symbols normalized
+	grammar.NormalizeSymbol("<time>")
+	grammar.NormalizeSymbol(RootSymbol)
+	normalized := build()
+	normalized.normalizeWeight()
+	for i, rule := range grammar.Rules {
+		if math.Abs(rule.Weight - normalized.Rules[i].Weight) > 1e-12 {
+			t.Fatalf("%s: %f != %f", rule.Id(), rule.Weight, normalized.Rules[i].Weight)
+		}
+	}
+
+	// TestCase-3: rules appended or removed after normalizing
+	grammar.Rules = append(grammar.Rules, &Rule{
+		Left: "<city>",
+		Right: []Symbol{"paris"},
+		Weight: 1.0,
+	})
+	grammar.NormalizeSymbol("<city>")
+	expected = []float64{1.0 / 12, 2.0 / 12, 3.0 / 12, 0.75, 1.0, 0.25, 0.5}
+	for i, rule := range grammar.Rules {
+		if math.Abs(rule.Weight - expected[i]) > 1e-12 {
+			t.Fatalf("%s: %f != %f", rule.Id(), rule.Weight, expected[i])
+		}
+	}
+	grammar.Rules = grammar.Rules[1: ]
+	grammar.NormalizeSymbol("<city>")
+	expected = []float64{2.0 / 11, 3.0 / 11, 0.75, 1.0, 0.25, 6.0 / 11}
+	for i, rule := range grammar.Rules {
+		if math.Abs(rule.Weight - expected[i]) > 1e-12 {
+			t.Fatalf("%s: %f != %f", rule.Id(), rule.Weight, expected[i])
+		}
+	}
+}
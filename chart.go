@@ -0,0 +1,130 @@
+package pcfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+// _ChartPointer points to a node in CYK chart by the cell coordinates and its
+// index in cell
+type _ChartPointer struct {
+	Length int `json:"length"`
+	Start int `json:"start"`
+	Index int `json:"index"`
+}
+
+// _ChartNode is a node in the JSON dump of CYK chart. For terminal rules,
+// Left and Right are nil, and Terminal is the token matched
+type _ChartNode struct {
+	Symbol string `json:"symbol"`
+	LogProb float64 `json:"logprob"`
+	Path []string `json:"path,omitempty"`
+	Terminal string `json:"terminal,omitempty"`
+	Left *_ChartPointer `json:"left,omitempty"`
+	Right *_ChartPointer `json:"right,omitempty"`
+}
+
+// _ChartCell is a cell in the JSON dump of CYK chart
+type _ChartCell struct {
+	Length int `json:"length"`
+	Start int `json:"start"`
+	Nodes []_ChartNode `json:"nodes"`
+}
+
+// _Chart is the JSON dump of CYK chart
+type _Chart struct {
+	Query []string `json:"query"`
+	Cells []_ChartCell `json:"cells"`
+}
+
+// DumpChart runs CKY algorithm on query and dumps the whole chart (the packed
+// parse forest) as JSON, like
+//     {"query": [...], "cells": [{"length": 1, "start": 0, "nodes": [...]}]}
+// Cells are ordered by length then start, including the empty ones. Each node
+// has its symbol, log-probability and pointers to its children by
+// (length, start, index in cell)
+func DumpChart(grammar *CNFGrammar, query []string) ([]byte, error) {
+	return dumpChart(grammar, query, &_ParseOptions{})
+}
+
+// DumpChart dumps the chart of query like the function DumpChart, but the table
+// is built with the options of parser, so that MaxNodes applies. Returns an
+// error if the node limit is exceeded
+func (p *Parser) DumpChart(query []string) ([]byte, error) {
+	options := p.enumerationOptions()
+	return dumpChart(p.cnfGrammar, query, &options)
+}
+
+// dumpChart dumps the chart of query with the table built with options
+func dumpChart(grammar *CNFGrammar, query []string, options *_ParseOptions) ([]byte, error) {
+	chart := _Chart{Query: query, Cells: []_ChartCell{}}
+	if len(query) != 0 {
+		table := buildTable(grammar, query, options)
+		if table == nil {
+			return nil, errors.New("DumpChart: node limit or deadline exceeded")
+		}
+
+		// Cell coordinates and index in cell of each node
+		positions := map[*_CYKNode]_ChartPointer{}
+		for length := 1; length < len(table); length++ {
+			for start, node := range table[length] {
+				for i := 0; node != nil; i++ {
+					positions[node] = _ChartPointer{Length: length, Start: start, Index: i}
+					node = node.next
+				}
+			}
+		}
+
+		for length := 1; length < len(table); length++ {
+			for start, node := range table[length] {
+				cell := _ChartCell{
+					Length: length,
+					Start: start,
+					Nodes: []_ChartNode{},
+				}
+				for ; node != nil; node = node.next {
+					cell.Nodes = append(
+						cell.Nodes,
+						dumpChartNode(grammar, node, start, positions, query))
+				}
+				chart.Cells = append(chart.Cells, cell)
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(chart); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// dumpChartNode converts node in CYK table starting from start to _ChartNode
+func dumpChartNode(
+	grammar *CNFGrammar,
+	node *_CYKNode,
+	start int,
+	positions map[*_CYKNode]_ChartPointer,
+	query []string) _ChartNode {
+	chartNode := _ChartNode{
+		Symbol: grammar.Symbols[node.symbol],
+		LogProb: float64(node.logp),
+	}
+	for _, symbol := range node.rule.Path {
+		chartNode.Path = append(chartNode.Path, grammar.Symbols[symbol])
+	}
+
+	if node.right == nil {
+		// Terminal rule, the left node is the leaf
+		chartNode.Terminal = query[start]
+		return chartNode
+	}
+	left := positions[node.left]
+	right := positions[node.right]
+	chartNode.Left = &left
+	chartNode.Right = &right
+	return chartNode
+}
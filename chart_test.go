@@ -0,0 +1,71 @@
+package pcfg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDumpChart(t *testing.T) {
+	// "x x" could be parsed as <a> <a> or <b> <b>
+	parser, err := NewParser(`
+		<a> ::= x
+		<b> ::= x
+		<root> ::= <a> <a> ; 0.6 | <b> <b> ; 0.4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := DumpChart(parser.cnfGrammar, strings.Fields("x x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chart struct {
+		Query []string
+		Cells []struct {
+			Length, Start int
+			Nodes []struct {
+				Symbol string
+				LogProb float64
+				Terminal string
+				Left, Right *struct {
+					Length, Start, Index int
+				}
+			}
+		}
+	}
+	if err = json.Unmarshal(data, &chart); err != nil {
+		t.Fatal(err)
+	}
+	if len(chart.Query) != 2 || len(chart.Cells) != 3 {
+		t.Fatalf("unexpected chart: %s", data)
+	}
+
+	// Cells of length 1
+	for i, cell := range chart.Cells[: 2] {
+		if cell.Length != 1 || cell.Start != i || len(cell.Nodes) != 2 {
+			t.Fatalf("unexpected cell %d: %s", i, data)
+		}
+		for _, node := range cell.Nodes {
+			if node.Terminal != "x" || node.Left != nil || node.Right != nil {
+				t.Fatalf("unexpected terminal node in cell %d: %s", i, data)
+			}
+		}
+	}
+
+	// The top cell has two <root> nodes
+	top := chart.Cells[2]
+	if top.Length != 2 || top.Start != 0 || len(top.Nodes) != 2 {
+		t.Fatalf("unexpected top cell: %s", data)
+	}
+	for _, node := range top.Nodes {
+		if node.Symbol != "<root>" || node.Left == nil || node.Right == nil {
+			t.Fatalf("unexpected root node: %s", data)
+		}
+		left := chart.Cells[node.Left.Start].Nodes[node.Left.Index]
+		right := chart.Cells[node.Right.Start].Nodes[node.Right.Index]
+		if node.Left.Length != 1 || node.Right.Length != 1 || left.Symbol != right.Symbol {
+			t.Fatalf("unexpected children of root node: %s", data)
+		}
+	}
+}
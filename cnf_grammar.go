@@ -1,15 +1,28 @@
 package pcfg
 
+import (
+	"golang.org/x/text/unicode/norm"
+	"math"
+	"sort"
+	"strings"
+)
+
 // CNFRuleBase is the base struct for CNFRule and CNFTerminalRule
 type CNFRuleBase struct {
 	// SymbolId in the left of rule
 	Source int
 
 	// Probability of this rule
-	Probability float64
+	Probability Prob
+
+	// Log of Probability, used in parsing
+	LogProbability Prob
 
 	// Path of symbolIds from source to target
 	Path []int
+
+	// Order of the rule in grammar text, see Rule.Order
+	Order int
 }
 
 // CNFRule stores a non-terminal rule in CNF grammar. All of the symbols in this
@@ -28,6 +41,11 @@ type CNFTerminalRule struct {
 
 	// Terminal symbol in this rule
 	TerminalTarget string
+
+	// Terminal as written in grammar, which TerminalTarget is normalized from
+	// to match query tokens, see Grammar.NormalizeUnicode and
+	// Parser.SetCaseInsensitive
+	Literal string
 }
 
 // CNFGrammar stores the grammar in Chomsky normal form
@@ -35,7 +53,8 @@ type CNFGrammar struct {
 	// Map from symbol name to its id
 	SymbolIds map[string]int
 
-	// Map from symbolId to symbol name
+	// Map from symbolId to symbol name. With shared vocabulary, the ids of
+	// symbols not in this grammar are empty
 	Symbols []string
 
 	// Map from terminal string to symbolId
@@ -47,6 +66,61 @@ type CNFGrammar struct {
 
 	// Nonterminal symbols that exports to parsing tree
 	Exports map[int]bool
+
+	// Symbols whose nodes in parsing tree have no internal structure, nil if
+	// none. See AddAtomicSymbol
+	atomics map[int]bool
+
+	// Vocabulary shared with other grammars, nil if not shared
+	vocabulary *Vocabulary
+
+	// If normalize terminals and query tokens to NFC, see
+	// Grammar.NormalizeUnicode
+	normalizeUnicode bool
+
+	// If lowercase terminals and query tokens, see Parser.SetCaseInsensitive
+	caseInsensitive bool
+
+	// Compiled form of Rules used by parsing. compiledRules[B] stores the
+	// rules A -> BC grouped by C and sorted by C. It's nil until Compile() is
+	// called and reset to nil by AddRule
+	compiledRules [][]_RuleGroup
+
+	// Trie of phrases if it's converted from a dictionary grammar, which
+	// parses without CYK. It's reset to nil by AddRule
+	dictionary *_TrieNode
+
+	// Terminals by their ids and the ids by terminals, see InternTokens.
+	// They're built by Compile() and reset to nil by AddRule
+	terminals []string
+	terminalIds map[string]int
+
+	// Separator token derived from <SEP>, empty if <SEP> is not used. See
+	// Grammar.Separator
+	separator string
+
+	// Regex terminals in TerminalRules with their compiled patterns, in the
+	// order of adding
+	regexTerminals []_RegexTerminal
+
+	// Terminal rules by terminal ids
+	terminalRulesById [][]*CNFTerminalRule
+
+	// Terminal rules by source symbolIds, built by Compile() and reset to nil
+	// by AddRule
+	terminalRulesBySource [][]*CNFTerminalRule
+}
+
+// WeightedTerminal is a terminal with the probability of rule producing it
+type WeightedTerminal struct {
+	Terminal string
+	Probability float64
+}
+
+// _RuleGroup is a group of rules A -> BC with the same C
+type _RuleGroup struct {
+	second int
+	rules []*CNFRule
 }
 
 // NewCNFGrammar creates a new instance of CNFGrammar
@@ -60,12 +134,62 @@ func NewCNFGrammar() *CNFGrammar {
 	}
 }
 
+// Vocabulary maps symbols to ids. It could be shared by CNF grammars so that
+// the same symbol gets the same id across them. It's not safe to build
+// grammars with the same vocabulary concurrently
+type Vocabulary struct {
+	// Map from symbol name to its id
+	SymbolIds map[string]int
+
+	// Map from symbolId to symbol name
+	Symbols []string
+}
+
+// NewVocabulary creates a new instance of Vocabulary
+func NewVocabulary() *Vocabulary {
+	return &Vocabulary{
+		SymbolIds: map[string]int{},
+		Symbols: []string{},
+	}
+}
+
+// getSymbolId get the id of given symbol. If the symbol not exist in
+// vocabulary insert a new one
+func (v *Vocabulary) getSymbolId(s Symbol) int {
+	if symbolId, ok := v.SymbolIds[string(s)]; ok {
+		return symbolId
+	}
+	symbolId := len(v.Symbols)
+	v.SymbolIds[string(s)] = symbolId
+	v.Symbols = append(v.Symbols, string(s))
+	return symbolId
+}
+
+// NewCNFGrammarWithVocabulary creates a new instance of CNFGrammar, which
+// allocates symbol ids from a shared vocabulary
+func NewCNFGrammarWithVocabulary(vocabulary *Vocabulary) *CNFGrammar {
+	g := NewCNFGrammar()
+	g.vocabulary = vocabulary
+	return g
+}
+
 // getSymbolId get the id of given symbol. If the symbol not exist in grammar
-// insert a new one
+// insert a new one. With shared vocabulary, the id comes from vocabulary, and
+// Symbols is extended to cover the id. The symbols of other grammars are not
+// added, their ids are left empty in Symbols
 func (g *CNFGrammar) getSymbolId(s Symbol) int {
 	if symbolId, ok := g.SymbolIds[string(s)]; ok {
 		return symbolId
 	}
+	if g.vocabulary != nil {
+		symbolId := g.vocabulary.getSymbolId(s)
+		for len(g.Symbols) <= symbolId {
+			g.Symbols = append(g.Symbols, "")
+		}
+		g.Symbols[symbolId] = string(s)
+		g.SymbolIds[string(s)] = symbolId
+		return symbolId
+	}
 	symbolId := len(g.Symbols)
 	g.SymbolIds[string(s)] = symbolId
 	g.Symbols = append(g.Symbols, string(s))
@@ -99,17 +223,24 @@ func (g *CNFGrammar) AddRule(rule *Rule) {
 	if rule.IsUnary() {
 		// It's a terminal rule, like <weather> ::= weather
 		sourceId := g.getSymbolId(rule.Left)
-		terminalSymbol := string(rule.Right[0])
+		terminalSymbol := rule.Right[0].Literal()
+		if !rule.Right[0].IsRegex() {
+			terminalSymbol = g.normalizeToken(terminalSymbol)
+		}
 		if _, ok := g.TerminalRules[terminalSymbol]; !ok {
 			g.TerminalRules[terminalSymbol] = []*CNFTerminalRule{}
+			g.addRegexTerminal(terminalSymbol)
 		}
 		cnfRule := &CNFTerminalRule{
 			CNFRuleBase: CNFRuleBase{
 				Source: sourceId,
-				Probability: rule.Weight,
+				Probability: Prob(rule.Weight),
+				LogProbability: Prob(math.Log(rule.Weight)),
 				Path: convertPath(rule.Path),
+				Order: rule.Order,
 			},
 			TerminalTarget: terminalSymbol,
+			Literal: rule.Right[0].Literal(),
 		}
 		g.TerminalRules[terminalSymbol] = append(
 			g.TerminalRules[terminalSymbol],
@@ -122,8 +253,10 @@ func (g *CNFGrammar) AddRule(rule *Rule) {
 		cnfRule := &CNFRule{
 			CNFRuleBase: CNFRuleBase{
 				Source: sourceId,
-				Probability: rule.Weight,
+				Probability: Prob(rule.Weight),
+				LogProbability: Prob(math.Log(rule.Weight)),
 				Path: convertPath(rule.Path),
+				Order: rule.Order,
 			},
 			FirstTarget: firstTargetId,
 			SecondTarget: secondTargetId,
@@ -139,5 +272,146 @@ func (g *CNFGrammar) AddRule(rule *Rule) {
 			g.Rules[firstTargetId][secondTargetId],
 			cnfRule)
 	}
+
+	// Rules changed, the compiled form is out of date
+	g.compiledRules = nil
+	g.dictionary = nil
+	g.terminals = nil
+	g.terminalIds = nil
+	g.terminalRulesById = nil
+	g.terminalRulesBySource = nil
+}
+
+// normalizeToken returns the form of token to match terminals
+func (g *CNFGrammar) normalizeToken(token string) string {
+	if g.normalizeUnicode {
+		token = norm.NFC.String(token)
+	}
+	if g.caseInsensitive {
+		token = strings.ToLower(token)
+	}
+	return token
+}
+
+// Compile builds the compiled form of Rules for faster lookups in parsing. It
+// should be called again after new rules are added
+func (g *CNFGrammar) Compile() {
+	compiled := make([][]_RuleGroup, len(g.Symbols))
+	for first, secondRules := range g.Rules {
+		groups := make([]_RuleGroup, 0, len(secondRules))
+		for second, rules := range secondRules {
+			groups = append(groups, _RuleGroup{second: second, rules: rules})
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i].second < groups[j].second
+		})
+		compiled[first] = groups
+	}
+	g.compiledRules = compiled
+
+	// Terminal ids are assigned in sorted order of terminals
+	g.terminals = []string{}
+	for terminal := range g.TerminalRules {
+		g.terminals = append(g.terminals, terminal)
+	}
+	sort.Strings(g.terminals)
+	g.terminalIds = map[string]int{}
+	g.terminalRulesById = make([][]*CNFTerminalRule, len(g.terminals))
+	g.terminalRulesBySource = make([][]*CNFTerminalRule, len(g.Symbols))
+	for id, terminal := range g.terminals {
+		g.terminalIds[terminal] = id
+		g.terminalRulesById[id] = g.TerminalRules[terminal]
+		for _, rule := range g.TerminalRules[terminal] {
+			g.terminalRulesBySource[rule.Source] = append(
+				g.terminalRulesBySource[rule.Source],
+				rule)
+		}
+	}
+}
+
+// TerminalsFor returns the terminals produced by the terminal rules from
+// symbol directly, ordered by probability descendingly then alphabetically.
+// The terminals of a symbol merged into the path of other rules when removing
+// unit rules (see Rule.Path) are listed under the source of those rules
+// instead. It uses the index built by Compile(), or scans all terminal rules
+// if not compiled
+func (g *CNFGrammar) TerminalsFor(symbol string) []WeightedTerminal {
+	terminals := []WeightedTerminal{}
+	symbolId, ok := g.SymbolIds[symbol]
+	if !ok {
+		return terminals
+	}
+	add := func(rule *CNFTerminalRule) {
+		terminals = append(terminals, WeightedTerminal{
+			Terminal: rule.TerminalTarget,
+			Probability: float64(rule.Probability),
+		})
+	}
+	if g.terminalRulesBySource != nil {
+		if symbolId < len(g.terminalRulesBySource) {
+			for _, rule := range g.terminalRulesBySource[symbolId] {
+				add(rule)
+			}
+		}
+	} else {
+		for _, rules := range g.TerminalRules {
+			for _, rule := range rules {
+				if rule.Source == symbolId {
+					add(rule)
+				}
+			}
+		}
+	}
+	sort.Slice(terminals, func(i, j int) bool {
+		if terminals[i].Probability != terminals[j].Probability {
+			return terminals[i].Probability > terminals[j].Probability
+		}
+		return terminals[i].Terminal < terminals[j].Terminal
+	})
+	return terminals
+}
+
+// InternTokens converts tokens to the ids of terminals, which are parsed by
+// Parser.ParseIDs without looking up the terminals by string. The id of token
+// not in terminals is -1. Ids are valid until the rules of grammar change,
+// and they're available after Compile() is called
+func (g *CNFGrammar) InternTokens(tokens []string) []int {
+	ids := make([]int, len(tokens))
+	for i, token := range tokens {
+		id, ok := g.terminalIds[g.normalizeToken(token)]
+		if !ok {
+			id = -1
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// lookupRules returns the rules A -> BC where B == first and C == second. It
+// uses the compiled form when available, otherwise falls back to Rules
+func (g *CNFGrammar) lookupRules(first, second int) []*CNFRule {
+	if g.compiledRules == nil {
+		return g.Rules[first][second]
+	}
+
+	if first >= len(g.compiledRules) {
+		return nil
+	}
+
+	// Binary search second in groups
+	groups := g.compiledRules[first]
+	low, high := 0, len(groups)
+	for low < high {
+		mid := (low + high) / 2
+		if groups[mid].second < second {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	if low < len(groups) && groups[low].second == second {
+		return groups[low].rules
+	}
+	return nil
 }
 
@@ -0,0 +1,106 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSharedVocabulary(t *testing.T) {
+	vocabulary := NewVocabulary()
+	convert := func(grammarText string) *CNFGrammar {
+		grammar, err := ParseGrammar(grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grammar.UseVocabulary(vocabulary)
+		return grammar.ConvertToCNF()
+	}
+	weather := convert(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	music := convert(`
+		<song> ::= hello | yesterday
+		<city> ::= seattle | beijing
+		<root> ::= play <song> in <city>
+		;!exports: <song> <city>`)
+
+	for _, symbol := range []string{"<root>", "<city>"} {
+		if weather.SymbolIds[symbol] != music.SymbolIds[symbol] {
+			t.Fatalf(
+				"%s: id %d != %d",
+				symbol,
+				weather.SymbolIds[symbol],
+				music.SymbolIds[symbol])
+		}
+		if weather.SymbolIds[symbol] != vocabulary.SymbolIds[symbol] {
+			t.Fatalf("%s: id of vocabulary expected", symbol)
+		}
+	}
+	if _, ok := vocabulary.SymbolIds["<song>"]; !ok {
+		t.Fatal("<song> should be added into vocabulary")
+	}
+
+	// Symbols of other grammars are not in the symbol table of grammar
+	other := convert(`
+		<unit> ::= celsius | fahrenheit
+		<root> ::= temperature in <unit>`)
+	if _, ok := other.SymbolIds["<song>"]; ok {
+		t.Fatal("<song> should not be added into other grammar")
+	}
+	for _, symbol := range other.Symbols {
+		if symbol == "<song>" || symbol == "<city>" {
+			t.Fatalf("%s should not be added into other grammar", symbol)
+		}
+	}
+	if other.SymbolIds["<root>"] != weather.SymbolIds["<root>"] {
+		t.Fatal("<root>: id of vocabulary expected")
+	}
+
+	// Both grammars still parse
+	if CYK(weather, strings.Fields("weather in seattle")) == nil {
+		t.Fatal("weather: tree != nil expected")
+	}
+	if CYK(music, strings.Fields("play hello in beijing")) == nil {
+		t.Fatal("music: tree != nil expected")
+	}
+	if CYK(other, strings.Fields("temperature in celsius")) == nil {
+		t.Fatal("other: tree != nil expected")
+	}
+}
+
+func TestTerminalsFor(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle ; 3 | beijing ; 1 | new york ; 1
+		<root> ::= weather in <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+
+	// TestCase-1: terminal rules from <city>, "new york" is not a terminal
+	terminals := cnfGrammar.TerminalsFor("<city>")
+	expected := []WeightedTerminal{{"seattle", 0.6}, {"beijing", 0.2}}
+	if len(terminals) != len(expected) {
+		t.Fatalf("%v != %v", terminals, expected)
+	}
+	for i := range expected {
+		if terminals[i].Terminal != expected[i].Terminal ||
+			math.Abs(terminals[i].Probability - expected[i].Probability) > probTolerance {
+			t.Fatalf("%v != %v", terminals, expected)
+		}
+	}
+
+	// TestCase-2: not compiled after AddRule
+	cnfGrammar.AddRule(&Rule{Left: "<city>", Right: []Symbol{"tokyo"}, Weight: 0.1})
+	terminals = cnfGrammar.TerminalsFor("<city>")
+	if len(terminals) != 3 || terminals[2].Terminal != "tokyo" {
+		t.Fatalf("%v: tokyo expected", terminals)
+	}
+
+	// TestCase-3: symbol not found
+	if terminals := cnfGrammar.TerminalsFor("<town>"); len(terminals) != 0 {
+		t.Fatalf("%v: empty expected", terminals)
+	}
+}
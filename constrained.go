@@ -0,0 +1,93 @@
+package pcfg
+
+// SpanConstraint requires the tokens [Start, End) of query derived from
+// Symbol in parsing tree
+type SpanConstraint struct {
+	Start int
+	End int
+
+	// Symbol like "<date>", which could be exported or not
+	Symbol string
+}
+
+// _SpanSymbol is SpanConstraint with the symbolId
+type _SpanSymbol struct {
+	start int
+	end int
+	symbol int
+}
+
+// ParseConstrained parses query like Parse, but only keeps the derivations
+// with a node of the symbol over the span of each constraint. The nodes
+// crossing the spans are dropped from CYK table, so that the derivations have
+// to go through the cells of the spans, where only the nodes of the symbols
+// are kept. Returns nil if no derivation satisfies all constraints, or any
+// constraint is out of query or has an unknown symbol
+func (p *Parser) ParseConstrained(query []string, constraints []SpanConstraint) *Tree {
+	spans := []_SpanSymbol{}
+	for _, constraint := range constraints {
+		symbolId, ok := p.cnfGrammar.SymbolIds[constraint.Symbol]
+		if !ok ||
+			constraint.Start < 0 ||
+			constraint.Start >= constraint.End ||
+			constraint.End > len(query) {
+			return nil
+		}
+		spans = append(spans, _SpanSymbol{constraint.Start, constraint.End, symbolId})
+	}
+	options := p.options
+	options.spans = spans
+	candidate := p.parseWithOptions(query, &options)
+	if candidate == nil {
+		return nil
+	}
+	return candidate.Tree
+}
+
+// constrainSpan returns the nodes of cell [start, start + length) allowed by
+// spans. The cells crossing a span get no node, and the cells of a span keep
+// only the nodes deriving its symbol. Nodes are copied from pool, so that the
+// order of the others is kept
+func constrainSpan(
+	pool *_NodePool,
+	nodes *_CYKNode,
+	start int,
+	length int,
+	spans []_SpanSymbol) *_CYKNode {
+	end := start + length
+	for _, span := range spans {
+		if end <= span.start || start >= span.end {
+			// Disjoint
+			continue
+		}
+		if start == span.start && end == span.end {
+			nodes = filterNodes(pool, nodes, span.symbol)
+			continue
+		}
+		if start >= span.start && end <= span.end ||
+			start <= span.start && end >= span.end {
+			// Inside or containing the span
+			continue
+		}
+		return nil
+	}
+	return nodes
+}
+
+// filterNodes returns the copies of nodes deriving symbol, either by itself or
+// through the path of its rule
+func filterNodes(pool *_NodePool, nodes *_CYKNode, symbol int) *_CYKNode {
+	var filtered *_CYKNode
+	tail := &filtered
+	for ; nodes != nil; nodes = nodes.next {
+		if nodes.symbol != symbol && indexOfSymbol(keptNode(nodes).rule.Path, symbol) < 0 {
+			continue
+		}
+		node := pool.Get()
+		*node = *nodes
+		node.next = nil
+		*tail = node
+		tail = &node.next
+	}
+	return filtered
+}
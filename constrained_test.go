@@ -0,0 +1,67 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+const constrainedGrammar = `
+<name> ::= may ; 0.9 | june ; 0.1
+<date> ::= may ; 0.5 | june ; 0.5 | may first
+<root> ::= meet <name> | meet <date>
+;!exports: <name> <date>`
+
+func TestParseConstrained(t *testing.T) {
+	parser, err := NewParser(constrainedGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("meet may")
+
+	// TestCase-1: no constraint, <name> is preferred
+	tree := parser.ParseConstrained(query, nil)
+	expected := "(<root> \n  meet \n  (<name> \n    may))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: "may" is constrained to be a <date>
+	tree = parser.ParseConstrained(query, []SpanConstraint{{1, 2, "<date>"}})
+	expected = "(<root> \n  meet \n  (<date> \n    may))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: the whole query is constrained to be <root>
+	tree = parser.ParseConstrained(query, []SpanConstraint{{0, 2, "<root>"}})
+	expected = "(<root> \n  meet \n  (<name> \n    may))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: impossible constraints
+	tree = parser.ParseConstrained(query, []SpanConstraint{{0, 1, "<date>"}})
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	tree = parser.ParseConstrained(query, []SpanConstraint{{1, 3, "<date>"}})
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	tree = parser.ParseConstrained(query, []SpanConstraint{{1, 2, "<unknown>"}})
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+
+	// TestCase-5: the span crossed by constraint
+	query = strings.Fields("meet may first")
+	tree = parser.ParseConstrained(query, []SpanConstraint{{0, 2, "<root>"}})
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	tree = parser.ParseConstrained(query, []SpanConstraint{{1, 3, "<date>"}})
+	expected = "(<root> \n  meet \n  (<date> \n    may \n    first))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
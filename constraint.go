@@ -0,0 +1,100 @@
+package pcfg
+
+// Maximum number of symbols in forbidden combinations, 2 bits of
+// _CYKNode.features for each of them
+const _MaxConstrainedSymbols = 32
+
+// _Constraints stores the forbidden combinations of symbols in a subtree for
+// a parse
+type _Constraints struct {
+	// Index of each symbol in forbidden combinations
+	indexes map[int]uint
+
+	// Pairs of indexes that must not occur in the same subtree. If they are
+	// the same, the symbol must not occur twice
+	pairs [][2]uint
+}
+
+// newConstraints resolves the forbidden combinations of symbol names in
+// grammar. Symbols not in grammar never occur, their combinations are skipped.
+// Returns nil if no combinations left
+func newConstraints(grammar *CNFGrammar, forbidden [][2]string) *_Constraints {
+	c := &_Constraints{indexes: map[int]uint{}}
+	index := func(name string) (uint, bool) {
+		symbol, ok := grammar.SymbolIds[name]
+		if !ok {
+			return 0, false
+		}
+		if i, ok := c.indexes[symbol]; ok {
+			return i, true
+		}
+		i := uint(len(c.indexes))
+		c.indexes[symbol] = i
+		return i, true
+	}
+	for _, pair := range forbidden {
+		a, okA := index(pair[0])
+		b, okB := index(pair[1])
+		if okA && okB {
+			c.pairs = append(c.pairs, [2]uint{a, b})
+		}
+	}
+	if len(c.pairs) == 0 {
+		return nil
+	}
+	return c
+}
+
+// count returns the number of occurrences (at most 2) of the i-th symbol in
+// features
+func (c *_Constraints) count(features uint64, i uint) uint64 {
+	return (features >> (2 * i)) & 3
+}
+
+// combine returns the features of a node of symbol with rule and children,
+// and false if the node is forbidden. For deletion nodes, symbol is -1 and
+// rule is nil, the node itself isn't counted. It's always allowed when c is
+// nil
+func (c *_Constraints) combine(symbol int, rule *CNFRuleBase, children ...*_CYKNode) (uint64, bool) {
+	if c == nil {
+		return 0, true
+	}
+
+	counts := make([]uint64, len(c.indexes))
+	for _, child := range children {
+		if child == nil || child.symbol < 0 {
+			continue
+		}
+		for i := range counts {
+			counts[i] += c.count(child.features, uint(i))
+		}
+	}
+	occur := func(s int) {
+		if i, ok := c.indexes[s]; ok {
+			counts[i]++
+		}
+	}
+	if symbol >= 0 {
+		occur(symbol)
+	}
+	if rule != nil {
+		for _, s := range rule.Path {
+			occur(s)
+		}
+	}
+
+	for _, pair := range c.pairs {
+		if pair[0] == pair[1] && counts[pair[0]] >= 2 ||
+			pair[0] != pair[1] && counts[pair[0]] >= 1 && counts[pair[1]] >= 1 {
+			return 0, false
+		}
+	}
+	features := uint64(0)
+	for i, count := range counts {
+		if count > 2 {
+			count = 2
+		}
+		features |= count << (2 * uint(i))
+	}
+	return features, true
+}
@@ -4,17 +4,32 @@ import (
 	"math"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // cykNode is the node used in CKY table
 type _CYKNode struct {
 	symbol int
 	rule *CNFRuleBase
-	logp float64
 
 	left *_CYKNode
 	right *_CYKNode
 	next *_CYKNode
+
+	// Log-probability, next to edit so that they're packed with float32 Prob
+	logp Prob
+
+	// Edit of this node in error-correcting parsing, see _EditType
+	edit _EditType
+
+	// Terminal from grammar for substitution and insertion nodes
+	word string
+
+	// Number of edits in the subtree of this node
+	edits int
+
+	// Occurrences of the symbols in forbidden combinations, see _Constraints
+	features uint64
 }
 
 // nodePool is the pool that allocatesand stores _CYKNode
@@ -49,52 +64,239 @@ func (pool *_NodePool) Get() *_CYKNode {
 	return node
 }
 
+// Size returns the number of nodes allocated from pool
+func (pool *_NodePool) Size() int {
+	return pool.row * _PoolBatchSize + pool.column
+}
+
 
+// constructParsingTree constructs the tree nodes of node
 func constructParsingTree(grammar *CNFGrammar, node *_CYKNode, query []string) []*Node {
-	// When it's a leaf node (terminal node, row = 0)
-	if node.symbol < 0 {
-		treeNode := &Node{Symbol: query[-node.symbol - 1]}
-		return []*Node{treeNode}
-	}
+	return constructNodes(grammar, node, 0, true, false, 0, query)
+}
 
-	// Get nodes of its children
-	leftNodes := constructParsingTree(grammar, node.left, query)
+// isVisible returns true if symbol has its node in parsing tree, which are the
+// exported and atomic symbols and <root>. An inner <root>, derived from <root>
+// directly or through unit rules, is a node of tree as well
+func isVisible(grammar *CNFGrammar, symbol int) bool {
+	return grammar.Exports[symbol] ||
+		grammar.atomics[symbol] ||
+		grammar.Symbols[symbol] == string(RootSymbol)
+}
 
-	// For some nodes node.right may be nil
-	rightNodes := []*Node{}
-	if node.right != nil {
-		rightNodes = constructParsingTree(grammar, node.right, query)
+// keptNode returns the node kept by deletion nodes, node itself if it's not a
+// deletion node
+func keptNode(node *_CYKNode) *_CYKNode {
+	for node.edit == _EditDeletion {
+		if node.left.symbol < 0 {
+			node = node.right
+		} else {
+			node = node.left
+		}
 	}
+	return node
+}
+
+// _ConstructFrame is a frame in the work stack of constructNodes
+type _ConstructFrame struct {
+	node *_CYKNode
+
+	// node.rule.Path[pathStart: ] is applied on the children of node
+	pathStart int
+
+	// If add the tree node of node itself when it's visible
+	wrapSelf bool
+
+	// Position in query of the first token covered by node. It's tracked
+	// while constructing since the inserted terminals have no leaf in query
+	start int
+
+	// Tree nodes of the left child, valid after it's constructed
+	left []*Node
+
+	// Number of children processed
+	step int
+}
+
+// constructNodes constructs the tree nodes of node. node.rule.Path[pathStart: ]
+// is applied on its children, and if wrapSelf is true, node itself is added
+// when it's visible. For deletion nodes, pathStart and wrapSelf are about the
+// kept node, and the deleted tokens are marked as Deleted. If withRules is
+// true, the tree nodes reference the CNF rules producing them. The spans of
+// tree nodes start from the position start. It uses an explicit work stack
+// instead of recursion, so that very deep trees won't overflow the goroutine
+// stack
+func constructNodes(
+	grammar *CNFGrammar,
+	node *_CYKNode,
+	pathStart int,
+	wrapSelf bool,
+	withRules bool,
+	start int,
+	query []string) []*Node {
+	stack := []*_ConstructFrame{{node: node, pathStart: pathStart, wrapSelf: wrapSelf, start: start}}
+
+	// Tree nodes of the last frame popped, and the position after its tokens
+	var result []*Node
+	end := start
+	for len(stack) != 0 {
+		frame := stack[len(stack) - 1]
+		node := frame.node
+
+		// When it's a leaf node (terminal node, row = 0)
+		if node.symbol < 0 {
+			token := query[-node.symbol - 1]
+			result = []*Node{{
+				Symbol: token,
+				Separator: grammar.isSeparator(token),
+				Start: frame.start,
+				End: frame.start + 1,
+			}}
+			end = frame.start + 1
+			stack = stack[: len(stack) - 1]
+			continue
+		}
 
-	treeNodes := append(leftNodes, rightNodes...)
+		// For deletion nodes, construct the kept node then add the deleted
+		// token before or after it
+		if node.edit == _EditDeletion {
+			deleteFirst := node.left.symbol < 0
+			if frame.step == 0 {
+				frame.step++
+				kept, keptStart := node.left, frame.start
+				if deleteFirst {
+					kept, keptStart = node.right, frame.start + 1
+				}
+				stack = append(stack, &_ConstructFrame{
+					node: kept,
+					pathStart: frame.pathStart,
+					wrapSelf: frame.wrapSelf,
+					start: keptStart,
+				})
+				continue
+			}
+			if deleteFirst {
+				deleted := &Node{
+					Symbol: query[-node.left.symbol - 1],
+					Deleted: true,
+					Start: frame.start,
+					End: frame.start + 1,
+				}
+				result = append([]*Node{deleted}, result...)
+			} else {
+				deleted := &Node{
+					Symbol: query[-node.right.symbol - 1],
+					Deleted: true,
+					Start: end,
+					End: end + 1,
+				}
+				result = append(result, deleted)
+				end++
+			}
+			stack = stack[: len(stack) - 1]
+			continue
+		}
+
+		// Get nodes of its children. For substitution and insertion nodes, the
+		// leaf is the terminal from grammar, and the inserted one is zero-width
+		if frame.step == 0 {
+			frame.step++
+			switch node.edit {
+			case _EditSubstitution:
+				frame.left = []*Node{{
+					Symbol: node.word,
+					Original: query[-node.left.symbol - 1],
+					Start: frame.start,
+					End: frame.start + 1,
+				}}
+				end = frame.start + 1
+			case _EditInsertion:
+				frame.left = []*Node{{
+					Symbol: node.word,
+					Inserted: true,
+					Start: frame.start,
+					End: frame.start,
+				}}
+				end = frame.start
+			default:
+				stack = append(stack, &_ConstructFrame{node: node.left, wrapSelf: true, start: frame.start})
+				continue
+			}
+		} else if frame.step == 1 {
+			frame.left = result
+		}
+
+		// For some nodes node.right may be nil
+		if frame.step == 1 && node.right != nil {
+			frame.step++
+			stack = append(stack, &_ConstructFrame{node: node.right, wrapSelf: true, start: end})
+			continue
+		}
+		rightNodes := []*Node{}
+		if node.right != nil {
+			rightNodes = result
+		}
+		treeNodes := append(frame.left, rightNodes...)
+		var rule *CNFRuleBase
+		if withRules {
+			rule = node.rule
+		}
 
-	// Handle the path from target to source
-	if node.rule.Path != nil {
-		// We are constructing the tree bottom-up, the path should be process
-		// in reversed order
-		for i := len(node.rule.Path) - 1; i >= 0; i-- {
-			symbol := node.rule.Path[i]
-			if grammar.Exports[symbol] {
+		// Handle the path from target to source. We are constructing the tree
+		// bottom-up, the path should be process in reversed order
+		path := node.rule.Path[frame.pathStart: ]
+		for i := len(path) - 1; i >= 0; i-- {
+			symbol := path[i]
+			if isVisible(grammar, symbol) {
+				if grammar.atomics[symbol] {
+					treeNodes = []*Node{atomicLeaf(treeNodes)}
+				}
 				treeNode := &Node{
 					Children: treeNodes,
-					Symbol: grammar.Symbols[symbol],
+					Symbol: baseSymbolName(grammar.Symbols[symbol]),
+					Start: frame.start,
+					End: end,
+					Rule: rule,
 				}
 				treeNodes = []*Node{treeNode}
 			}
 		}
-	}
 
-	// Handle the node itself
-	if grammar.Exports[node.symbol] ||
-		grammar.Symbols[node.symbol] == string(RootSymbol) {
-		treeNode := &Node{
-			Children: treeNodes,
-			Symbol: grammar.Symbols[node.symbol],
+		// Handle the node itself
+		if frame.wrapSelf && isVisible(grammar, node.symbol) {
+			if grammar.atomics[node.symbol] {
+				treeNodes = []*Node{atomicLeaf(treeNodes)}
+			}
+			treeNode := &Node{
+				Children: treeNodes,
+				Symbol: baseSymbolName(grammar.Symbols[node.symbol]),
+				Start: frame.start,
+				End: end,
+				Rule: rule,
+			}
+			treeNodes = []*Node{treeNode}
 		}
-		treeNodes = []*Node{treeNode}
+		result = treeNodes
+		stack = stack[: len(stack) - 1]
 	}
+	return result
+}
 
-	return treeNodes
+// spanOf returns the union of the spans of nodes
+func spanOf(nodes []*Node) (int, int) {
+	if len(nodes) == 0 {
+		return 0, 0
+	}
+	start, end := nodes[0].Start, nodes[0].End
+	for _, n := range nodes[1: ] {
+		if n.Start < start {
+			start = n.Start
+		}
+		if n.End > end {
+			end = n.End
+		}
+	}
+	return start, end
 }
 
 // printRow prints a row in CYK table for debugging
@@ -113,11 +315,430 @@ func printRow(grammar *CNFGrammar, row []*_CYKNode) {
 // CYK parses query using CKY algorithm. When query matches grammae, returns the
 // parsing tree. Otherwise returns nil
 func CYK(grammar *CNFGrammar, query []string) *Tree {
+	return CYKWithStart(grammar, RootSymbol, query)
+}
+
+// CYKWithStart parses query like CYK, but requires the whole query derived
+// from start symbol instead of <root>. The returned tree is rooted at start
+func CYKWithStart(grammar *CNFGrammar, start Symbol, query []string) *Tree {
+	candidate := cyk(grammar, start, query, &_ParseOptions{})
+	if candidate == nil {
+		return nil
+	}
+	return candidate.Tree
+}
+
+// _ParseOptions stores the options of CYK parsing
+type _ParseOptions struct {
+	// When tieBreak != nil, root candidates whose log-probability within
+	// tieEpsilon of the best one are compared by tieBreak
+	tieEpsilon float64
+	tieBreak func(a, b *Candidate) bool
+
+	// Penalties of edits for error-correcting parsing, nil to disable it
+	edits *EditCosts
+
+	// If not nil, the statistics of parsing are stored into it
+	stats *ParseStats
+
+	// Maximum edit distance of fuzzy matching terminals, 0 to disable it
+	fuzzyDistance int
+
+	// Pairs of symbols must not occur in the same subtree
+	forbidden [][2]string
+
+	// If prune the CYK table and detach the best tree from it, see
+	// Parser.CompactForest
+	compactForest bool
+
+	// Parsing is abandoned after deadline, zero for no deadline
+	deadline time.Time
+
+	// Parsing is abandoned when more nodes allocated, 0 for no limit. See
+	// Parser.MaxNodes
+	maxNodes int
+
+	// If tree nodes reference the CNF rules producing them, see
+	// Parser.Provenance
+	withRules bool
+
+	// If prefer the segmentations with longest matches, see
+	// Parser.LongestMatch
+	longestMatch bool
+
+	// Spans required to be derived from symbols, see Parser.ParseConstrained
+	spans []_SpanSymbol
+
+	// Symbol absorbing the tokens not in grammar, empty for any symbol. See
+	// Parser.SetUnknownSymbol
+	unknown string
+}
+
+// Number of combinations between two checks of the deadline when building CYK
+// table
+const _DeadlineCheckInterval = 1024
+
+// isPlain returns true if options change nothing but how the best tree is
+// found, so that parsing could skip the CYK table
+func (o *_ParseOptions) isPlain() bool {
+	return o.tieBreak == nil &&
+		o.edits == nil &&
+		o.stats == nil &&
+		o.fuzzyDistance == 0 &&
+		len(o.forbidden) == 0 &&
+		!o.withRules &&
+		len(o.spans) == 0 &&
+		o.unknown == ""
+}
+
+// ParseStats stores the statistics of a parse for performance analysis
+type ParseStats struct {
+	// Number of non-empty cells in CYK table
+	Cells int
+
+	// Number of pairs of (left, right) nodes tried to combine
+	Combinations int
+
+	// Number of nodes allocated
+	Nodes int
+
+	// Wall time of parsing
+	Duration time.Duration
+}
+
+// Candidate is a candidate of parsing tree in tie-breaking
+type Candidate struct {
+	Tree *Tree
+
+	// Log-probability of the tree
+	LogProb float64
+
+	// Number of edits in error-correcting parsing
+	Edits int
+}
+
+// _RootNode is a node in the top cell of CYK table that derives the start
+// symbol. The start symbol may be the node itself or be merged into the path
+// of its rule. pathIndex is the index of start in the path, -1 for the node
+// itself. For deletion nodes, it's about the kept node
+type _RootNode struct {
+	node *_CYKNode
+	pathIndex int
+}
+
+// findRoots finds the nodes derive start symbol from the top cell of table
+func findRoots(table [][]*_CYKNode, startId int) []_RootNode {
+	roots := []_RootNode{}
+	for node := table[len(table) - 1][0]; node != nil; node = node.next {
+		if node.symbol == startId {
+			roots = append(roots, _RootNode{node, -1})
+		} else if i := indexOfSymbol(keptNode(node).rule.Path, startId); i >= 0 {
+			roots = append(roots, _RootNode{node, i})
+		}
+	}
+	return roots
+}
+
+// constructStartTree constructs the parsing tree rooted at start symbol. If
+// withRules is true, the tree nodes reference the CNF rules producing them
+func constructStartTree(
+	grammar *CNFGrammar,
+	root _RootNode,
+	start Symbol,
+	withRules bool,
+	query []string) *Tree {
+	children := constructNodes(grammar, root.node, root.pathIndex + 1, false, withRules, 0, query)
+	var rule *CNFRuleBase
+	if withRules {
+		rule = keptNode(root.node).rule
+	}
+	return &Tree{
+		Node: &Node{
+			Children: children,
+			Symbol: string(start),
+			Start: 0,
+			End: len(query),
+			Rule: rule,
+		},
+		LogProb: float64(root.node.logp),
+	}
+}
+
+// cyk parses query with options, requires the whole query derived from start.
+// Returns the best parsing tree with its log-probability, nil if not matched
+func cyk(grammar *CNFGrammar, start Symbol, query []string, options *_ParseOptions) *Candidate {
+	if options.stats != nil {
+		startTime := time.Now()
+		defer func() {
+			options.stats.Duration = time.Since(startTime)
+		}()
+	}
+	startId, ok := grammar.SymbolIds[string(start)]
+	if !ok || len(query) == 0 {
+		return nil
+	}
+	if grammar.dictionary != nil && start == RootSymbol && options.isPlain() {
+		return grammar.matchDictionary(query)
+	}
+	if len(query) == 1 && options.isPlain() {
+		return matchToken(grammar, startId, start, query)
+	}
+	table := buildTable(grammar, query, options)
+	if table == nil {
+		// Deadline or node limit exceeded
+		return nil
+	}
+	return bestCandidate(grammar, table, startId, start, query, options)
+}
+
+// matchToken parses the query of a single token without CYK table. It finds
+// the best terminal rule deriving start, by itself or through the path of
+// rule, the same as bestCandidate does on the table of query
+func matchToken(grammar *CNFGrammar, startId int, start Symbol, query []string) *Candidate {
+	rules := tokenRules(grammar, query[0], "")
+	leaf := &_CYKNode{symbol: -1}
+	nodes := make([]_CYKNode, len(rules))
+	best := _RootNode{nil, -1}
+
+	// Rules are visited in the order of the node list in table
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		node := &nodes[i]
+		node.symbol = rule.Source
+		node.rule = &rule.CNFRuleBase
+		node.logp = rule.LogProbability
+		node.left = leaf
+		pathIndex := -1
+		if node.symbol != startId {
+			if pathIndex = indexOfSymbol(rule.Path, startId); pathIndex < 0 {
+				continue
+			}
+		}
+		if best.node == nil ||
+			node.logp > best.node.logp ||
+			node.logp == best.node.logp && compareOrder(node, best.node) < 0 {
+			best = _RootNode{node, pathIndex}
+		}
+	}
+	if best.node == nil {
+		return nil
+	}
+	return &Candidate{
+		Tree: constructStartTree(grammar, best, start, false, query),
+		LogProb: float64(best.node.logp),
+	}
+}
+
+// bestCandidate returns the best parsing tree rooted at start from CYK table,
+// nil if no root found
+func bestCandidate(
+	grammar *CNFGrammar,
+	table [][]*_CYKNode,
+	startId int,
+	start Symbol,
+	query []string,
+	options *_ParseOptions) *Candidate {
+	// Find the best root node
+	roots := findRoots(table, startId)
+	if options.compactForest {
+		roots = detachRoots(roots, options)
+	}
+	maxLogProb := math.Inf(-1)
+	best := -1
+	for i, root := range roots {
+		if float64(root.node.logp) > maxLogProb ||
+			best >= 0 && float64(root.node.logp) == maxLogProb &&
+			compareOrder(root.node, roots[best].node) < 0 {
+			maxLogProb = float64(root.node.logp)
+			best = i
+		}
+	}
+	if best < 0 {
+		// No root means query didn't match grammar
+		return nil
+	}
+	bestCandidate := &Candidate{
+		Tree: constructStartTree(grammar, roots[best], start, options.withRules, query),
+		LogProb: maxLogProb,
+		Edits: roots[best].node.edits,
+	}
+	if options.tieBreak == nil {
+		return bestCandidate
+	}
+
+	// Break near-ties with options.tieBreak
+	for i, root := range roots {
+		if i == best || float64(root.node.logp) < maxLogProb - options.tieEpsilon {
+			continue
+		}
+		candidate := &Candidate{
+			Tree: constructStartTree(grammar, root, start, options.withRules, query),
+			LogProb: float64(root.node.logp),
+			Edits: root.node.edits,
+		}
+		if options.tieBreak(candidate, bestCandidate) {
+			bestCandidate = candidate
+		}
+	}
+	return bestCandidate
+}
+
+// detachRoots copies the best root, and the roots within tieEpsilon of it when
+// tie-breaking, with their sub-forests out of the node pool. Then the pool and
+// table could be released before constructing parsing tree
+func detachRoots(roots []_RootNode, options *_ParseOptions) []_RootNode {
+	maxLogProb := math.Inf(-1)
+	for _, root := range roots {
+		maxLogProb = math.Max(maxLogProb, float64(root.node.logp))
+	}
+
+	detached := []_RootNode{}
+	for _, root := range roots {
+		if float64(root.node.logp) == maxLogProb ||
+			options.tieBreak != nil &&
+			float64(root.node.logp) >= maxLogProb - options.tieEpsilon {
+			detached = append(detached, _RootNode{detachNode(root.node), root.pathIndex})
+		}
+	}
+	return detached
+}
+
+// detachNode copies node and its descendants, next of the copy is nil
+func detachNode(node *_CYKNode) *_CYKNode {
+	if node == nil {
+		return nil
+	}
+	detached := *node
+	detached.left = detachNode(node.left)
+	detached.right = detachNode(node.right)
+	detached.next = nil
+	return &detached
+}
+
+// compareOrder compares the trees of a and b by the orders of their rules in
+// preorder. Returns negative if a comes from earlier rules in grammar text,
+// positive if b does, and 0 if they tie
+func compareOrder(a, b *_CYKNode) int {
+	stack := [][2]*_CYKNode{{a, b}}
+	for len(stack) != 0 {
+		x, y := stack[len(stack) - 1][0], stack[len(stack) - 1][1]
+		stack = stack[: len(stack) - 1]
+		if x == y || x == nil || y == nil || x.symbol < 0 || y.symbol < 0 {
+			continue
+		}
+		if x.rule != nil && y.rule != nil && x.rule.Order != y.rule.Order {
+			return x.rule.Order - y.rule.Order
+		}
+
+		// Push right first so that left is compared first
+		stack = append(stack, [2]*_CYKNode{x.right, y.right}, [2]*_CYKNode{x.left, y.left})
+	}
+	return 0
+}
+
+// indexOfSymbol returns the index of symbol in path, -1 if not found
+func indexOfSymbol(path []int, symbol int) int {
+	for i, s := range path {
+		if s == symbol {
+			return i
+		}
+	}
+	return -1
+}
+
+// buildTable fills the CYK table of query. table[length][start] is the linked
+// list of nodes for span [start, start + length). Returns nil if the deadline
+// or the node limit in options exceeded
+func buildTable(grammar *CNFGrammar, query []string, options *_ParseOptions) [][]*_CYKNode {
+	return buildTableWith(grammar, query, options, newNodePool(), grammar.TerminalRules, nil)
+}
+
+// lookupTerminalRules returns the terminal rules matching the normalized tok
+// in terminalRules, the exact terminal and the regex terminals, as row 1 of
+// CYK table does before falling back to <?unk>
+func lookupTerminalRules(
+	grammar *CNFGrammar,
+	terminalRules map[string][]*CNFTerminalRule,
+	tok string) []*CNFTerminalRule {
+	rules := terminalRules[tok]
+	if len(grammar.regexTerminals) != 0 {
+		rules = append(rules[: len(rules): len(rules)], grammar.regexRules(tok)...)
+	}
+	return rules
+}
+
+// tokenRules returns the terminal rules matching tok like row 1 of CYK table
+// without fuzzy matching or edits: the exact and the regex terminals of the
+// normalized tok, or the rules of <?unk> deriving unknown if none of them
+// matches (see unknownRules)
+func tokenRules(grammar *CNFGrammar, tok string, unknown string) []*CNFTerminalRule {
+	rules := lookupTerminalRules(grammar, grammar.TerminalRules, grammar.normalizeToken(tok))
+	if len(rules) == 0 {
+		rules = unknownRules(grammar, unknown)
+	}
+	return rules
+}
+
+// matchTerminalRules returns the list of nodes of terminal rules on leaf, the
+// last rule at the head
+func matchTerminalRules(
+	pool *_NodePool,
+	rules []*CNFTerminalRule,
+	leaf *_CYKNode,
+	constraints *_Constraints) *_CYKNode {
+	var nodes *_CYKNode
+	for _, rule := range rules {
+		features, ok := constraints.combine(rule.Source, &rule.CNFRuleBase)
+		if !ok {
+			continue
+		}
+		node := pool.Get()
+		node.symbol = rule.Source
+		node.rule = &rule.CNFRuleBase
+		node.logp = rule.LogProbability
+		node.left = leaf
+		node.next = nodes
+		node.features = features
+
+		// Insert into the head of linklist
+		nodes = node
+	}
+	return nodes
+}
+
+// buildTableWith builds the CYK table like buildTable, but allocates nodes from
+// pool and looks up the terminal rules of normalized query tokens in
+// terminalRules, so that they could be shared by several grammars
+func buildTableWith(
+	grammar *CNFGrammar,
+	query []string,
+	options *_ParseOptions,
+	pool *_NodePool,
+	terminalRules map[string][]*CNFTerminalRule,
+	ids []int) [][]*_CYKNode {
+	expired := func() bool {
+		return !options.deadline.IsZero() && time.Now().After(options.deadline)
+	}
+
+	// The pool may be shared by several tables, see MultiParser
+	poolBase := pool.Size()
+	exhausted := func() bool {
+		return options.maxNodes > 0 && pool.Size() - poolBase > options.maxNodes
+	}
 	if gEnableDebug {
 		fmt.Println("======= CYK algorithm =======")
 	}
 	table := [][]*_CYKNode{}
-	pool := newNodePool()
+	combinations := 0
+
+	// Keep only the best node of each symbol in cells, which is enough for
+	// the best parsing tree
+	prune := options.edits != nil || options.compactForest
+	constraints := newConstraints(grammar, options.forbidden)
+	var editContext *_EditContext
+	if options.edits != nil {
+		editContext = newEditContext(grammar, *options.edits, constraints)
+	}
 
 	// Row 0: dummy node for terminal symbols
 	table = append(table, make([]*_CYKNode, len(query)))
@@ -126,28 +747,63 @@ func CYK(grammar *CNFGrammar, query []string) *Tree {
 		table[0][i] = &_CYKNode{symbol: -i - 1}
 	}
 
-	// Row 1: apply all terminla rules
+	// Row 1: apply all terminla rules. The rules matched by the same
+	// normalized token are looked up once, and each position gets its own
+	// nodes with its leaf. If ids is not nil, the rules are looked up by the
+	// terminal ids of query instead, and the negative ids are the tokens not in
+	// terminals. Besides the exact terminal, a token matches the regex
+	// terminals, and the tokens matching nothing match the rules of <?unk>
 	table = append(table, make([]*_CYKNode, len(query)))
+	matchedRules := map[string][]*CNFTerminalRule{}
 	for i, tok := range query {
-		if rules, ok := grammar.TerminalRules[tok]; ok {
-			var nodes *_CYKNode
-			for _, rule := range rules {
-				node := pool.Get()
-				node.symbol = rule.Source
-				node.rule = &rule.CNFRuleBase
-				node.logp = math.Log(rule.Probability)
-				node.left = table[0][i]
-				node.next = nodes
-
-				// Insert into the head of linklist
-				nodes = node
+		var rules []*CNFTerminalRule
+		if ids != nil {
+			if ids[i] >= 0 {
+				rules = grammar.terminalRulesById[ids[i]]
+				if len(grammar.regexTerminals) != 0 {
+					rules = append(rules[: len(rules): len(rules)], grammar.regexRules(tok)...)
+				}
+			}
+		} else {
+			tok = grammar.normalizeToken(tok)
+			var ok bool
+			if rules, ok = matchedRules[tok]; !ok {
+				rules = lookupTerminalRules(grammar, terminalRules, tok)
+				matchedRules[tok] = rules
 			}
-			table[1][i] = nodes
 		}
+		nodes := matchTerminalRules(pool, rules, table[0][i], constraints)
+		if nodes == nil && options.fuzzyDistance > 0 {
+			nodes = addFuzzyMatches(
+				grammar,
+				pool,
+				table[0][i],
+				tok,
+				options.fuzzyDistance,
+				constraints)
+		}
+		if nodes == nil && len(rules) == 0 {
+			unknown := unknownRules(grammar, options.unknown)
+			nodes = matchTerminalRules(pool, unknown, table[0][i], constraints)
+		}
+		if editContext != nil {
+			nodes = editContext.addSubstitutions(pool, nodes, table[0][i], tok)
+			nodes = editContext.addInsertions(pool, nodes)
+		}
+		if options.spans != nil {
+			nodes = constrainSpan(pool, nodes, i, 1, options.spans)
+		}
+		if prune && len(query) > 1 {
+			nodes = pruneNodes(nodes)
+		}
+		table[1][i] = nodes
 	}
 	if gEnableDebug {
 		printRow(grammar, table[1])
 	}
+	if exhausted() {
+		return nil
+	}
 
 
 	// Row 2 to row n: apply non-terminal rules
@@ -158,19 +814,34 @@ func CYK(grammar *CNFGrammar, query []string) *Tree {
 		table = append(table, make([]*_CYKNode, columns))
 		// Start of span
 		for start := 0; start < columns; start++ {
+			if expired() || exhausted() {
+				return nil
+			}
+
 			// Partition of span
 			for partition := 1; partition < length; partition++ {
 				left := table[partition][start]
 				for left != nil {
-					rightRules, ok := grammar.Rules[left.symbol]
 					right := table[length - partition][start + partition]
-					for ok && right != nil {
-						if rules, ok := rightRules[right.symbol]; ok {
+					for right != nil {
+						combinations++
+						if combinations % _DeadlineCheckInterval == 0 && expired() {
+							return nil
+						}
+						if rules := grammar.lookupRules(left.symbol, right.symbol); rules != nil {
 							// Ok, there are some rules A -> BC that B == first
 							// and C == second
 							nodes := table[length][start]
 							for _, rule := range rules {
-								logp := math.Log(rule.Probability) + left.logp + right.logp
+								features, ok := constraints.combine(
+									rule.Source,
+									&rule.CNFRuleBase,
+									left,
+									right)
+								if !ok {
+									continue
+								}
+								logp := rule.LogProbability + left.logp + right.logp
 								node := pool.Get()
 								node.symbol = rule.Source
 								node.left = left
@@ -178,10 +849,15 @@ func CYK(grammar *CNFGrammar, query []string) *Tree {
 								node.next = nodes
 								node.rule = &rule.CNFRuleBase
 								node.logp = logp
+								node.edits = left.edits + right.edits
+								node.features = features
 
 								nodes = node
 							}
 							table[length][start] = nodes
+							if exhausted() {
+								return nil
+							}
 						}
 						right = right.next
 					}
@@ -189,32 +865,59 @@ func CYK(grammar *CNFGrammar, query []string) *Tree {
 					left = left.next
 				}
 			}
+
+			if editContext != nil {
+				// Delete the last or the first token of span
+				nodes := table[length][start]
+				nodes = editContext.addDeletions(
+					pool,
+					nodes,
+					table[length - 1][start],
+					table[0][start + length - 1],
+					false)
+				nodes = editContext.addDeletions(
+					pool,
+					nodes,
+					table[length - 1][start + 1],
+					table[0][start],
+					true)
+
+				// Insert missing terminals around the nodes in span
+				nodes = editContext.addInsertions(pool, nodes)
+				table[length][start] = nodes
+			}
+
+			if options.spans != nil {
+				table[length][start] = constrainSpan(
+					pool,
+					table[length][start],
+					start,
+					length,
+					options.spans)
+			}
+
+			// Nodes in the top cell are kept since the start symbol may be
+			// in the path of their rules
+			if prune && length < len(query) {
+				table[length][start] = pruneNodes(table[length][start])
+			}
 		}
 		if gEnableDebug {
 			printRow(grammar, table[len(table) - 1])
 		}
 	}
 
-	// Find the best root node and construct the parsing tree
-	rootSymbol := grammar.SymbolIds[string(RootSymbol)]
-	node := table[len(query)][0]
-	maxLogProb := math.Inf(-1)
-	var root *_CYKNode
-	for node != nil {
-		if node.symbol == rootSymbol && node.logp > maxLogProb {
-			maxLogProb = node.logp
-			root = node
+	if options.stats != nil {
+		options.stats.Cells = 0
+		for _, row := range table[1: ] {
+			for _, nodes := range row {
+				if nodes != nil {
+					options.stats.Cells++
+				}
+			}
 		}
-		node = node.next
-	}
-	if root == nil {
-		// root == nil means query didn't match grammar
-		return nil
-	}
-
-
-	nodes := constructParsingTree(grammar, root, query)
-	return &Tree{
-		Node: nodes[0],
+		options.stats.Combinations = combinations
+		options.stats.Nodes = pool.Size()
 	}
+	return table
 }
@@ -0,0 +1,327 @@
+package pcfg
+
+import (
+	"fmt"
+	"math"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// denseCNFGrammar creates a CNF grammar with n non-terminal symbols, every
+// pair of them could be combined into another one
+func denseCNFGrammar(n int) *CNFGrammar {
+	grammar := NewCNFGrammar()
+	symbol := func(i int) Symbol {
+		return Symbol(fmt.Sprintf("<a%d>", i))
+	}
+	for i := 0; i < n; i++ {
+		grammar.AddRule(&Rule{
+			Left: symbol(i),
+			Right: []Symbol{"w"},
+			Weight: 1.0 / float64(n)})
+		for j := 0; j < n; j++ {
+			grammar.AddRule(&Rule{
+				Left: RootSymbol,
+				Right: []Symbol{symbol(i), symbol(j)},
+				Weight: 1.0 / float64(n * n)})
+			grammar.AddRule(&Rule{
+				Left: symbol((i + j) % n),
+				Right: []Symbol{symbol(i), symbol(j)},
+				Weight: 1.0 / float64(n)})
+		}
+	}
+	return grammar
+}
+
+func benchmarkDenseCYK(b *testing.B, compile bool) {
+	grammar := denseCNFGrammar(32)
+	if compile {
+		grammar.Compile()
+	}
+	query := strings.Fields("w w w")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if CYK(grammar, query) == nil {
+			b.Fatal("CYK: query not matched")
+		}
+	}
+}
+
+func BenchmarkCYKDenseMap(b *testing.B) {
+	benchmarkDenseCYK(b, false)
+}
+
+func BenchmarkCYKDenseCompiled(b *testing.B) {
+	benchmarkDenseCYK(b, true)
+}
+
+// BenchmarkChartMemory reports the bytes of nodes in the CYK table of a dense
+// grammar. Run it with and without -tags pcfg_float32 to compare the Prob types
+func BenchmarkChartMemory(b *testing.B) {
+	grammar := denseCNFGrammar(8)
+	grammar.Compile()
+	query := strings.Fields("w w w w")
+	nodes := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pool := newNodePool()
+		buildTableWith(grammar, query, &_ParseOptions{}, pool, grammar.TerminalRules, nil)
+		nodes = pool.Size()
+	}
+	b.ReportMetric(float64(nodes) * float64(unsafe.Sizeof(_CYKNode{})), "chart-bytes")
+}
+
+func BenchmarkCYKRepeatedAmbiguousTokens(b *testing.B) {
+	grammar := NewCNFGrammar()
+	for i := 0; i < 64; i++ {
+		grammar.AddRule(&Rule{
+			Left: Symbol(fmt.Sprintf("<a%d>", i)),
+			Right: []Symbol{"x"},
+			Weight: 1.0})
+	}
+	grammar.AddRule(&Rule{
+		Left: RootSymbol,
+		Right: []Symbol{"<a0>", "<a1>"},
+		Weight: 1.0})
+	grammar.Compile()
+	query := strings.Fields(strings.Repeat("x ", 64))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CYK(grammar, query)
+	}
+}
+
+func TestCYKSmallProbability(t *testing.T) {
+	parser, err := NewParser(`
+		<w> ::= a ; 0.0000000001 | b ; 0.9999999999
+		<root> ::= <w> <root> | <w>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Best log-probability is around 200 * log(1e-10) = -4605
+	query := strings.Fields(strings.Repeat("a ", 200))
+	candidate := parser.parse(query)
+	if candidate == nil {
+		t.Fatal("candidate != nil expected")
+	}
+	if math.IsInf(candidate.LogProb, 0) || candidate.LogProb > -4605 {
+		t.Fatalf("finite log-probability < -4605 expected, but got %f", candidate.LogProb)
+	}
+	if p, ok := LinearProb(candidate.LogProb); ok || p != 0 {
+		t.Fatalf("LinearProb: underflow expected, but got (%g, %v)", p, ok)
+	}
+	if p, ok := LinearProb(math.Log(0.5)); !ok || math.Abs(p - 0.5) > 1e-12 {
+		t.Fatalf("LinearProb: (0.5, true) expected, but got (%g, %v)", p, ok)
+	}
+}
+
+func TestConstructDeepTree(t *testing.T) {
+	grammar := NewCNFGrammar()
+	grammar.AddRule(&Rule{Left: "<l>", Right: []Symbol{"<x>", "<l>"}, Weight: 0.5})
+	grammar.AddRule(&Rule{Left: "<l>", Right: []Symbol{"x"}, Weight: 0.5})
+	grammar.AddRule(&Rule{Left: "<x>", Right: []Symbol{"x"}, Weight: 1.0})
+	grammar.AddExportSymbol("<l>")
+	binary := grammar.lookupRules(grammar.SymbolIds["<x>"], grammar.SymbolIds["<l>"])[0]
+	terminals := grammar.TerminalRules["x"]
+
+	// A right-branching chain of nodes derives "x x ... x"
+	depth := 100000
+	query := make([]string, depth)
+	leaves := make([]_CYKNode, depth)
+	for i := range query {
+		query[i] = "x"
+		leaves[i].symbol = -i - 1
+	}
+	lexical := func(symbol int, i int) *_CYKNode {
+		for _, rule := range terminals {
+			if rule.Source == symbol {
+				return &_CYKNode{symbol: symbol, rule: &rule.CNFRuleBase, left: &leaves[i]}
+			}
+		}
+		return nil
+	}
+	node := lexical(grammar.SymbolIds["<l>"], depth - 1)
+	for i := depth - 2; i >= 0; i-- {
+		node = &_CYKNode{
+			symbol: binary.Source,
+			rule: &binary.CNFRuleBase,
+			left: lexical(grammar.SymbolIds["<x>"], i),
+			right: node,
+		}
+	}
+
+	// The recursive construction overflows the stack with this limit
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	treeNodes := constructParsingTree(grammar, node, query)
+	for i := 0; i < depth; i++ {
+		if len(treeNodes) != 1 || treeNodes[0].Symbol != "<l>" {
+			t.Fatalf("<l> expected at depth %d", i)
+		}
+		children := treeNodes[0].Children
+		if i == depth - 1 {
+			if len(children) != 1 || children[0].Symbol != "x" {
+				t.Fatalf("leaf 'x' expected at depth %d", i)
+			}
+			break
+		}
+		if len(children) != 2 || children[0].Symbol != "x" {
+			t.Fatalf("'x' and <l> expected at depth %d", i)
+		}
+		treeNodes = children[1: ]
+	}
+}
+
+func TestTreeLogProb(t *testing.T) {
+	parser, err := NewParser(`
+		<a> ::= x ; 0.4 | y ; 0.6
+		<b> ::= z
+		<root> ::= <a> <b> ; 0.7 | <b> <a> ; 0.3
+		;!exports: <a> <b>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: sum of the terminal and binary rule log-probabilities
+	tree := parser.Parse(strings.Fields("x z"))
+	if tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+	expected := math.Log(0.7) + math.Log(0.4) + math.Log(1.0)
+	if math.Abs(tree.LogProb - expected) > probTolerance {
+		t.Fatalf("%f != %f", tree.LogProb, expected)
+	}
+
+	// TestCase-2: kept by stripping <root>
+	parser.StripRoot(true)
+	parser.cnfGrammar.AddRule(&Rule{Left: RootSymbol, Right: []Symbol{"w"}, Weight: 0.5})
+	parser.cnfGrammar.Compile()
+	tree = parser.Parse(strings.Fields("w"))
+	if tree == nil || math.Abs(tree.LogProb - math.Log(0.5)) > probTolerance {
+		t.Fatalf("%v != %f", tree, math.Log(0.5))
+	}
+}
+
+const singleTokenGrammar = `
+<city> ::= seattle ; 0.6 | beijing ; 0.4
+<place> ::= <city> ; 0.5 | home ; 0.5
+<song> ::= hello | beijing
+<root> ::= <place> ; 0.6 | <song> ; 0.3 | <place> weather ; 0.1
+;!exports: <city> <place> <song>`
+
+func TestSingleToken(t *testing.T) {
+	parser, err := NewParser(singleTokenGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: same as the trees from CYK table, which is built when
+	// collecting statistics
+	for _, token := range []string{"seattle", "beijing", "home", "hello", "weather"} {
+		query := []string{token}
+		tree := parser.Parse(query)
+		expected, _ := parser.ParseStats(query)
+		if !tree.Equal(expected) {
+			t.Fatalf("'%v' != '%v'", tree, expected)
+		}
+		if tree != nil && tree.LogProb != expected.LogProb {
+			t.Fatalf("%f != %f", tree.LogProb, expected.LogProb)
+		}
+	}
+
+	// TestCase-2: through the unit paths
+	tree := parser.Parse([]string{"seattle"})
+	expected := "(<root> \n  (<place> \n    (<city> \n      seattle)))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+func BenchmarkParseSingleToken(b *testing.B) {
+	parser, err := NewParser(singleTokenGrammar)
+	if err != nil {
+		b.Fatal(err)
+	}
+	query := []string{"beijing"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.Parse(query)
+	}
+}
+
+// spanString formats the span of n and its descendants, like
+//     <root>[0,3) (weather[0,1) ...)
+func spanString(n *Node) string {
+	s := fmt.Sprintf("%s[%d,%d)", n.Symbol, n.Start, n.End)
+	if n.Children != nil {
+		children := []string{}
+		for _, child := range n.Children {
+			children = append(children, spanString(child))
+		}
+		s += " (" + strings.Join(children, " ") + ")"
+	}
+	return s
+}
+
+func TestTreeSpans(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= new york | seattle
+		<date> ::= march third | today | seattle
+		<root> ::= weather in <city> <date> | <city>
+		;!exports: <city>
+		;!atomic: <date>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: leaves map to tokens, internal nodes span their children
+	tree := parser.Parse(strings.Fields("weather in new york march third"))
+	expected := "<root>[0,6) (weather[0,1) in[1,2) <city>[2,4) (new[2,3) york[3,4)) " +
+		"<date>[4,6) (march third[4,6)))"
+	if tree == nil || spanString(tree.Node) != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: single token and dictionary matching
+	tree = parser.Parse([]string{"seattle"})
+	expected = "<root>[0,1) (<city>[0,1) (seattle[0,1)))"
+	if tree == nil || spanString(tree.Node) != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+	tree = parser.Parse(strings.Fields("new york"))
+	expected = "<root>[0,2) (<city>[0,2) (new[0,1) york[1,2)))"
+	if tree == nil || spanString(tree.Node) != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: the node lists of repeated tokens are shared in table
+	tree = parser.Parse(strings.Fields("weather in seattle seattle"))
+	expected = "<root>[0,4) (weather[0,1) in[1,2) <city>[2,3) (seattle[2,3)) " +
+		"<date>[3,4) (seattle[3,4)))"
+	if tree == nil || spanString(tree.Node) != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: deleted tokens keep their positions, and inserted leaves
+	// are zero-width
+	tree = parser.ParseWithDeletion(strings.Fields("the weather in seattle today"), -5)
+	expected = "<root>[0,5) (the[0,1) weather[1,2) in[2,3) <city>[3,4) (seattle[3,4)) " +
+		"<date>[4,5) (today[4,5)))"
+	if tree == nil || spanString(tree.Node) != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+	tree, _ = parser.ParseApprox(strings.Fields("weather seattle today"), EditCosts{
+		Insertion: -5,
+		Deletion: math.Inf(-1),
+		Substitution: math.Inf(-1),
+	})
+	expected = "<root>[0,3) (weather[0,1) in[1,1) <city>[1,2) (seattle[1,2)) " +
+		"<date>[2,3) (today[2,3)))"
+	if tree == nil || spanString(tree.Node) != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
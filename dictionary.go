@@ -0,0 +1,182 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+)
+
+// _Phrase is a sentence of dictionary grammar with its most probable
+// derivation from <root>
+type _Phrase struct {
+	tokens []string
+
+	// Symbols from <root> (exclusive) down to the symbol of the phrase. The
+	// visible ones wrap the tokens in parsing tree
+	symbols []Symbol
+
+	logp float64
+}
+
+// _TrieNode is a node in the trie of phrases of dictionary grammar
+type _TrieNode struct {
+	children map[string]*_TrieNode
+
+	// The phrase ending at this node, nil if no phrase ends here
+	phrase *_Phrase
+}
+
+// dictionaryPhrases returns the phrases derived from <root> if g is a
+// dictionary grammar, nil otherwise. In dictionary grammar, the right side of
+// each rule is either terminals only or a single non-terminal, the unit rules
+// have no cycle, and neither <?unk> nor regex terminals are used. So that
+// each sentence is a phrase of terminals derived through a chain of unit
+// rules. It should be called after the weights are normalized and before
+// rules are rewritten
+func (g *Grammar) dictionaryPhrases() []*_Phrase {
+	occursLeft := g.occursLeft()
+	for _, rule := range g.Rules {
+		if len(rule.Right) == 0 || rule.Path != nil {
+			return nil
+		}
+		for _, symbol := range rule.Right {
+			if symbol == EpsilonSymbol || symbol == UnknownSymbol || symbol.IsRegex() {
+				return nil
+			}
+			if !symbol.IsTerminal() && len(rule.Right) > 1 {
+				return nil
+			}
+		}
+	}
+
+	// The best phrase of each sentence
+	best := map[string]*_Phrase{}
+	sentences := []string{}
+
+	// Walk down the unit rules from <root>, symbols are the symbols walked
+	// through, and onStack detects cycles
+	onStack := map[Symbol]bool{}
+	isDictionary := true
+	var walk func(symbol Symbol, symbols []Symbol, logp float64)
+	walk = func(symbol Symbol, symbols []Symbol, logp float64) {
+		if onStack[symbol] {
+			isDictionary = false
+			return
+		}
+		onStack[symbol] = true
+		defer delete(onStack, symbol)
+		if symbol != RootSymbol {
+			symbols = append(append([]Symbol{}, symbols...), symbol)
+		}
+		for _, rule := range occursLeft[symbol] {
+			if !isDictionary {
+				return
+			}
+			ruleLogp := logp + math.Log(rule.Weight)
+			if !rule.Right[0].IsTerminal() {
+				walk(rule.Right[0], symbols, ruleLogp)
+				continue
+			}
+
+			tokens := []string{}
+			for _, terminal := range rule.Right {
+				tokens = append(tokens, terminal.Literal())
+			}
+			sentence := strings.Join(tokens, " ")
+			if phrase, ok := best[sentence]; !ok || ruleLogp > phrase.logp {
+				if !ok {
+					sentences = append(sentences, sentence)
+				}
+				best[sentence] = &_Phrase{
+					tokens: tokens,
+					symbols: symbols,
+					logp: ruleLogp,
+				}
+			}
+		}
+	}
+	walk(RootSymbol, []Symbol{}, 0)
+	if !isDictionary {
+		return nil
+	}
+
+	phrases := []*_Phrase{}
+	for _, sentence := range sentences {
+		phrases = append(phrases, best[sentence])
+	}
+	return phrases
+}
+
+// buildDictionary builds the trie of phrases for the fast path of parsing
+// dictionary grammar
+func (g *CNFGrammar) buildDictionary(phrases []*_Phrase) {
+	g.dictionary = &_TrieNode{}
+	for _, phrase := range phrases {
+		node := g.dictionary
+		for _, token := range phrase.tokens {
+			token = g.normalizeToken(token)
+			if node.children == nil {
+				node.children = map[string]*_TrieNode{}
+			}
+			child, ok := node.children[token]
+			if !ok {
+				child = &_TrieNode{}
+				node.children[token] = child
+			}
+			node = child
+		}
+		if node.phrase == nil || phrase.logp > node.phrase.logp {
+			node.phrase = phrase
+		}
+	}
+}
+
+// matchDictionary parses query with the trie of dictionary grammar. Returns
+// the same parsing tree as CYK, nil if not matched
+func (g *CNFGrammar) matchDictionary(query []string) *Candidate {
+	node := g.dictionary
+	for _, token := range query {
+		node = node.children[g.normalizeToken(token)]
+		if node == nil {
+			return nil
+		}
+	}
+	if node.phrase == nil {
+		return nil
+	}
+
+	treeNodes := []*Node{}
+	for i, token := range query {
+		treeNodes = append(treeNodes, &Node{
+			Symbol: token,
+			Separator: g.isSeparator(token),
+			Start: i,
+			End: i + 1,
+		})
+	}
+	for i := len(node.phrase.symbols) - 1; i >= 0; i-- {
+		symbol, ok := g.SymbolIds[string(node.phrase.symbols[i])]
+		if ok && isVisible(g, symbol) {
+			if g.atomics[symbol] {
+				treeNodes = []*Node{atomicLeaf(treeNodes)}
+			}
+			treeNodes = []*Node{{
+				Children: treeNodes,
+				Symbol: baseSymbolName(g.Symbols[symbol]),
+				Start: 0,
+				End: len(query),
+			}}
+		}
+	}
+	return &Candidate{
+		Tree: &Tree{
+			Node: &Node{
+				Children: treeNodes,
+				Symbol: string(RootSymbol),
+				Start: 0,
+				End: len(query),
+			},
+			LogProb: node.phrase.logp,
+		},
+		LogProb: node.phrase.logp,
+	}
+}
@@ -0,0 +1,97 @@
+package pcfg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const dictionaryGrammar = `
+<on> ::= turn on ; 2 | switch on
+<off> ::= turn off | shut down | switch on ; 0.1
+<power> ::= <on> ; 3 | <off>
+<root> ::= <power> | stop ; 0.5
+;!exports: <power> <off>`
+
+func TestDictionary(t *testing.T) {
+	parser, err := NewParser(dictionaryGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parser.cnfGrammar.dictionary == nil {
+		t.Fatal("dictionary grammar expected")
+	}
+
+	// Parser without the fast path
+	cykParser, err := NewParser(dictionaryGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cykParser.cnfGrammar.dictionary = nil
+
+	// TestCase-1: same trees as CYK
+	for _, query := range []string{"turn on", "shut down", "switch on", "stop", "turn", "turn on on"} {
+		tokens := strings.Fields(query)
+		candidate := parser.parse(tokens)
+		expected := cykParser.parse(tokens)
+		if candidate == nil && expected == nil {
+			continue
+		}
+		if candidate == nil || expected == nil || !candidate.Tree.Equal(expected.Tree) {
+			t.Fatalf("%s: '%v' != '%v'", query, candidate, expected)
+		}
+		if diff := candidate.LogProb - expected.LogProb; diff > probTolerance || diff < -probTolerance {
+			t.Fatalf("%s: %f != %f", query, candidate.LogProb, expected.LogProb)
+		}
+	}
+
+	// TestCase-2: not a dictionary grammar
+	for _, grammarText := range []string{
+		"<a> ::= x <b>\n<b> ::= y\n<root> ::= <a>",
+		"<a> ::= x | <root>\n<root> ::= <a>",
+		"<a> ::= x | <nil>\n<root> ::= <a>",
+	} {
+		parser, err := NewParser(grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parser.cnfGrammar.dictionary != nil {
+			t.Fatalf("'%s' is not a dictionary grammar", grammarText)
+		}
+	}
+}
+
+// newDictionaryParser creates the parser of a flat grammar with 2000 phrases
+func newDictionaryParser(b *testing.B) *Parser {
+	phrases := []string{}
+	for i := 0; i < 2000; i++ {
+		phrases = append(phrases, fmt.Sprintf("turn on light%d now", i))
+	}
+	parser, err := NewParser(fmt.Sprintf(`
+		<intent> ::= %s
+		<root> ::= <intent>
+		;!exports: <intent>`, strings.Join(phrases, " | ")))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return parser
+}
+
+func BenchmarkDictionary(b *testing.B) {
+	parser := newDictionaryParser(b)
+	query := strings.Fields("turn on light42 now")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.Parse(query)
+	}
+}
+
+func BenchmarkDictionaryCYK(b *testing.B) {
+	parser := newDictionaryParser(b)
+	parser.cnfGrammar.dictionary = nil
+	query := strings.Fields("turn on light42 now")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.Parse(query)
+	}
+}
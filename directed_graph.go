@@ -1,7 +1,10 @@
 package pcfg
 
 import (
+	"fmt"
 	"math"
+	"sort"
+	"strings"
 )
 
 // Vertex in graoh
@@ -62,18 +65,35 @@ func (g *DirectedGraph) DFS(s Vertex, visited map[Vertex]bool) []Vertex {
 	return order
 }
 
-// TopologicalSort sorts the graph by topological order
+// TopologicalSort sorts the graph by topological order, which is the reversed
+// post-order of DFS. For graphs with cycles, vertices are sorted by their
+// finishing time in DFS descendingly, as Kosaraju's algorithm requires
 func (g *DirectedGraph) TopologicalSort() []Vertex {
 	visited := map[Vertex]bool{}
-	topologicalOrder := []Vertex{}
+	postOrder := []Vertex{}
 	for v := range g.Vertices {
-		if !visited[v] {
-			topologicalOrder = append(g.DFS(v, visited), topologicalOrder...)
-		}
+		postOrder = g.postOrder(v, visited, postOrder)
+	}
+	topologicalOrder := make([]Vertex, len(postOrder))
+	for i, v := range postOrder {
+		topologicalOrder[len(postOrder) - 1 - i] = v
 	}
 	return topologicalOrder
 }
 
+// postOrder runs depth-first search from s like DFS, and appends the vertices
+// visited to order when they are finished
+func (g *DirectedGraph) postOrder(s Vertex, visited map[Vertex]bool, order []Vertex) []Vertex {
+	if visited[s] || !g.Vertices[s] {
+		return order
+	}
+	visited[s] = true
+	for t := range g.Arcs[s] {
+		order = g.postOrder(t, visited, order)
+	}
+	return append(order, s)
+}
+
 
 // Transpose returns the reversed graph of g
 func (g *DirectedGraph) Transpose() *DirectedGraph {
@@ -110,9 +130,21 @@ func (g *DirectedGraph) StrongComponents() [][]Vertex {
 // Floyd finds the weight of shortest path between each vertices using
 // Floyd–Warshall algorithm
 func (g *DirectedGraph) Floyd() map[Vertex]map[Vertex]float64 {
-	distance := map[Vertex]map[Vertex]float64{}
+	distance, _ := g.FloydPaths()
+	return distance
+}
+
+// FloydPaths finds the shortest paths like Floyd. Besides the weights, it
+// returns next[s][t], which is the vertex after s in the shortest path from s
+// to t. See ShortestPath
+func (g *DirectedGraph) FloydPaths() (
+	distance map[Vertex]map[Vertex]float64,
+	next map[Vertex]map[Vertex]Vertex) {
+	distance = map[Vertex]map[Vertex]float64{}
+	next = map[Vertex]map[Vertex]Vertex{}
 	for s, _ := range g.Vertices {
 		distance[s] = map[Vertex]float64{}
+		next[s] = map[Vertex]Vertex{}
 		for t, _ := range g.Vertices {
 			if s == t {
 				distance[s][t] = 0
@@ -125,6 +157,7 @@ func (g *DirectedGraph) Floyd() map[Vertex]map[Vertex]float64 {
 	for s, ts := range g.Arcs {
 		for t, w := range ts {
 			distance[s][t] = w
+			next[s][t] = t
 		}
 	}
 
@@ -135,10 +168,58 @@ func (g *DirectedGraph) Floyd() map[Vertex]map[Vertex]float64 {
 				d := distance[i][k] + distance[k][j]
 				if distance[i][j] > d {
 					distance[i][j] = d
+					next[i][j] = next[i][k]
 				}
 			}
 		}
 	}
 
-	return distance
+	return
+}
+
+// ShortestPath returns the vertices in shortest path from s to t, excluding s
+// and including t, using next from FloydPaths. Returns nil if t is not
+// reachable from s
+func ShortestPath(next map[Vertex]map[Vertex]Vertex, s, t Vertex) []Vertex {
+	path := []Vertex{}
+	for s != t {
+		v, ok := next[s][t]
+		if !ok {
+			return nil
+		}
+		path = append(path, v)
+		s = v
+	}
+	return path
+}
+
+// DOT returns the graph in Graphviz DOT format. Vertices and arcs are sorted
+// so that the output is deterministic
+func (g *DirectedGraph) DOT() string {
+	vertices := []string{}
+	for v := range g.Vertices {
+		vertices = append(vertices, string(v))
+	}
+	sort.Strings(vertices)
+
+	lines := []string{"digraph G {"}
+	for _, v := range vertices {
+		lines = append(lines, fmt.Sprintf("  %q;", v))
+	}
+	for _, s := range vertices {
+		targets := []string{}
+		for t := range g.Arcs[Vertex(s)] {
+			targets = append(targets, string(t))
+		}
+		sort.Strings(targets)
+		for _, t := range targets {
+			lines = append(lines, fmt.Sprintf(
+				"  %q -> %q [label=\"%g\"];",
+				s,
+				t,
+				g.Arcs[Vertex(s)][Vertex(t)]))
+		}
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n") + "\n"
 }
@@ -0,0 +1,363 @@
+package pcfg
+
+import (
+	"math"
+	"sort"
+)
+
+// EditCosts stores the penalties of edits in error-correcting parsing. Each
+// penalty is added to the log-probability of parsing tree once per edit, so
+// it should be negative. math.Inf(-1) disables the edit
+type EditCosts struct {
+	// Penalty of inserting a terminal missing in query
+	Insertion float64
+
+	// Penalty of deleting a token in query
+	Deletion float64
+
+	// Penalty of substituting a token in query by a terminal in grammar
+	Substitution float64
+}
+
+// _EditType is the type of edit of a node in CYK table
+type _EditType int32
+
+const (
+	_EditNone _EditType = iota
+
+	// One of left and right is the leaf node of deleted token, the other one
+	// is the node kept. rule is nil
+	_EditDeletion
+
+	// left is the leaf node of substituted token, word is the terminal
+	_EditSubstitution
+
+	// A zero-width node of terminal rule, left is nil and word is the terminal
+	_EditInsertion
+)
+
+// _EditContext stores the data used by error-correcting parsing
+type _EditContext struct {
+	costs EditCosts
+
+	// Symbols that derive a terminal and the best terminal rule of them.
+	// symbols is sorted to make parsing deterministic
+	symbols []int
+	bestTerminals map[int]*CNFTerminalRule
+
+	// Zero-width insertion node of each symbol in symbols
+	insertions map[int]*_CYKNode
+
+	// Rules A -> BC indexed by B and by C
+	rulesByFirst map[int][]*CNFRule
+	rulesBySecond map[int][]*CNFRule
+
+	constraints *_Constraints
+}
+
+// newEditContext creates a new instance of _EditContext for grammar. Positive
+// penalties are treated as 0
+func newEditContext(grammar *CNFGrammar, costs EditCosts, constraints *_Constraints) *_EditContext {
+	costs.Insertion = math.Min(costs.Insertion, 0)
+	costs.Deletion = math.Min(costs.Deletion, 0)
+	costs.Substitution = math.Min(costs.Substitution, 0)
+	context := &_EditContext{
+		costs: costs,
+		symbols: []int{},
+		bestTerminals: map[int]*CNFTerminalRule{},
+		insertions: map[int]*_CYKNode{},
+		rulesByFirst: map[int][]*CNFRule{},
+		rulesBySecond: map[int][]*CNFRule{},
+		constraints: constraints,
+	}
+
+	for _, rules := range grammar.TerminalRules {
+		for _, rule := range rules {
+			best, ok := context.bestTerminals[rule.Source]
+			if !ok {
+				context.symbols = append(context.symbols, rule.Source)
+			}
+			if !ok || rule.LogProbability > best.LogProbability ||
+				rule.LogProbability == best.LogProbability &&
+				rule.TerminalTarget < best.TerminalTarget {
+				context.bestTerminals[rule.Source] = rule
+			}
+		}
+	}
+	sort.Ints(context.symbols)
+
+	if !math.IsInf(costs.Insertion, -1) {
+		for _, symbol := range context.symbols {
+			rule := context.bestTerminals[symbol]
+			features, ok := constraints.combine(symbol, &rule.CNFRuleBase)
+			if !ok {
+				continue
+			}
+			context.insertions[symbol] = &_CYKNode{
+				symbol: symbol,
+				rule: &rule.CNFRuleBase,
+				logp: rule.LogProbability + Prob(costs.Insertion),
+				edit: _EditInsertion,
+				word: rule.TerminalTarget,
+				edits: 1,
+				features: features,
+			}
+		}
+
+		for _, secondRules := range grammar.Rules {
+			for _, rules := range secondRules {
+				for _, rule := range rules {
+					context.rulesByFirst[rule.FirstTarget] = append(
+						context.rulesByFirst[rule.FirstTarget],
+						rule)
+					context.rulesBySecond[rule.SecondTarget] = append(
+						context.rulesBySecond[rule.SecondTarget],
+						rule)
+				}
+			}
+		}
+	}
+	return context
+}
+
+// addSubstitutions adds the nodes substituting token in leaf into the head of
+// nodes, one for each symbol that not matched by the token already
+func (c *_EditContext) addSubstitutions(
+	pool *_NodePool,
+	nodes *_CYKNode,
+	leaf *_CYKNode,
+	tok string) *_CYKNode {
+	if math.IsInf(c.costs.Substitution, -1) {
+		return nodes
+	}
+
+	matched := map[int]bool{}
+	for node := nodes; node != nil; node = node.next {
+		matched[node.symbol] = true
+	}
+	for _, symbol := range c.symbols {
+		rule := c.bestTerminals[symbol]
+		if matched[symbol] || rule.TerminalTarget == tok {
+			continue
+		}
+		features, ok := c.constraints.combine(symbol, &rule.CNFRuleBase)
+		if !ok {
+			continue
+		}
+		node := pool.Get()
+		node.features = features
+		node.symbol = symbol
+		node.rule = &rule.CNFRuleBase
+		node.logp = rule.LogProbability + Prob(c.costs.Substitution)
+		node.left = leaf
+		node.edit = _EditSubstitution
+		node.word = rule.TerminalTarget
+		node.edits = 1
+		node.next = nodes
+		nodes = node
+	}
+	return nodes
+}
+
+// addDeletions adds the deletion nodes into the head of nodes. For each
+// symbol, the best node of kept is kept and leaf is deleted. If leafFirst is
+// true, the deleted leaf is before the kept node
+func (c *_EditContext) addDeletions(
+	pool *_NodePool,
+	nodes *_CYKNode,
+	kept *_CYKNode,
+	leaf *_CYKNode,
+	leafFirst bool) *_CYKNode {
+	if math.IsInf(c.costs.Deletion, -1) {
+		return nodes
+	}
+
+	best := map[int]*_CYKNode{}
+	symbols := []int{}
+	for ; kept != nil; kept = kept.next {
+		b, ok := best[kept.symbol]
+		if !ok || kept.logp > b.logp || kept.logp == b.logp && compareOrder(kept, b) < 0 {
+			if !ok {
+				symbols = append(symbols, kept.symbol)
+			}
+			best[kept.symbol] = kept
+		}
+	}
+
+	for _, symbol := range symbols {
+		node := pool.Get()
+		node.symbol = symbol
+		node.logp = best[symbol].logp + Prob(c.costs.Deletion)
+		node.edit = _EditDeletion
+		node.edits = best[symbol].edits + 1
+		node.features = best[symbol].features
+		if leafFirst {
+			node.left, node.right = leaf, best[symbol]
+		} else {
+			node.left, node.right = best[symbol], leaf
+		}
+		node.next = nodes
+		nodes = node
+	}
+	return nodes
+}
+
+// addInsertions adds the nodes combining a node in cell with an insertion node
+// before or after it, A -> (inserted B) C or A -> B (inserted C), into the head
+// of nodes. New nodes are combined again until no symbol gets a better
+// log-probability in the cell
+func (c *_EditContext) addInsertions(pool *_NodePool, nodes *_CYKNode) *_CYKNode {
+	if len(c.insertions) == 0 {
+		return nodes
+	}
+
+	best := map[int]Prob{}
+	frontier := []*_CYKNode{}
+	for node := nodes; node != nil; node = node.next {
+		if logp, ok := best[node.symbol]; !ok || node.logp > logp {
+			best[node.symbol] = node.logp
+		}
+		frontier = append(frontier, node)
+	}
+
+	combine := func(rule *CNFRule, left, right *_CYKNode) {
+		logp := rule.LogProbability + left.logp + right.logp
+		if current, ok := best[rule.Source]; ok && logp <= current {
+			return
+		}
+		features, ok := c.constraints.combine(rule.Source, &rule.CNFRuleBase, left, right)
+		if !ok {
+			return
+		}
+		node := pool.Get()
+		node.features = features
+		node.symbol = rule.Source
+		node.rule = &rule.CNFRuleBase
+		node.logp = logp
+		node.left = left
+		node.right = right
+		node.edits = left.edits + right.edits
+		node.next = nodes
+		nodes = node
+
+		best[rule.Source] = logp
+		frontier = append(frontier, node)
+	}
+
+	for len(frontier) > 0 {
+		node := frontier[0]
+		frontier = frontier[1:]
+		for _, rule := range c.rulesBySecond[node.symbol] {
+			if inserted, ok := c.insertions[rule.FirstTarget]; ok {
+				combine(rule, inserted, node)
+			}
+		}
+		for _, rule := range c.rulesByFirst[node.symbol] {
+			if inserted, ok := c.insertions[rule.SecondTarget]; ok {
+				combine(rule, node, inserted)
+			}
+		}
+	}
+	return nodes
+}
+
+// pruneNodes keeps only the best node of each symbol in nodes. The order of
+// kept nodes is not changed
+func pruneNodes(nodes *_CYKNode) *_CYKNode {
+	best := map[int]*_CYKNode{}
+	for node := nodes; node != nil; node = node.next {
+		b, ok := best[node.symbol]
+		if !ok || node.logp > b.logp || node.logp == b.logp && compareOrder(node, b) < 0 {
+			best[node.symbol] = node
+		}
+	}
+
+	var head, tail *_CYKNode
+	for node := nodes; node != nil; node = node.next {
+		if best[node.symbol] != node {
+			continue
+		}
+		if tail == nil {
+			head = node
+		} else {
+			tail.next = node
+		}
+		tail = node
+	}
+	if tail != nil {
+		tail.next = nil
+	}
+	return head
+}
+
+// addFuzzyMatches returns the nodes of terminal rules whose terminal within
+// maxDistance edit distance of token in leaf. The log-probability is
+// penalized by the distance
+func addFuzzyMatches(
+	grammar *CNFGrammar,
+	pool *_NodePool,
+	leaf *_CYKNode,
+	token string,
+	maxDistance int,
+	constraints *_Constraints) *_CYKNode {
+	terminals := []string{}
+	for terminal := range grammar.TerminalRules {
+		terminals = append(terminals, terminal)
+	}
+	sort.Strings(terminals)
+
+	var nodes *_CYKNode
+	for _, terminal := range terminals {
+		distance := editDistance(token, terminal)
+		if distance > maxDistance {
+			continue
+		}
+		for _, rule := range grammar.TerminalRules[terminal] {
+			features, ok := constraints.combine(rule.Source, &rule.CNFRuleBase)
+			if !ok {
+				continue
+			}
+			node := pool.Get()
+			node.features = features
+			node.symbol = rule.Source
+			node.rule = &rule.CNFRuleBase
+			node.logp = rule.LogProbability - Prob(distance)
+			node.left = leaf
+			node.edit = _EditSubstitution
+			node.word = terminal
+			node.next = nodes
+			nodes = node
+		}
+	}
+	return nodes
+}
+
+// editDistance returns the Levenshtein distance between the runes of a and b
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	row := make([]int, len(t) + 1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i - 1] == t[j - 1] {
+				cost = 0
+			}
+			current := row[j]
+			distance := diagonal + cost
+			if row[j] + 1 < distance {
+				distance = row[j] + 1
+			}
+			if row[j - 1] + 1 < distance {
+				distance = row[j - 1] + 1
+			}
+			row[j] = distance
+			diagonal = current
+		}
+	}
+	return row[len(t)]
+}
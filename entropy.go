@@ -0,0 +1,88 @@
+package pcfg
+
+import (
+	"math"
+)
+
+// Parameters of the fixed-point iteration in DerivationEntropy
+const (
+	_EntropyMaxIterations = 10000
+	_EntropyTolerance = 1e-12
+)
+
+// Entropy returns the entropy in bits of the rule distribution of each source
+// symbol, -sum(p * log2(p)) over the rules from it. A symbol with a single
+// rule has entropy 0
+func (g *CNFGrammar) Entropy() map[string]float64 {
+	entropy := map[string]float64{}
+	for _, rule := range g.allRules() {
+		h := 0.0
+		if p := float64(rule.Probability); p > 0 {
+			h = -p * math.Log2(p)
+		}
+		entropy[g.Symbols[rule.Source]] += h
+	}
+	return entropy
+}
+
+// DerivationEntropy returns the entropy in bits of the derivations from
+// <root>, which is the expected sum of the rule entropies of the symbols
+// expanded in a derivation
+//     H(A) = Entropy(A) + sum(p(A -> BC) * (H(B) + H(C)))
+// It's solved by fixed-point iteration. Returns +Inf if it doesn't converge,
+// e.g. the expected size of derivations is infinite, and 0 if there's no
+// <root>
+func (g *CNFGrammar) DerivationEntropy() float64 {
+	rootId, ok := g.SymbolIds[string(RootSymbol)]
+	if !ok {
+		return 0
+	}
+	ruleEntropy := make([]float64, len(g.Symbols))
+	for name, h := range g.Entropy() {
+		ruleEntropy[g.SymbolIds[name]] = h
+	}
+	binaryRules := []*CNFRule{}
+	for _, secondRules := range g.Rules {
+		for _, rules := range secondRules {
+			binaryRules = append(binaryRules, rules...)
+		}
+	}
+
+	entropy := append([]float64{}, ruleEntropy...)
+	for i := 0; i < _EntropyMaxIterations; i++ {
+		next := append([]float64{}, ruleEntropy...)
+		for _, rule := range binaryRules {
+			next[rule.Source] += float64(rule.Probability) *
+				(entropy[rule.FirstTarget] + entropy[rule.SecondTarget])
+		}
+		converged := true
+		for symbol := range next {
+			if math.Abs(next[symbol] - entropy[symbol]) > _EntropyTolerance {
+				converged = false
+			}
+		}
+		entropy = next
+		if converged {
+			return entropy[rootId]
+		}
+	}
+	return math.Inf(1)
+}
+
+// allRules returns the bases of all terminal and binary rules of g
+func (g *CNFGrammar) allRules() []*CNFRuleBase {
+	rules := []*CNFRuleBase{}
+	for _, terminalRules := range g.TerminalRules {
+		for _, rule := range terminalRules {
+			rules = append(rules, &rule.CNFRuleBase)
+		}
+	}
+	for _, secondRules := range g.Rules {
+		for _, binaryRules := range secondRules {
+			for _, rule := range binaryRules {
+				rules = append(rules, &rule.CNFRuleBase)
+			}
+		}
+	}
+	return rules
+}
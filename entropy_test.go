@@ -0,0 +1,51 @@
+package pcfg
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	testCases := []struct {
+		grammar string
+		root float64
+		derivation float64
+	}{
+		// TestCase-1: uniform two-way choice is 1 bit
+		{"<root> ::= a | b", 1, 1},
+
+		// TestCase-2: two independent choices
+		{"<a> ::= x | y\n<root> ::= <a> <a>", 0, 2},
+
+		// TestCase-3: four-way choice with probabilities 1/2, 1/4, 1/8, 1/8
+		{"<root> ::= a ; 4 | b ; 2 | c ; 1 | d ; 1", 1.75, 1.75},
+
+		// TestCase-4: recursion with geometric number of steps,
+		// H = 1 + 0.5 * H
+		{"<root> ::= <root> x | x", 1, 2},
+	}
+	for _, testCase := range testCases {
+		grammar, err := ParseGrammar(testCase.grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cnfGrammar := grammar.ConvertToCNF()
+		entropy := cnfGrammar.Entropy()[string(RootSymbol)]
+		if math.Abs(entropy - testCase.root) > 1e-9 {
+			t.Fatalf("%s: %f != %f", testCase.grammar, entropy, testCase.root)
+		}
+		derivation := cnfGrammar.DerivationEntropy()
+		if math.Abs(derivation - testCase.derivation) > 1e-9 {
+			t.Fatalf("%s: %f != %f", testCase.grammar, derivation, testCase.derivation)
+		}
+	}
+
+	// TestCase-5: infinite derivations
+	grammar, err := ParseGrammar("<root> ::= <root> <root> ; 0.9 | x ; 0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := grammar.ConvertToCNF().DerivationEntropy(); !math.IsInf(h, 1) {
+		t.Fatalf("+Inf expected, but got %f", h)
+	}
+}
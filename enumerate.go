@@ -0,0 +1,115 @@
+package pcfg
+
+import (
+	"sort"
+	"strings"
+)
+
+// WeightedSentence is a sentence with its probability
+type WeightedSentence struct {
+	Tokens []string
+
+	// Sum of the probabilities of all derivations of the sentence
+	Probability float64
+}
+
+// enumerateSpans returns the sentences derived from each symbol by length, up
+// to maxLen. spans[length][symbolId] maps sentence, stored as tokens joined by
+// space, to the sum of probabilities of its derivations
+func (g *CNFGrammar) enumerateSpans(maxLen int) []map[int]map[string]float64 {
+	spans := make([]map[int]map[string]float64, maxLen + 1)
+	for length := range spans {
+		spans[length] = map[int]map[string]float64{}
+	}
+	add := func(length, symbol int, sentence string, p float64) {
+		if spans[length][symbol] == nil {
+			spans[length][symbol] = map[string]float64{}
+		}
+		spans[length][symbol][sentence] += p
+	}
+
+	if maxLen < 1 {
+		return spans
+	}
+	for terminal, rules := range g.TerminalRules {
+		for _, rule := range rules {
+			add(1, rule.Source, terminal, float64(rule.Probability))
+		}
+	}
+
+	for length := 2; length <= maxLen; length++ {
+		for partition := 1; partition < length; partition++ {
+			for first, lefts := range spans[partition] {
+				for second, rights := range spans[length - partition] {
+					rules := g.Rules[first][second]
+					if len(rules) == 0 {
+						continue
+					}
+					for left, leftP := range lefts {
+						for right, rightP := range rights {
+							for _, rule := range rules {
+								add(
+									length,
+									rule.Source,
+									left + " " + right,
+									float64(rule.Probability) * leftP * rightP)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return spans
+}
+
+// Enumerate returns all sentences of <root> with no more than maxLen tokens,
+// ordered by length then alphabetically. The number of sentences could grow
+// exponentially with maxLen
+func (g *CNFGrammar) Enumerate(maxLen int) [][]string {
+	sentences := [][]string{}
+	for _, sentence := range g.Expand(string(RootSymbol), maxLen) {
+		sentences = append(sentences, sentence.Tokens)
+	}
+	return sentences
+}
+
+// Expand returns all sentences derived from symbol with no more than maxLen
+// tokens and their probabilities, ordered by length then alphabetically.
+// Symbols merged into the path of rules when converting to CNF (see
+// Rule.Path) have no rules from them, so that they expand to nothing
+func (g *CNFGrammar) Expand(symbol string, maxLen int) []WeightedSentence {
+	sentences := []WeightedSentence{}
+	symbolId, ok := g.SymbolIds[symbol]
+	if !ok {
+		return sentences
+	}
+
+	spans := g.enumerateSpans(maxLen)
+	for length := 1; length <= maxLen; length++ {
+		texts := []string{}
+		for text := range spans[length][symbolId] {
+			texts = append(texts, text)
+		}
+		sort.Strings(texts)
+		for _, text := range texts {
+			sentences = append(sentences, WeightedSentence{
+				Tokens: strings.Split(text, " "),
+				Probability: spans[length][symbolId][text],
+			})
+		}
+	}
+	return sentences
+}
+
+// Subsumes checks if a accepts every sentence with no more than maxLen tokens
+// that b accepts. It's exact only up to maxLen. If not, returns false with the
+// first sentence accepted by b but not a
+func (a *CNFGrammar) Subsumes(b *CNFGrammar, maxLen int) (bool, []string) {
+	for _, sentence := range b.Enumerate(maxLen) {
+		if CYK(a, sentence) == nil {
+			return false, sentence
+		}
+	}
+	return true, nil
+}
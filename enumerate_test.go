@@ -0,0 +1,92 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSubsumes(t *testing.T) {
+	wide, err := NewParser(`
+		<city> ::= seattle | beijing
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	narrow, err := NewParser(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"weather in beijing",
+		"weather in seattle",
+		"what's the weather in beijing",
+		"what's the weather in seattle",
+	}
+	sentences := wide.cnfGrammar.Enumerate(5)
+	if len(sentences) != len(expected) {
+		t.Fatalf("%d sentences expected, but got %v", len(expected), sentences)
+	}
+	for i, sentence := range sentences {
+		if strings.Join(sentence, " ") != expected[i] {
+			t.Fatalf("'%s' != '%s'", strings.Join(sentence, " "), expected[i])
+		}
+	}
+
+	// TestCase-1: wide subsumes narrow
+	ok, counterexample := wide.cnfGrammar.Subsumes(narrow.cnfGrammar, 5)
+	if !ok || counterexample != nil {
+		t.Fatalf("wide should subsume narrow, but got %v", counterexample)
+	}
+
+	// TestCase-2: narrow doesn't subsume wide
+	ok, counterexample = narrow.cnfGrammar.Subsumes(wide.cnfGrammar, 5)
+	if ok || strings.Join(counterexample, " ") != "what's the weather in beijing" {
+		t.Fatalf("counterexample expected, but got %v", counterexample)
+	}
+
+	// TestCase-3: it's exact only up to maxLen
+	ok, _ = narrow.cnfGrammar.Subsumes(wide.cnfGrammar, 4)
+	if !ok {
+		t.Fatal("narrow should subsume wide up to 4 tokens")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle ; 0.6 | new york ; 0.4
+		<place> ::= <city> ; 0.5 | downtown <city> ; 0.25 | home ; 0.25
+		<root> ::= weather in <place>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+
+	sentences := cnfGrammar.Expand("<place>", 3)
+	expected := []WeightedSentence{
+		{[]string{"home"}, 0.25},
+		{[]string{"seattle"}, 0.3},
+		{[]string{"downtown", "seattle"}, 0.15},
+		{[]string{"new", "york"}, 0.2},
+		{[]string{"downtown", "new", "york"}, 0.1},
+	}
+	if len(sentences) != len(expected) {
+		t.Fatalf("%d != %d", len(sentences), len(expected))
+	}
+	for i, sentence := range sentences {
+		text := strings.Join(sentence.Tokens, " ")
+		expectedText := strings.Join(expected[i].Tokens, " ")
+		if text != expectedText || math.Abs(sentence.Probability - expected[i].Probability) > probTolerance {
+			t.Fatalf("'%s' %f != '%s' %f", text, sentence.Probability, expectedText, expected[i].Probability)
+		}
+	}
+
+	// Unknown symbol
+	if sentences := cnfGrammar.Expand("<unknown>", 3); len(sentences) != 0 {
+		t.Fatalf("no sentences expected, but got %v", sentences)
+	}
+}
@@ -0,0 +1,201 @@
+package pcfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Explanation explains why the best parsing tree is chosen over the next best
+// one
+type Explanation struct {
+	Best *Tree
+	BestLogProb float64
+
+	// The best tree different from Best, nil if there is no other tree
+	Alternative *Tree
+	AlternativeLogProb float64
+
+	// Contributions only in one of the trees, ordered by span. The gap of
+	// log-probability equals the sum of LogProb in Best minus the sum of
+	// LogProb in Alternative
+	Differences []Contribution
+}
+
+// Contribution is the log-probability contributed by a rule in CNF grammar
+// over a span of query in a parsing tree
+type Contribution struct {
+	// Span [Start, End) of query
+	Start int
+	End int
+
+	// Rule in CNF grammar, like "<a> ::= <b> <c>", and the symbols merged into
+	// it (see Rule.Path)
+	Rule string
+	Path []string
+
+	LogProb float64
+
+	// True if it's in Best only, false if in Alternative only
+	InBest bool
+}
+
+// _ContributionKey identifies a contribution in a derivation
+type _ContributionKey struct {
+	start, end int
+	rule *CNFRuleBase
+	text string
+	logp float64
+}
+
+// Explain parses query and explains the choice of best tree by comparing it
+// with the next best tree. Returns nil if query doesn't match grammar
+func (p *Parser) Explain(query []string) *Explanation {
+	grammar := p.cnfGrammar
+	startId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || len(query) == 0 {
+		return nil
+	}
+
+	// All derivations are needed, so the table shouldn't be pruned
+	options := p.options
+	options.compactForest = false
+	table := buildTable(grammar, query, &options)
+	if table == nil {
+		return nil
+	}
+	roots := findRoots(table, startId)
+	if len(roots) == 0 {
+		return nil
+	}
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].node.logp > roots[j].node.logp
+	})
+
+	best := roots[0]
+	explanation := &Explanation{
+		Best: p.stripTree(constructStartTree(grammar, best, RootSymbol, options.withRules, query)),
+		BestLogProb: float64(best.node.logp),
+		Differences: []Contribution{},
+	}
+	for _, root := range roots[1: ] {
+		tree := p.stripTree(constructStartTree(grammar, root, RootSymbol, options.withRules, query))
+		if tree.Equal(explanation.Best) {
+			continue
+		}
+		explanation.Alternative = tree
+		explanation.AlternativeLogProb = float64(root.node.logp)
+		explanation.Differences = diffContributions(
+			grammar,
+			query,
+			contributions(grammar, query, best.node),
+			contributions(grammar, query, root.node))
+		break
+	}
+	return explanation
+}
+
+// contributions returns the contributions of nodes in the derivation of node,
+// with the counts of each
+func contributions(grammar *CNFGrammar, query []string, node *_CYKNode) map[_ContributionKey]int {
+	counts := map[_ContributionKey]int{}
+	var walk func(node *_CYKNode, start int) int
+	walk = func(node *_CYKNode, start int) int {
+		if node == nil {
+			return start
+		}
+		if node.symbol < 0 {
+			return start + 1
+		}
+		end := start
+		if node.edit != _EditInsertion {
+			end = walk(node.left, start)
+		}
+		end = walk(node.right, end)
+		if node.rule != nil {
+			// The log-probability of node excluding its children, which
+			// includes the penalties like fuzzy matching
+			logp := node.logp
+			if node.left != nil {
+				logp -= node.left.logp
+			}
+			if node.right != nil {
+				logp -= node.right.logp
+			}
+			key := _ContributionKey{
+				start: start,
+				end: end,
+				rule: node.rule,
+				text: ruleText(grammar, query, node),
+				logp: float64(logp),
+			}
+			counts[key]++
+		}
+		return end
+	}
+	walk(node, 0)
+	return counts
+}
+
+// ruleText returns the CNF rule of node in text, like "<a> ::= <b> <c>"
+func ruleText(grammar *CNFGrammar, query []string, node *_CYKNode) string {
+	targets := []string{}
+	if node.edit == _EditSubstitution || node.edit == _EditInsertion {
+		targets = append(targets, node.word)
+	} else if node.left.symbol < 0 {
+		targets = append(targets, query[-node.left.symbol - 1])
+	} else {
+		targets = append(targets, grammar.Symbols[node.left.symbol])
+	}
+	if node.right != nil {
+		targets = append(targets, grammar.Symbols[node.right.symbol])
+	}
+	return fmt.Sprintf(
+		"%s ::= %s",
+		grammar.Symbols[node.symbol],
+		strings.Join(targets, " "))
+}
+
+// diffContributions returns the contributions only in best or only in
+// alternative, ordered by span
+func diffContributions(
+	grammar *CNFGrammar,
+	query []string,
+	best map[_ContributionKey]int,
+	alternative map[_ContributionKey]int) []Contribution {
+	differences := []Contribution{}
+	add := func(a, b map[_ContributionKey]int, inBest bool) {
+		for key, count := range a {
+			for i := b[key]; i < count; i++ {
+				path := []string{}
+				for _, symbol := range key.rule.Path {
+					path = append(path, grammar.Symbols[symbol])
+				}
+				differences = append(differences, Contribution{
+					Start: key.start,
+					End: key.end,
+					Rule: key.text,
+					Path: path,
+					LogProb: key.logp,
+					InBest: inBest,
+				})
+			}
+		}
+	}
+	add(best, alternative, true)
+	add(alternative, best, false)
+	sort.Slice(differences, func(i, j int) bool {
+		a, b := differences[i], differences[j]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		if a.End != b.End {
+			return a.End > b.End
+		}
+		if a.InBest != b.InBest {
+			return a.InBest
+		}
+		return a.Rule < b.Rule
+	})
+	return differences
+}
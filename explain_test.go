@@ -0,0 +1,65 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	// "see man with telescope" attaches <pp> to <vp> or <np>
+	parser, err := NewParser(`
+		<pp> ::= with <n>
+		<n> ::= man | telescope
+		<np> ::= <n> ; 0.8 | <n> <pp> ; 0.2
+		<vp> ::= see <np> ; 0.4 | see <np> <pp> ; 0.6
+		<root> ::= <vp>
+		;!exports: <np> <pp>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explanation := parser.Explain(strings.Fields("see man with telescope"))
+	if explanation == nil || explanation.Alternative == nil {
+		t.Fatal("explanation with alternative expected")
+	}
+
+	// TestCase-1: <pp> attached to <vp>
+	expected := "(<root> \n  see \n  (<np> \n    man) \n  (<pp> \n    with \n    telescope))"
+	if explanation.Best.String() != expected {
+		t.Fatalf("'%s' != '%s'", explanation.Best.String(), expected)
+	}
+
+	// TestCase-2: the gap equals the sum of differences
+	gap := 0.0
+	for _, contribution := range explanation.Differences {
+		if contribution.InBest {
+			gap += contribution.LogProb
+		} else {
+			gap -= contribution.LogProb
+		}
+	}
+	if math.Abs(gap - (explanation.BestLogProb - explanation.AlternativeLogProb)) > probTolerance {
+		t.Fatalf("%f != %f", gap, explanation.BestLogProb - explanation.AlternativeLogProb)
+	}
+
+	// TestCase-3: the decisive rule <np> ::= <n> <pp> over "man with telescope"
+	found := false
+	for _, contribution := range explanation.Differences {
+		if !contribution.InBest &&
+			contribution.Start == 1 &&
+			contribution.End == 4 &&
+			contribution.Rule == "<np> ::= <n> <pp>" &&
+			math.Abs(contribution.LogProb - math.Log(0.2)) < probTolerance {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("<np> ::= <n> <pp> expected in differences, but got %v", explanation.Differences)
+	}
+
+	// TestCase-4: single parse
+	explanation = parser.Explain(strings.Fields("see man"))
+	if explanation == nil || explanation.Alternative != nil || len(explanation.Differences) != 0 {
+		t.Fatalf("explanation without alternative expected, but got %v", explanation)
+	}
+}
@@ -0,0 +1,159 @@
+package pcfg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fingerprint returns a stable hash of grammar, over its rules, weights,
+// exports, priors and the options of conversion. It's independent of the
+// order of rules, so grammars with the same rules in different order have the
+// same fingerprint
+func (g *Grammar) Fingerprint() string {
+	lines := []string{}
+	for _, rule := range g.Rules {
+		lines = append(lines, "rule " + rule.Canonical())
+	}
+	for symbol, exported := range g.Exports {
+		if exported {
+			lines = append(lines, "export " + string(symbol))
+		}
+	}
+	for symbol := range g.atomics {
+		lines = append(lines, "atomic " + string(symbol))
+	}
+	for symbol, alpha := range g.priors {
+		lines = append(lines, fmt.Sprintf("prior %s %s", symbol, formatFloat(alpha)))
+	}
+	lines = append(
+		lines,
+		fmt.Sprintf("factor-prefixes %v", g.factorPrefixes),
+		fmt.Sprintf("preserve-distribution %v", g.preserveDistribution),
+		fmt.Sprintf("normalize-unicode %v", g.normalizeUnicode),
+		fmt.Sprintf("case-insensitive %v", g.caseInsensitive),
+		"max-ambiguity " + formatFloat(g.maxAmbiguity),
+		"separator " + g.separatorToken(),
+		"temperature " + formatFloat(g.temperature),
+		"min-probability " + formatFloat(g.minProbability))
+	return fingerprintLines(lines)
+}
+
+// _FingerprintRule is a rule of CNFGrammar, either binary or terminal, used by
+// CNFGrammar.Fingerprint
+type _FingerprintRule struct {
+	*CNFRuleBase
+	targets []int
+	terminal string
+}
+
+// Fingerprint returns a stable hash of CNF grammar, over its rules,
+// probabilities, paths, orders and exports by symbol names. It's independent
+// of the symbol ids and the order in which rules are stored, but not of the
+// orders of rules in grammar text (see Rule.Order), which break the ties of
+// parsing. Internal symbols are numbered in the order of conversion, so
+// they're named by the hash of their own rules instead
+func (g *CNFGrammar) Fingerprint() string {
+	rules := []_FingerprintRule{}
+	for _, terminalRules := range g.TerminalRules {
+		for _, rule := range terminalRules {
+			rules = append(rules, _FingerprintRule{
+				CNFRuleBase: &rule.CNFRuleBase,
+				terminal: rule.TerminalTarget,
+			})
+		}
+	}
+	for _, secondRules := range g.Rules {
+		for _, binaryRules := range secondRules {
+			for _, rule := range binaryRules {
+				rules = append(rules, _FingerprintRule{
+					CNFRuleBase: &rule.CNFRuleBase,
+					targets: []int{rule.FirstTarget, rule.SecondTarget},
+				})
+			}
+		}
+	}
+	bySource := map[int][]_FingerprintRule{}
+	for _, rule := range rules {
+		bySource[rule.Source] = append(bySource[rule.Source], rule)
+	}
+
+	// name returns the name of symbol in fingerprint, and body returns the
+	// right side of rule with the names. Internal symbols in a cycle keep
+	// their own names
+	names := map[int]string{}
+	var name func(symbolId int) string
+	body := func(rule _FingerprintRule) string {
+		targets := []string{}
+		for _, target := range rule.targets {
+			targets = append(targets, name(target))
+		}
+		if rule.targets == nil {
+			targets = append(targets, rule.terminal)
+		}
+		path := []string{}
+		for _, symbolId := range rule.Path {
+			path = append(path, name(symbolId))
+		}
+		return fmt.Sprintf(
+			"%s ; %s (%s) #%d",
+			strings.Join(targets, " "),
+			formatFloat(float64(rule.Probability)),
+			strings.Join(path, " "),
+			rule.Order)
+	}
+	name = func(symbolId int) string {
+		symbol := g.Symbols[symbolId]
+		if !strings.HasPrefix(symbol, "<__") {
+			return symbol
+		}
+		if n, ok := names[symbolId]; ok {
+			return n
+		}
+		names[symbolId] = symbol
+		lines := []string{}
+		for _, rule := range bySource[symbolId] {
+			lines = append(lines, body(rule))
+		}
+		names[symbolId] = "<__" + fingerprintLines(lines)[: 16] + ">"
+		return names[symbolId]
+	}
+
+	lines := []string{}
+	for _, rule := range rules {
+		lines = append(lines, name(rule.Source) + " ::= " + body(rule))
+	}
+	for symbolId, exported := range g.Exports {
+		if exported {
+			lines = append(lines, "export " + name(symbolId))
+		}
+	}
+	for symbolId := range g.atomics {
+		lines = append(lines, "atomic " + name(symbolId))
+	}
+	lines = append(
+		lines,
+		fmt.Sprintf("normalize-unicode %v", g.normalizeUnicode),
+		fmt.Sprintf("case-insensitive %v", g.caseInsensitive),
+		"separator " + g.separator)
+	return fingerprintLines(lines)
+}
+
+// fingerprintLines returns the hex SHA-256 of lines after sorting them
+func fingerprintLines(lines []string) string {
+	sort.Strings(lines)
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// formatFloat formats f in full precision
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
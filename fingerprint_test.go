@@ -0,0 +1,74 @@
+package pcfg
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	fingerprints := func(grammarText string) (string, string) {
+		grammar, err := ParseGrammar(grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprint := grammar.Fingerprint()
+		return fingerprint, grammar.ConvertToCNF().Fingerprint()
+	}
+	g1, c1 := fingerprints(`
+		<city> ::= seattle | beijing ; 2
+		<weather> ::= weather in <city> | <city> weather
+		<root> ::= <weather>
+		;!exports: <weather> <city>`)
+
+	// TestCase-1: same grammar with rules, alternatives and exports reordered.
+	// The orders of rules break ties in parsing, so that the CNF grammar differs
+	g2, c2 := fingerprints(`
+		<root> ::= <weather>
+		<weather> ::= <city> weather | weather in <city>
+		<city> ::= beijing ; 2 | seattle
+		;!exports: <city> <weather>`)
+	if g1 != g2 {
+		t.Fatalf("'%s' != '%s'", g1, g2)
+	}
+	if c1 == c2 {
+		t.Fatalf("'%s' == '%s'", c1, c2)
+	}
+
+	// TestCase-2: only exports reordered
+	g2, c2 = fingerprints(`
+		<city> ::= seattle | beijing ; 2
+		<weather> ::= weather in <city> | <city> weather
+		<root> ::= <weather>
+		;!exports: <city> <weather>`)
+	if g1 != g2 {
+		t.Fatalf("'%s' != '%s'", g1, g2)
+	}
+	if c1 != c2 {
+		t.Fatalf("'%s' != '%s'", c1, c2)
+	}
+
+	// TestCase-3: weight changed
+	g3, c3 := fingerprints(`
+		<city> ::= seattle | beijing ; 3
+		<weather> ::= weather in <city> | <city> weather
+		<root> ::= <weather>
+		;!exports: <weather> <city>`)
+	if g1 == g3 {
+		t.Fatalf("'%s' == '%s'", g1, g3)
+	}
+	if c1 == c3 {
+		t.Fatalf("'%s' == '%s'", c1, c3)
+	}
+
+	// TestCase-4: exports changed
+	g4, c4 := fingerprints(`
+		<city> ::= seattle | beijing ; 2
+		<weather> ::= weather in <city> | <city> weather
+		<root> ::= <weather>
+		;!exports: <weather>`)
+	if g1 == g4 {
+		t.Fatalf("'%s' == '%s'", g1, g4)
+	}
+	if c1 == c4 {
+		t.Fatalf("'%s' == '%s'", c1, c4)
+	}
+}
@@ -0,0 +1,111 @@
+package pcfg
+
+// EndSymbol marks the end of input in FOLLOW sets. It's not a valid symbol in
+// grammar text, so that it never conflicts with terminals
+const EndSymbol = Symbol("<$>")
+
+// First computes the FIRST set of each non-terminal symbol in the rules, which
+// are the terminals that could begin a sequence derived from it. EpsilonSymbol
+// is in the set if the symbol is nullable. It should be called before
+// ConvertToCNF, which rewrites rules
+func (g *Grammar) First() map[Symbol]map[Symbol]bool {
+	nullables := g.findNullables()
+	first := map[Symbol]map[Symbol]bool{}
+	for _, rule := range g.Rules {
+		first[rule.Left] = map[Symbol]bool{}
+	}
+	for symbol, p := range nullables {
+		if p > 0 {
+			first[symbol][EpsilonSymbol] = true
+		}
+	}
+
+	// Iterate until no set changes
+	for changed := true; changed; {
+		changed = false
+		for _, rule := range g.Rules {
+			for terminal := range firstOfSequence(rule.Right, first, nullables) {
+				if terminal != EpsilonSymbol && !first[rule.Left][terminal] {
+					first[rule.Left][terminal] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return first
+}
+
+// firstOfSequence returns the FIRST set of a sequence of symbols, including
+// EpsilonSymbol if the whole sequence is nullable
+func firstOfSequence(
+	symbols []Symbol,
+	first map[Symbol]map[Symbol]bool,
+	nullables map[Symbol]float64) map[Symbol]bool {
+	set := map[Symbol]bool{}
+	for _, symbol := range symbols {
+		if symbol == EpsilonSymbol {
+			continue
+		}
+		if symbol.IsTerminal() {
+			set[symbol] = true
+			return set
+		}
+		for terminal := range first[symbol] {
+			if terminal != EpsilonSymbol {
+				set[terminal] = true
+			}
+		}
+		if !(nullables[symbol] > 0) {
+			return set
+		}
+	}
+	set[EpsilonSymbol] = true
+	return set
+}
+
+// Follow computes the FOLLOW set of each non-terminal symbol in the rules,
+// which are the terminals that could come right after it in a sentence
+// derived from <root>. EndSymbol is in the set if the symbol could end a
+// sentence. It should be called before ConvertToCNF, which rewrites rules
+func (g *Grammar) Follow() map[Symbol]map[Symbol]bool {
+	nullables := g.findNullables()
+	first := g.First()
+	follow := map[Symbol]map[Symbol]bool{}
+	for _, rule := range g.Rules {
+		follow[rule.Left] = map[Symbol]bool{}
+	}
+	follow[RootSymbol] = map[Symbol]bool{EndSymbol: true}
+
+	// Iterate until no set changes
+	for changed := true; changed; {
+		changed = false
+		add := func(symbol, terminal Symbol) {
+			if _, ok := follow[symbol]; !ok {
+				follow[symbol] = map[Symbol]bool{}
+			}
+			if !follow[symbol][terminal] {
+				follow[symbol][terminal] = true
+				changed = true
+			}
+		}
+		for _, rule := range g.Rules {
+			for i, symbol := range rule.Right {
+				if symbol.IsTerminal() {
+					continue
+				}
+				rest := firstOfSequence(rule.Right[i + 1: ], first, nullables)
+				for terminal := range rest {
+					if terminal != EpsilonSymbol {
+						add(symbol, terminal)
+					}
+				}
+				if rest[EpsilonSymbol] {
+					for terminal := range follow[rule.Left] {
+						add(symbol, terminal)
+					}
+				}
+			}
+		}
+	}
+	return follow
+}
@@ -0,0 +1,57 @@
+package pcfg
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// symbolSet returns the symbols in set sorted and joined by space
+func symbolSet(set map[Symbol]bool) string {
+	symbols := []string{}
+	for symbol := range set {
+		symbols = append(symbols, string(symbol))
+	}
+	sort.Strings(symbols)
+	return strings.Join(symbols, " ")
+}
+
+func TestFirstAndFollow(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<root> ::= <t> <e2>
+		<e2> ::= + <t> <e2> | <nil>
+		<t> ::= <f> <t2>
+		<t2> ::= * <f> <t2> | <nil>
+		<f> ::= ( <root> ) | id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: FIRST
+	first := grammar.First()
+	for symbol, expected := range map[Symbol]string{
+		"<root>": "( id",
+		"<e2>": "+ <nil>",
+		"<t>": "( id",
+		"<t2>": "* <nil>",
+		"<f>": "( id",
+	} {
+		if symbolSet(first[symbol]) != expected {
+			t.Fatalf("FIRST(%s): '%s' != '%s'", symbol, symbolSet(first[symbol]), expected)
+		}
+	}
+
+	// TestCase-2: FOLLOW
+	follow := grammar.Follow()
+	for symbol, expected := range map[Symbol]string{
+		"<root>": ") <$>",
+		"<e2>": ") <$>",
+		"<t>": ") + <$>",
+		"<t2>": ") + <$>",
+		"<f>": ") * + <$>",
+	} {
+		if symbolSet(follow[symbol]) != expected {
+			t.Fatalf("FOLLOW(%s): '%s' != '%s'", symbol, symbolSet(follow[symbol]), expected)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+// _Derivation is all the derivations of a symbol or a sequence of symbols
+// over a span of sentence, packed by their total probability and the expected
+// number of times each rule is used
+type _Derivation struct {
+	// Log of the total probability (inside probability)
+	logp float64
+
+	// Expected count of each rule, given the span is derived
+	counts map[*Rule]float64
+}
+
+// sumDerivations sums up the alternative derivations, whose expected counts
+// are weighted by their probabilities. Returns nil if there is none
+func sumDerivations(alternatives []*_Derivation) *_Derivation {
+	if len(alternatives) == 0 {
+		return nil
+	}
+	maxLogp := math.Inf(-1)
+	for _, alternative := range alternatives {
+		maxLogp = math.Max(maxLogp, alternative.logp)
+	}
+	if math.IsInf(maxLogp, -1) {
+		return &_Derivation{logp: maxLogp, counts: map[*Rule]float64{}}
+	}
+	total := 0.0
+	for _, alternative := range alternatives {
+		total += math.Exp(alternative.logp - maxLogp)
+	}
+	sum := &_Derivation{
+		logp: maxLogp + math.Log(total),
+		counts: map[*Rule]float64{},
+	}
+	for _, alternative := range alternatives {
+		posterior := math.Exp(alternative.logp - maxLogp) / total
+		for rule, count := range alternative.counts {
+			sum.counts[rule] += posterior * count
+		}
+	}
+	return sum
+}
+
+// _DerivationKey is the key for memorizing derivations. For symbols, rule is
+// nil. For sequences, it's the right side of rule starting from pos
+type _DerivationKey struct {
+	symbol Symbol
+	rule *Rule
+	pos int
+	begin, end int
+}
+
+// _Deriver sums up the derivations over the original (non-CNF) grammar, like
+// the inside algorithm with the expected counts of rules
+type _Deriver struct {
+	occurs map[Symbol][]*Rule
+	logWeights map[*Rule]float64
+	tokens []string
+	memo map[_DerivationKey]*_Derivation
+	inProgress map[_DerivationKey]bool
+
+	// Number of times a derivation was cut by inProgress. Derivations found
+	// during cutting are incomplete, so that they won't be memorized. The
+	// derivations through cycles are never counted
+	cuts int
+}
+
+// newDeriver creates a new instance of _Deriver for tokens
+func newDeriver(g *Grammar, tokens []string) *_Deriver {
+	weights := map[Symbol]float64{}
+	for _, rule := range g.Rules {
+		weights[rule.Left] += rule.Weight
+	}
+	logWeights := map[*Rule]float64{}
+	for _, rule := range g.Rules {
+		logWeights[rule] = math.Log(rule.Weight / weights[rule.Left])
+	}
+
+	return &_Deriver{
+		occurs: g.occursLeft(),
+		logWeights: logWeights,
+		tokens: tokens,
+		memo: map[_DerivationKey]*_Derivation{},
+		inProgress: map[_DerivationKey]bool{},
+	}
+}
+
+// derive returns the derivations from symbol or the right side of rule
+// (starting from pos) to tokens[begin: end]. Returns nil if not derivable
+func (d *_Deriver) derive(key _DerivationKey) *_Derivation {
+	if derivation, ok := d.memo[key]; ok {
+		return derivation
+	}
+	if d.inProgress[key] {
+		// A cycle, like A -> B, B -> A
+		d.cuts++
+		return nil
+	}
+	d.inProgress[key] = true
+	cuts := d.cuts
+
+	var best *_Derivation
+	if key.rule == nil {
+		best = d.deriveSymbol(key)
+	} else {
+		best = d.deriveSequence(key)
+	}
+
+	delete(d.inProgress, key)
+	if cuts == d.cuts {
+		d.memo[key] = best
+	}
+	return best
+}
+
+// deriveSymbol sums up the derivations of key.symbol
+func (d *_Deriver) deriveSymbol(key _DerivationKey) *_Derivation {
+	symbol := key.symbol
+	if symbol == EpsilonSymbol {
+		if key.begin == key.end {
+			return &_Derivation{counts: map[*Rule]float64{}}
+		}
+		return nil
+	}
+	if symbol.IsTerminal() {
+		if key.end == key.begin + 1 && d.tokens[key.begin] == symbol.Literal() {
+			return &_Derivation{counts: map[*Rule]float64{}}
+		}
+		return nil
+	}
+
+	alternatives := []*_Derivation{}
+	for _, rule := range d.occurs[symbol] {
+		derivation := d.derive(_DerivationKey{
+			rule: rule,
+			begin: key.begin,
+			end: key.end,
+		})
+		if derivation == nil {
+			continue
+		}
+		counts := map[*Rule]float64{rule: 1}
+		for r, count := range derivation.counts {
+			counts[r] += count
+		}
+		alternatives = append(alternatives, &_Derivation{
+			logp: d.logWeights[rule] + derivation.logp,
+			counts: counts,
+		})
+	}
+	return sumDerivations(alternatives)
+}
+
+// deriveSequence sums up the derivations of key.rule.Right[key.pos: ]
+func (d *_Deriver) deriveSequence(key _DerivationKey) *_Derivation {
+	right := key.rule.Right
+	if key.pos == len(right) {
+		if key.begin == key.end {
+			return &_Derivation{counts: map[*Rule]float64{}}
+		}
+		return nil
+	}
+
+	alternatives := []*_Derivation{}
+	for split := key.begin; split <= key.end; split++ {
+		first := d.derive(_DerivationKey{
+			symbol: right[key.pos],
+			begin: key.begin,
+			end: split,
+		})
+		if first == nil {
+			continue
+		}
+		rest := d.derive(_DerivationKey{
+			rule: key.rule,
+			pos: key.pos + 1,
+			begin: split,
+			end: key.end,
+		})
+		if rest == nil {
+			continue
+		}
+		counts := map[*Rule]float64{}
+		for rule, count := range first.counts {
+			counts[rule] += count
+		}
+		for rule, count := range rest.counts {
+			counts[rule] += count
+		}
+		alternatives = append(alternatives, &_Derivation{
+			logp: first.logp + rest.logp,
+			counts: counts,
+		})
+	}
+	return sumDerivations(alternatives)
+}
+
+// FitToSentences re-estimates the rule weights from a target distribution over
+// sentences, by one step of expectation-maximization. The expected count of
+// each rule over all derivations of a sentence (under the current weights, like
+// the inside-outside algorithm) is weighted by the probability of sentence.
+// Then the weight of rule is set to its fraction of count among the rules with
+// the same source symbol. Symbols never used keep their weights, and the
+// derivations through cycles of rules like A -> B, B -> A are not counted. It
+// should be called before ConvertToCNF, which rewrites rules
+func (g *Grammar) FitToSentences(weighted map[string]float64) error {
+	// Iterate sentences in fixed order to make the result deterministic
+	sentences := []string{}
+	for sentence := range weighted {
+		sentences = append(sentences, sentence)
+	}
+	sort.Strings(sentences)
+
+	counts := map[*Rule]float64{}
+	for _, sentence := range sentences {
+		tokens := strings.Fields(sentence)
+		deriver := newDeriver(g, tokens)
+		derivation := deriver.derive(_DerivationKey{
+			symbol: RootSymbol,
+			begin: 0,
+			end: len(tokens),
+		})
+		if derivation == nil {
+			return errors.New(fmt.Sprintf(
+				"FitToSentences: '%s' doesn't match grammar",
+				sentence))
+		}
+		for rule, count := range derivation.counts {
+			counts[rule] += weighted[sentence] * count
+		}
+	}
+
+	totals := map[Symbol]float64{}
+	for rule, count := range counts {
+		totals[rule.Left] += count
+	}
+	for _, rule := range g.Rules {
+		if totals[rule.Left] > 0 {
+			rule.Weight = counts[rule] / totals[rule.Left]
+		}
+	}
+	return nil
+}
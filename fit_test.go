@@ -0,0 +1,68 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFitToSentences(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle | beijing
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = grammar.FitToSentences(map[string]float64{
+		"what's the weather in seattle": 0.75,
+		"weather in beijing": 0.25,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []float64{0.75, 0.25, 0.75, 0.25, 1.0}
+	for i, rule := range grammar.Rules {
+		if math.Abs(rule.Weight - expected[i]) > 1e-9 {
+			t.Fatalf("%s: weight %f expected", rule.String(), expected[i])
+		}
+	}
+
+	// Failed case
+	err = grammar.FitToSentences(map[string]float64{"seattle weather": 1.0})
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
+
+func TestFitToSentencesExpectedCounts(t *testing.T) {
+	// "x" is derived from both <a> and <b>, so that the counts are split by
+	// the posterior probabilities of derivations rather than given to the best
+	grammar, err := ParseGrammar(`
+		<a> ::= x
+		<b> ::= x | y
+		<root> ::= <a> ; 0.6 | <b> ; 0.4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = grammar.FitToSentences(map[string]float64{"x": 0.5, "y": 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// <b> derives x with 0.4 * 0.5 = 0.2, versus 0.6 for <a>
+	expected := map[string]float64{
+		"<a> ::= x": 1.0,
+		"<b> ::= x": 0.125 / 0.625,
+		"<b> ::= y": 0.5 / 0.625,
+		"<root> ::= <a>": 0.375,
+		"<root> ::= <b>": 0.625,
+	}
+	for _, rule := range grammar.Rules {
+		text := strings.Split(rule.String(), " ; ")[0]
+		if math.Abs(rule.Weight - expected[text]) > 1e-9 {
+			t.Fatalf("%s: weight %f expected", rule.String(), expected[text])
+		}
+	}
+}
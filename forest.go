@@ -0,0 +1,256 @@
+package pcfg
+
+// Forest is the packed parse forest of query, where all derivations of a
+// symbol over the same span of tokens share one ForestNode. It's built from the
+// CYK table, whose nodes are the derivations themselves, so that the forest is
+// polynomial in the length of query while the derivations may be exponential
+type Forest struct {
+	// Derivations of <root> from the whole query, nil if not matched
+	Root *ForestNode
+
+	grammar *CNFGrammar
+	query []string
+	stripRoot bool
+}
+
+// ForestNode is an OR-node of forest, the derivations of Symbol spanning the
+// tokens [Start, Start + Length) of query by alternative rules or partitions
+type ForestNode struct {
+	Symbol string
+	Start int
+	Length int
+	Alternatives []*ForestAlternative
+}
+
+// ForestAlternative is an AND-node of forest, a derivation by the CNF rule Rule.
+// Left and Right are nil for terminal rules, which derive the token at Start
+type ForestAlternative struct {
+	Rule *CNFRuleBase
+	Left *ForestNode
+	Right *ForestNode
+
+	// Node in CYK table of the derivation, whose children are replaced when
+	// enumerating trees
+	node *_CYKNode
+
+	// Index of the symbol of ForestNode in Rule.Path, -1 for Rule.Source
+	pathIndex int
+}
+
+// _ForestKey identifies a ForestNode by the symbol and span
+type _ForestKey struct {
+	symbol int
+	start int
+	length int
+}
+
+// _AlternativeKey identifies an alternative of ForestNode. The nodes in CYK
+// table with the same rule and partition differ in the derivations of children
+// only, which are packed into the children ForestNodes
+type _AlternativeKey struct {
+	rule *CNFRuleBase
+	leftLength int
+	word string
+}
+
+// ParseForest parses query and returns the packed forest of all derivations
+// of <root>. Like ParseNBest, the derivations differing only in the symbols not
+// exported have the same tree. Forest.Root is nil if query doesn't match
+func (p *Parser) ParseForest(query []string) *Forest {
+	options := p.options
+	options.compactForest = false
+	options.stats = nil
+	forest := buildForest(p.cnfGrammar, query, &options)
+	forest.stripRoot = p.stripRoot
+	return forest
+}
+
+// buildForest builds the forest of query from the CYK table built with options
+func buildForest(grammar *CNFGrammar, query []string, options *_ParseOptions) *Forest {
+	forest := &Forest{grammar: grammar, query: query}
+	rootId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || len(query) == 0 {
+		return forest
+	}
+	table := buildTable(grammar, query, options)
+	if table == nil {
+		return forest
+	}
+	roots := findRoots(table, rootId)
+	if len(roots) == 0 {
+		return forest
+	}
+
+	// Span length of each node in table, as in DumpChart
+	lengths := map[*_CYKNode]int{}
+	for length := 1; length < len(table); length++ {
+		for _, node := range table[length] {
+			for ; node != nil; node = node.next {
+				lengths[node] = length
+			}
+		}
+	}
+
+	// Forest nodes are expanded with a work stack, each of them once
+	forestNodes := map[_ForestKey]*ForestNode{}
+	stack := []*ForestNode{}
+	forestNode := func(symbol int, start int, length int) *ForestNode {
+		key := _ForestKey{symbol, start, length}
+		if n, ok := forestNodes[key]; ok {
+			return n
+		}
+		n := &ForestNode{
+			Symbol: grammar.Symbols[symbol],
+			Start: start,
+			Length: length,
+			Alternatives: []*ForestAlternative{},
+		}
+		forestNodes[key] = n
+		stack = append(stack, n)
+		return n
+	}
+	addAlternative := func(
+		n *ForestNode,
+		seen map[_AlternativeKey]bool,
+		node *_CYKNode,
+		pathIndex int) {
+		key := _AlternativeKey{rule: node.rule, word: node.word}
+		if node.right != nil {
+			key.leftLength = lengths[node.left]
+		}
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		alternative := &ForestAlternative{Rule: node.rule, node: node, pathIndex: pathIndex}
+		if node.right != nil {
+			alternative.Left = forestNode(node.left.symbol, n.Start, key.leftLength)
+			alternative.Right = forestNode(
+				node.right.symbol,
+				n.Start + key.leftLength,
+				n.Length - key.leftLength)
+		}
+		n.Alternatives = append(n.Alternatives, alternative)
+	}
+
+	// The root has the alternatives with <root> in the path of rule as well
+	forest.Root = forestNode(rootId, 0, len(query))
+	stack = stack[: 0]
+	rootSeen := map[_AlternativeKey]bool{}
+	for _, root := range roots {
+		addAlternative(forest.Root, rootSeen, root.node, root.pathIndex)
+	}
+	for len(stack) != 0 {
+		n := stack[len(stack) - 1]
+		stack = stack[: len(stack) - 1]
+		seen := map[_AlternativeKey]bool{}
+		for node := table[n.Length][n.Start]; node != nil; node = node.next {
+			if grammar.Symbols[node.symbol] == n.Symbol {
+				addAlternative(n, seen, node, -1)
+			}
+		}
+	}
+	return forest
+}
+
+// CountDerivations returns the number of derivations in forest without
+// enumerating them. It's not the number of distinct parsing trees, since the
+// derivations differing only in the symbols not exported have the same tree.
+// The count is float64 since it grows exponentially in ambiguous grammars
+func (f *Forest) CountDerivations() float64 {
+	if f.Root == nil {
+		return 0
+	}
+
+	// Post-order traversal with a work stack, counts of children first
+	counts := map[*ForestNode]float64{}
+	stack := []*ForestNode{f.Root}
+	for len(stack) != 0 {
+		n := stack[len(stack) - 1]
+		if _, ok := counts[n]; ok {
+			stack = stack[: len(stack) - 1]
+			continue
+		}
+		pending := false
+		for _, alternative := range n.Alternatives {
+			for _, child := range []*ForestNode{alternative.Left, alternative.Right} {
+				if _, ok := counts[child]; child != nil && !ok {
+					stack = append(stack, child)
+					pending = true
+				}
+			}
+		}
+		if pending {
+			continue
+		}
+		count := 0.0
+		for _, alternative := range n.Alternatives {
+			if alternative.Left == nil {
+				count++
+			} else {
+				count += counts[alternative.Left] * counts[alternative.Right]
+			}
+		}
+		counts[n] = count
+		stack = stack[: len(stack) - 1]
+	}
+	return counts[f.Root]
+}
+
+// ForEachTree calls visit on each distinct parsing tree in forest, until visit
+// returns false. Like ParseNBest, the derivations with the same tree are
+// visited once, by the first of them. The trees are constructed one by one, in
+// the order of alternatives from the root, so that stopping early skips the rest
+func (f *Forest) ForEachTree(visit func(*Tree) bool) {
+	if f.Root == nil {
+		return
+	}
+	seen := map[string]bool{}
+	for _, alternative := range f.Root.Alternatives {
+		ok := f.derive(alternative, func(node *_CYKNode) bool {
+			root := _RootNode{node, alternative.pathIndex}
+			tree := constructStartTree(f.grammar, root, RootSymbol, false, f.query)
+			if f.stripRoot && len(tree.Children) == 1 {
+				tree = &Tree{Node: tree.Children[0], LogProb: tree.LogProb}
+			}
+			text := tree.String()
+			if seen[text] {
+				return true
+			}
+			seen[text] = true
+			return visit(tree)
+		})
+		if !ok {
+			return
+		}
+	}
+}
+
+// derive calls visit on each derivation of alternative as a node like the ones
+// in CYK table. Returns false if visit returned false
+func (f *Forest) derive(alternative *ForestAlternative, visit func(*_CYKNode) bool) bool {
+	if alternative.Left == nil {
+		return visit(alternative.node)
+	}
+	return f.deriveNode(alternative.Left, func(left *_CYKNode) bool {
+		return f.deriveNode(alternative.Right, func(right *_CYKNode) bool {
+			node := *alternative.node
+			node.left = left
+			node.right = right
+			node.next = nil
+			node.logp = alternative.node.rule.LogProbability + left.logp + right.logp
+			return visit(&node)
+		})
+	})
+}
+
+// deriveNode calls visit on each derivation of n. Returns false if visit
+// returned false
+func (f *Forest) deriveNode(n *ForestNode, visit func(*_CYKNode) bool) bool {
+	for _, alternative := range n.Alternatives {
+		if !f.derive(alternative, visit) {
+			return false
+		}
+	}
+	return true
+}
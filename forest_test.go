@@ -0,0 +1,88 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseForest(t *testing.T) {
+	parser, err := NewParser(`
+		<np> ::= <np> <np> ; 0.5 | a ; 0.5
+		<root> ::= <np>
+		;!exports: <np>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: the number of binary bracketings is the Catalan number,
+	// while the forest has a node for each span
+	query := strings.Fields("a a a a a a a a a a")
+	forest := parser.ParseForest(query)
+	if forest.Root == nil {
+		t.Fatal("forest.Root != nil expected")
+	}
+	if forest.CountDerivations() != 4862 {
+		t.Fatalf("%f != 4862", forest.CountDerivations())
+	}
+	if count := CountParses(parser.cnfGrammar, query); forest.CountDerivations() != count {
+		t.Fatalf("%f != %f", forest.CountDerivations(), count)
+	}
+	if forest.Root.Length != 10 || len(forest.Root.Alternatives) != 9 {
+		t.Fatalf("span 10 with 9 alternatives expected, but got %d, %d",
+			forest.Root.Length,
+			len(forest.Root.Alternatives))
+	}
+
+	// TestCase-2: enumerated trees are distinct, and the enumeration stops
+	// when visit returns false
+	forest = parser.ParseForest(strings.Fields("a a a a"))
+	seen := map[string]bool{}
+	forest.ForEachTree(func(tree *Tree) bool {
+		seen[tree.String()] = true
+		return true
+	})
+	if len(seen) != 5 || forest.CountDerivations() != 5 {
+		t.Fatalf("%d != 5", len(seen))
+	}
+	best := parser.Parse(strings.Fields("a a a a"))
+	if !seen[best.String()] {
+		t.Fatalf("'%v' not enumerated", best)
+	}
+	visited := 0
+	forest.ForEachTree(func(tree *Tree) bool {
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Fatalf("%d != 2", visited)
+	}
+
+	// TestCase-3: derivations differing only in the symbols not exported have
+	// the same tree, which is visited once
+	parser, err = NewParser(`
+		<a> ::= x
+		<b> ::= x
+		<root> ::= <a> | <b>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forest = parser.ParseForest([]string{"x"})
+	visited = 0
+	forest.ForEachTree(func(tree *Tree) bool {
+		visited++
+		return true
+	})
+	if visited != 1 || forest.CountDerivations() != 2 {
+		t.Fatalf("%d, %f != 1, 2", visited, forest.CountDerivations())
+	}
+
+	// TestCase-4: not matched
+	forest = parser.ParseForest(strings.Fields("a b"))
+	if forest.Root != nil || forest.CountDerivations() != 0 {
+		t.Fatal("empty forest expected")
+	}
+	forest.ForEachTree(func(tree *Tree) bool {
+		t.Fatal("no tree expected")
+		return false
+	})
+}
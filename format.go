@@ -0,0 +1,102 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// FormatGrammar formats grammar text into the canonical form:
+//   - Rules are re-emitted with single spaces between symbols
+//   - "::=" of consecutive rules are aligned
+//   - Weights are shown with 3 decimals, and omitted when it's 1.0
+//   - Comments and directives are kept, runs of blank lines are merged
+// Formatting the output again yields the same text
+func FormatGrammar(text string) (string, error) {
+	output := []string{}
+
+	// Rules in current block, aligned when the block ends
+	blockLefts := []string{}
+	blockRights := []string{}
+	flushBlock := func() {
+		width := 0
+		for _, left := range blockLefts {
+			if len(left) > width {
+				width = len(left)
+			}
+		}
+		for i, left := range blockLefts {
+			output = append(output, fmt.Sprintf(
+				"%-*s ::= %s",
+				width,
+				left,
+				blockRights[i]))
+		}
+		blockLefts = []string{}
+		blockRights = []string{}
+	}
+
+	for lineIdx, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		// Blank lines
+		if line == "" {
+			flushBlock()
+			if len(output) != 0 && output[len(output) - 1] != "" {
+				output = append(output, "")
+			}
+			continue
+		}
+
+		// Directives and comments
+		if strings.Index(line, ";!exports:") == 0 {
+			flushBlock()
+			exports := strings.Fields(line[len(";!exports:"):])
+			output = append(output, ";!exports: " + strings.Join(exports, " "))
+			continue
+		}
+		if line[0] == ';' {
+			flushBlock()
+			output = append(output, line)
+			continue
+		}
+
+		rules, err := ParseRule(line)
+		if err != nil {
+			return "", errors.Wrapf(err, "FormatGrammar: line %d", lineIdx + 1)
+		}
+		alternatives := []string{}
+		for _, rule := range rules {
+			alternatives = append(alternatives, formatAlternative(rule))
+		}
+		blockLefts = append(blockLefts, string(rules[0].Left))
+		blockRights = append(blockRights, strings.Join(alternatives, " | "))
+	}
+	flushBlock()
+
+	// Remove the trailing blank line
+	if len(output) != 0 && output[len(output) - 1] == "" {
+		output = output[: len(output) - 1]
+	}
+	if len(output) == 0 {
+		return "", nil
+	}
+	return strings.Join(output, "\n") + "\n", nil
+}
+
+// formatAlternative formats the right side and weight of rule in canonical
+// form, like "weather in <city> ; 0.300"
+func formatAlternative(rule *Rule) string {
+	symbols := []string{}
+	for _, symbol := range rule.Right {
+		symbols = append(symbols, string(symbol))
+	}
+	s := strings.Join(symbols, " ")
+
+	// Compare after rounding, so that formatting is idempotent
+	weight := fmt.Sprintf("%.3f", rule.Weight)
+	if weight != "1.000" {
+		s += " ; " + weight
+	}
+	return s
+}
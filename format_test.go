@@ -0,0 +1,47 @@
+package pcfg
+
+import (
+	"testing"
+)
+
+func TestFormatGrammar(t *testing.T) {
+	messy := `
+
+		; Cities
+		<city>::=seattle|  beijing ;0.5
+		<whats> ::=   what's   the ; 0.99999 | <nil>;0.2
+
+
+		<root>  ::= <whats> weather in <city>
+		;!exports:   <city>
+	`
+	expected := `; Cities
+<city>  ::= seattle | beijing ; 0.500
+<whats> ::= what's the | <nil> ; 0.200
+
+<root> ::= <whats> weather in <city>
+;!exports: <city>
+`
+	formatted, err := FormatGrammar(messy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != expected {
+		t.Fatalf("'%s' != '%s'", formatted, expected)
+	}
+
+	// Formatting twice yields the same output
+	formattedTwice, err := FormatGrammar(formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formattedTwice != formatted {
+		t.Fatalf("'%s' != '%s'", formattedTwice, formatted)
+	}
+
+	// Failed case
+	_, err = FormatGrammar("<city> ::= <seattle")
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
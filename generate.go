@@ -0,0 +1,68 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math/rand"
+)
+
+// Randomized features take an explicit *rand.Rand instead of using the global
+// source of math/rand, so that the results are reproducible with the same
+// seed
+
+// Generate samples a sentence from <root> of grammar. For each non-terminal
+// symbol, a rule is chosen by the weights of rules from it. Returns error if
+// the derivation is deeper than maxDepth. It should be called before
+// ConvertToCNF, which rewrites rules
+func (g *Grammar) Generate(rng *rand.Rand, maxDepth int) ([]string, error) {
+	occurs := g.occursLeft()
+	sentence := []string{}
+
+	var generate func(symbol Symbol, depth int) error
+	generate = func(symbol Symbol, depth int) error {
+		if symbol == EpsilonSymbol {
+			return nil
+		}
+		if symbol.IsTerminal() {
+			sentence = append(sentence, symbol.Literal())
+			return nil
+		}
+		if depth > maxDepth {
+			return errors.New(fmt.Sprintf(
+				"Generate: derivation deeper than %d",
+				maxDepth))
+		}
+
+		rules := occurs[symbol]
+		if len(rules) == 0 {
+			return errors.New(fmt.Sprintf(
+				"Generate: symbol not defined: %s",
+				symbol))
+		}
+		total := 0.0
+		for _, rule := range rules {
+			total += rule.Weight
+		}
+		r := rng.Float64() * total
+		chosen := rules[len(rules) - 1]
+		for _, rule := range rules {
+			if r < rule.Weight {
+				chosen = rule
+				break
+			}
+			r -= rule.Weight
+		}
+
+		for _, s := range chosen.Right {
+			if err := generate(s, depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := generate(RootSymbol, 0); err != nil {
+		return nil, err
+	}
+	return sentence, nil
+}
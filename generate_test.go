@@ -0,0 +1,61 @@
+package pcfg
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle | beijing | shanghai
+		<time> ::= today | tomorrow | <nil>
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <city> <time>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// generate samples n sentences with seed
+	generate := func(seed int64, n int) []string {
+		rng := rand.New(rand.NewSource(seed))
+		sentences := []string{}
+		for i := 0; i < n; i++ {
+			sentence, err := grammar.Generate(rng, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sentences = append(sentences, strings.Join(sentence, " "))
+		}
+		return sentences
+	}
+
+	// The same seed produces identical output
+	first := generate(42, 20)
+	second := generate(42, 20)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("'%s' != '%s'", first[i], second[i])
+		}
+	}
+
+	// Generated sentences should match the grammar
+	parser, err := NewParserFromGrammar(grammar.clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sentence := range first {
+		if parser.Parse(strings.Fields(sentence)) == nil {
+			t.Fatalf("'%s' doesn't match grammar", sentence)
+		}
+	}
+
+	// Failed case: too deep
+	grammar, err = ParseGrammar("<root> ::= a <root>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = grammar.Generate(rand.New(rand.NewSource(1)), 10); err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
@@ -1,11 +1,17 @@
 package pcfg
 
 import (
+	"bufio"
 	"fmt"
 	"strings"
 	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
 	"math"
 	"log"
+	"path/filepath"
+	"strconv"
 )
 
 // Grammar consists a list of PCFG rules
@@ -13,51 +19,546 @@ type Grammar struct {
 	Rules []*Rule
 	Exports map[Symbol]bool
 	isDebug bool
+
+	// If share the binarization of rules with common prefixes
+	factorPrefixes bool
+
+	// Vocabulary for the symbol ids of CNF grammar, nil if not shared
+	vocabulary *Vocabulary
+
+	// Maximum ambiguity accepted by NewParserFromGrammar, 0 for no limit
+	maxAmbiguity float64
+
+	// If normalize terminals and query tokens to NFC
+	normalizeUnicode bool
+
+	// If match terminals and query tokens case-insensitively, see
+	// Parser.SetCaseInsensitive
+	caseInsensitive bool
+
+	// Minimum probability of rules after normalization, 0 for no floor
+	minProbability float64
+
+	// Concentration parameters of symmetric Dirichlet priors, from the
+	// ;!prior: command
+	priors map[Symbol]float64
+
+	// Atomic symbols from the ;!atomic: command, nil if none
+	atomics map[Symbol]bool
+
+	// Receives the rule changes in ConvertToCNF, nil if disabled
+	auditLog func(event AuditEvent)
+
+	// Cache of occursLeft and occursRight, nil if not cached
+	occurs *_OccursIndex
+
+	// Index of rules by left symbols for NormalizeSymbol, built lazily
+	leftIndex *_LeftIndex
+
+	// Directory to resolve the relative paths in commands like ;!gazetteer:,
+	// empty for the working directory
+	baseDir string
+
+	// Absolute paths of the files being parsed, from the outermost to the
+	// innermost, to detect the cycles of ;!include:
+	includes []string
+
+	// If remove null rules exactly, see PreserveDistribution
+	preserveDistribution bool
+
+	// Report of the last conversion to CNF
+	report ConversionReport
+
+	// Token derived from <SEP>, empty for the default, see Grammar.Separator
+	separator string
+
+	// Temperature applied on weights in conversion, 0 for none, see
+	// ConvertToCNFTemperature
+	temperature float64
+}
+
+// ConversionReport reports the approximations made by converting grammar to
+// CNF
+type ConversionReport struct {
+	// If any step of conversion changed the total probability of rules from a
+	// symbol beyond floating tolerance, so that they are renormalized
+	Approximated bool
+
+	// The largest change of the total probability of rules from a symbol
+	// before renormalized, like the probability of null rules removed or of
+	// the cycles broken in strong components. 0 if not approximated
+	ProbabilityDrift float64
 }
 
+// Tolerance of the change of total probability in conversion
+const _DriftTolerance = 1e-9
+
+// Parameters of the fixed-point iteration in nullProbabilities
+const (
+	_NullMaxIterations = 10000
+	_NullTolerance = 1e-12
+)
+
 //
 // Here are the functions that used to convert PCFG to CNF
 // According to paper: http://www.cs.nyu.edu/courses/fall07/V22.0453-001/cnf.pdf
 //
 
-// ParseGrammar parses grammar from string
+// ParseGrammar parses grammar from string. The errors are wrapped with the
+// line number, like "grammar line 147: ParseRule: ..."
 func ParseGrammar(grammarText string) (grammar *Grammar, err error) {
-	grammar = &Grammar{
+	grammar = newGrammar()
+	lines := strings.Split(grammarText, "\n")
+	for lineIdx, line := range lines {
+		if err = grammar.parseLine(line, lineIdx + 1); err != nil {
+			err = errors.Wrapf(err, "grammar line %d", lineIdx + 1)
+			return
+		}
+	}
+	return
+}
+
+// ParseGrammarFile parses grammar from file. The relative paths in commands
+// like ;!gazetteer: are resolved against the directory of file
+func ParseGrammarFile(path string) (*Grammar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParseGrammarFile")
+	}
+	defer file.Close()
+	grammar := newGrammar()
+	grammar.baseDir = filepath.Dir(path)
+	if abs, err := filepath.Abs(path); err == nil {
+		grammar.includes = []string{abs}
+	}
+	if err := grammar.parseReader(file); err != nil {
+		return nil, err
+	}
+	return grammar, nil
+}
+
+// ParseGrammarReader parses grammar from r like ParseGrammar. The text is
+// read line by line, so that a large grammar is not buffered in whole
+func ParseGrammarReader(r io.Reader) (*Grammar, error) {
+	grammar := newGrammar()
+	if err := grammar.parseReader(r); err != nil {
+		return nil, err
+	}
+	return grammar, nil
+}
+
+// parseReader parses the lines from r and adds them into g
+func (g *Grammar) parseReader(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrap(err, "ParseGrammarReader")
+		}
+		if parseErr := g.parseLine(line, lineNo); parseErr != nil {
+			return errors.Wrapf(parseErr, "grammar line %d", lineNo)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// ParseGrammarAll parses grammar from string like ParseGrammar, but continues
+// past the bad lines. Returns the grammar of good lines and the errors of all
+// bad lines with their line numbers
+func ParseGrammarAll(grammarText string) (*Grammar, []error) {
+	grammar := newGrammar()
+	errs := []error{}
+	lines := strings.Split(grammarText, "\n")
+	for lineIdx, line := range lines {
+		if err := grammar.parseLine(line, lineIdx + 1); err != nil {
+			errs = append(errs, errors.Wrapf(err, "ParseGrammarAll: line %d", lineIdx + 1))
+		}
+	}
+	return grammar, errs
+}
+
+// newGrammar creates an empty grammar
+func newGrammar() *Grammar {
+	return &Grammar{
 		Rules: []*Rule{},
 		Exports: map[Symbol]bool{},
 	}
-	lines := strings.Split(grammarText, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		// Exports command
-		if strings.Index(line, ";!exports:") == 0 {
-			exports := strings.Fields(line[len(";!exports:"):])
-			for _, export:= range exports {
-				symbol := Symbol(strings.TrimSpace(export))
-				if symbol.IsTerminal() || !symbol.IsValid() {
-					err = errors.New(fmt.Sprintf(
-						"ParseGrammar: unexpected export symbol: %s",
-						symbol))
-					return nil, err
-				}
-				grammar.Exports[symbol] = true
+}
+
+// parseLine parses a line of grammar text and adds its rules and exports into
+// g. lineNo is the 1-based line number. On error g is not changed
+func (g *Grammar) parseLine(line string, lineNo int) error {
+	line = strings.TrimSpace(line)
+
+	// Exports command
+	if strings.Index(line, ";!exports:") == 0 {
+		exports := strings.Fields(line[len(";!exports:"):])
+		for _, export:= range exports {
+			symbol := Symbol(strings.TrimSpace(export))
+			if symbol.IsTerminal() || !symbol.IsValid() {
+				return errors.New(fmt.Sprintf(
+					"ParseGrammar: unexpected export symbol: %s",
+					symbol))
 			}
 		}
+		for _, export:= range exports {
+			g.Exports[Symbol(strings.TrimSpace(export))] = true
+		}
+		return nil
+	}
 
-		// Comments
-		if line == "" || line[0] == ';' {
-			continue
+	// Prior command
+	if strings.Index(line, ";!prior:") == 0 {
+		symbol, alpha, err := parsePrior(line[len(";!prior:"):])
+		if err != nil {
+			return err
+		}
+		if g.priors == nil {
+			g.priors = map[Symbol]float64{}
 		}
+		g.priors[symbol] = alpha
+		return nil
+	}
 
-		// Parse this rule
-		rule, err := ParseRule(line)
+	// Atomic command
+	if strings.Index(line, ";!atomic:") == 0 {
+		symbols, err := parseAtomics(line[len(";!atomic:"):])
 		if err != nil {
-			return grammar, err
+			return err
+		}
+		for _, symbol := range symbols {
+			g.addAtomic(symbol)
 		}
-		grammar.Rules = append(grammar.Rules, rule...)
+		return nil
 	}
-	return
+
+	// Synonyms command
+	if strings.Index(line, ";!synonyms:") == 0 {
+		rules, err := parseSynonyms(line[len(";!synonyms:"):])
+		if err != nil {
+			return err
+		}
+		for i, r := range rules {
+			r.Line = lineNo
+			r.Order = len(g.Rules) + i
+		}
+		g.Rules = append(g.Rules, rules...)
+		return nil
+	}
+
+	// Separator command
+	if strings.Index(line, ";!separator:") == 0 {
+		separator, err := parseSeparator(line[len(";!separator:"):])
+		if err != nil {
+			return err
+		}
+		g.separator = separator
+		return nil
+	}
+
+	// Gazetteer command
+	if strings.Index(line, ";!gazetteer:") == 0 {
+		rules, err := parseGazetteer(line[len(";!gazetteer:"):], g.baseDir)
+		if err != nil {
+			return err
+		}
+		for i, r := range rules {
+			r.Line = lineNo
+			r.Order = len(g.Rules) + i
+		}
+		g.Rules = append(g.Rules, rules...)
+		return nil
+	}
+
+	// Include command
+	if strings.Index(line, ";!include:") == 0 {
+		return g.parseInclude(line[len(";!include:"):], lineNo)
+	}
+
+	// Comments
+	if line == "" || line[0] == ';' {
+		return nil
+	}
+
+	// Parse this rule
+	rules, err := ParseRule(line)
+	if err != nil {
+		return err
+	}
+	for i, r := range rules {
+		r.Line = lineNo
+		r.Order = len(g.Rules) + i
+	}
+	g.Rules = append(g.Rules, rules...)
+	return nil
+}
+
+// parsePrior parses the prior command, like
+//     ;!prior: <city> 0.5
+// which sets a symmetric Dirichlet prior with concentration 0.5 on the rules
+// from <city>
+func parsePrior(text string) (Symbol, float64, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return "", 0, errors.New(fmt.Sprintf(
+			"parsePrior: unexpected number of fields in '%s'",
+			text))
+	}
+	symbol := Symbol(fields[0])
+	if !symbol.IsValid() || symbol.IsTerminal() {
+		return "", 0, errors.New(fmt.Sprintf(
+			"parsePrior: unexpected symbol '%s' in '%s'",
+			symbol,
+			text))
+	}
+	alpha, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || !(alpha >= 0) {
+		return "", 0, errors.New(fmt.Sprintf(
+			"parsePrior: unexpected alpha '%s' in '%s'",
+			fields[1],
+			text))
+	}
+	return symbol, alpha, nil
+}
+
+// parseSynonyms parses the synonyms command, like
+//     ;!synonyms: <size> = big large huge ; 0.6
+// It generates a terminal rule from the symbol to each synonym, and the weight
+// of group (default 1.0) is split equally among them
+func parseSynonyms(text string) ([]*Rule, error) {
+	fields := strings.Split(text, "=")
+	if len(fields) != 2 {
+		return nil, errors.New(fmt.Sprintf(
+			"parseSynonyms: unexpected number of '=' in '%s'",
+			text))
+	}
+	left := Symbol(strings.TrimSpace(fields[0]))
+	if !left.IsValid() || left.IsTerminal() {
+		return nil, errors.New(fmt.Sprintf(
+			"parseSynonyms: unexpected symbol '%s' in '%s'",
+			left,
+			text))
+	}
+
+	weight := 1.0
+	right := strings.Split(fields[1], ";")
+	if len(right) == 2 {
+		weightText := strings.TrimSpace(right[1])
+		var err error
+		if weight, err = strconv.ParseFloat(weightText, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf(
+				"parseSynonyms: float expected but '%s' found in '%s'",
+				weightText,
+				text))
+		}
+	} else if len(right) != 1 {
+		return nil, errors.New(fmt.Sprintf(
+			"parseSynonyms: unexpected ';' token in '%s'",
+			text))
+	}
+
+	synonyms := strings.Fields(right[0])
+	if len(synonyms) == 0 {
+		return nil, errors.New(fmt.Sprintf(
+			"parseSynonyms: no synonyms in '%s'",
+			text))
+	}
+	rules := []*Rule{}
+	for _, synonym := range synonyms {
+		symbol := Symbol(synonym)
+		if !symbol.IsValid() || !symbol.IsTerminal() {
+			return nil, errors.New(fmt.Sprintf(
+				"parseSynonyms: unexpected synonym '%s' in '%s'",
+				synonym,
+				text))
+		}
+		rules = append(rules, &Rule{
+			Left: left,
+			Right: []Symbol{symbol},
+			Weight: weight / float64(len(synonyms)),
+		})
+	}
+	return rules, nil
+}
+
+// parseGazetteer parses the gazetteer command, like
+//     ;!gazetteer: <city> path/to/cities.txt
+// It loads the file of one entry per line, and generates a terminal rule from
+// the symbol to each entry. An entry could be several tokens separated by
+// spaces. Like synonyms, the weight 1.0 is split equally among the entries.
+// Blank lines are skipped, and a relative path is resolved against baseDir
+func parseGazetteer(text string, baseDir string) ([]*Rule, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return nil, errors.New(fmt.Sprintf(
+			"parseGazetteer: unexpected number of fields in '%s'",
+			text))
+	}
+	left := Symbol(fields[0])
+	if !left.IsValid() || left.IsTerminal() {
+		return nil, errors.New(fmt.Sprintf(
+			"parseGazetteer: unexpected symbol '%s' in '%s'",
+			left,
+			text))
+	}
+	path := fields[1]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseGazetteer")
+	}
+
+	entries := [][]Symbol{}
+	for _, line := range strings.Split(string(data), "\n") {
+		tokens := []Symbol{}
+		for _, token := range strings.Fields(line) {
+			symbol := Symbol(token)
+			if !symbol.IsValid() || !symbol.IsTerminal() {
+				return nil, errors.New(fmt.Sprintf(
+					"parseGazetteer: unexpected entry '%s' in %s",
+					strings.TrimSpace(line),
+					path))
+			}
+			tokens = append(tokens, symbol)
+		}
+		if len(tokens) != 0 {
+			entries = append(entries, tokens)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, errors.New(fmt.Sprintf("parseGazetteer: no entries in %s", path))
+	}
+	rules := []*Rule{}
+	for _, tokens := range entries {
+		rules = append(rules, &Rule{
+			Left: left,
+			Right: tokens,
+			Weight: 1.0 / float64(len(entries)),
+		})
+	}
+	return rules, nil
+}
+
+// parseInclude parses the include command, like
+//     ;!include: path/to/cities.pcfg
+// It parses the file as a grammar and merges its rules, exports, priors,
+// atomic symbols and separator into g. The rules of a symbol in both of them
+// are unioned, and the merged rules have lineNo as their Line like the ones
+// from other commands. A relative path is resolved against the directory of
+// current file. The included files could include others, but not in a cycle
+func (g *Grammar) parseInclude(text string, lineNo int) error {
+	path := strings.TrimSpace(text)
+	if path == "" {
+		return errors.New("parseInclude: empty path")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(g.baseDir, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrap(err, "parseInclude")
+	}
+	for i, included := range g.includes {
+		if included == abs {
+			chain := append(append([]string{}, g.includes[i: ]...), abs)
+			return errors.New(fmt.Sprintf(
+				"parseInclude: cycle of included files %s",
+				strings.Join(chain, " -> ")))
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "parseInclude")
+	}
+
+	included := newGrammar()
+	included.baseDir = filepath.Dir(path)
+	included.includes = append(append([]string{}, g.includes...), abs)
+	for lineIdx, line := range strings.Split(string(data), "\n") {
+		if err := included.parseLine(line, lineIdx + 1); err != nil {
+			return errors.Wrapf(err, "parseInclude: %s line %d", path, lineIdx + 1)
+		}
+	}
+
+	for i, r := range included.Rules {
+		r.Line = lineNo
+		r.Order = len(g.Rules) + i
+	}
+	g.Rules = append(g.Rules, included.Rules...)
+	for symbol := range included.Exports {
+		g.Exports[symbol] = true
+	}
+	for symbol, alpha := range included.priors {
+		if g.priors == nil {
+			g.priors = map[Symbol]float64{}
+		}
+		g.priors[symbol] = alpha
+	}
+	for symbol := range included.atomics {
+		g.addAtomic(symbol)
+	}
+	if included.separator != "" {
+		g.separator = included.separator
+	}
+	return nil
+}
+
+// clone returns a deep copy of grammar
+func (g *Grammar) clone() *Grammar {
+	cloned := *g
+	cloned.leftIndex = nil
+	cloned.Rules = []*Rule{}
+	for _, rule := range g.Rules {
+		r := *rule
+		r.Right = append([]Symbol{}, rule.Right...)
+		if rule.Path != nil {
+			r.Path = append([]Symbol{}, rule.Path...)
+		}
+		cloned.Rules = append(cloned.Rules, &r)
+	}
+	cloned.Exports = map[Symbol]bool{}
+	for symbol := range g.Exports {
+		cloned.Exports[symbol] = true
+	}
+	if g.priors != nil {
+		cloned.priors = map[Symbol]float64{}
+		for symbol, alpha := range g.priors {
+			cloned.priors[symbol] = alpha
+		}
+	}
+	if g.atomics != nil {
+		cloned.atomics = map[Symbol]bool{}
+		for symbol := range g.atomics {
+			cloned.atomics[symbol] = true
+		}
+	}
+	return &cloned
+}
+
+// checkRoot returns an error if there are no rules of the start symbols in
+// grammar, then the parser of it matches nothing. The start symbols are <root>
+// and the exported symbols, which ParseIntent and ParseMultiStart start from
+func (g *Grammar) checkRoot() error {
+	for _, rule := range g.Rules {
+		if rule.Left == RootSymbol || g.Exports[rule.Left] {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf(
+		"Grammar.checkRoot: no rules of the start symbol %s or exported symbols",
+		RootSymbol))
+}
+
+// addAtomic marks symbol as atomic, see parseAtomics
+func (g *Grammar) addAtomic(symbol Symbol) {
+	if g.atomics == nil {
+		g.atomics = map[Symbol]bool{}
+	}
+	g.atomics[symbol] = true
 }
 
 // Enable debug in grammar, it will print some debug information
@@ -65,6 +566,71 @@ func (g *Grammar) DebugMode() {
 	g.isDebug = true
 }
 
+// FactorPrefixes sets whether to share the binarization of rules with common
+// prefixes in right side. It reduces the number of internal symbols and CNF
+// rules when many rules share prefixes
+func (g *Grammar) FactorPrefixes(enable bool) {
+	g.factorPrefixes = enable
+}
+
+// PreserveDistribution sets whether ConvertToCNF keeps the distribution of
+// sentences derived from each symbol, as authored in grammar. By default, the
+// null rules are removed approximately: the probability of a nullable symbol
+// is subtracted from the rule once for each nullable symbol in it, and the
+// rules are renormalized per left symbol afterwards, including the internal
+// symbols of binarization. So that the rules with several nullable symbols
+// could shift the distribution. When enabled, the null probabilities are
+// solved exactly, and each rule keeps the probability of its non-empty
+// derivations. The distribution is kept except for the empty sentence, which
+// is never parsed, and the cycles of unit rules (see removeStrongComponents)
+func (g *Grammar) PreserveDistribution(enable bool) {
+	g.preserveDistribution = enable
+}
+
+// ApplyWeights sets the weights of rules from a map of rule identifier (see
+// Rule.Id) to weight. Rules not in weights keep their weights. It should be
+// called before ConvertToCNF, which normalizes weights and rewrites rules
+func (g *Grammar) ApplyWeights(weights map[string]float64) error {
+	rules := map[string][]*Rule{}
+	for _, rule := range g.Rules {
+		rules[rule.Id()] = append(rules[rule.Id()], rule)
+	}
+
+	// Check all weights before applying any of them
+	for id, weight := range weights {
+		if _, ok := rules[id]; !ok {
+			return errors.New(fmt.Sprintf("ApplyWeights: rule not found: '%s'", id))
+		}
+		if !(weight > 0) || math.IsInf(weight, 0) {
+			return errors.New(fmt.Sprintf(
+				"ApplyWeights: invalid weight %g of '%s'",
+				weight,
+				id))
+		}
+	}
+
+	for id, weight := range weights {
+		for _, rule := range rules[id] {
+			rule.Weight = weight
+		}
+	}
+	return nil
+}
+
+// NormalizeUnicode sets whether to normalize the terminals in the CNF grammar
+// converted from g, and the query tokens when parsing with it, to Unicode
+// NFC. So that tokens in NFD (common on macOS) match the terminals in NFC.
+// It's disabled by default, which matches tokens byte by byte
+func (g *Grammar) NormalizeUnicode(enable bool) {
+	g.normalizeUnicode = enable
+}
+
+// UseVocabulary sets the shared vocabulary to allocate symbol ids of the CNF
+// grammar converted from g
+func (g *Grammar) UseVocabulary(vocabulary *Vocabulary) {
+	g.vocabulary = vocabulary
+}
+
 // Print grammar
 func (g *Grammar) Print() {
 	for _, rule := range g.Rules {
@@ -73,12 +639,71 @@ func (g *Grammar) Print() {
 	fmt.Println("")
 }
 
-// ConvertToCNF converts CFG grammar to CNF (Debug mode)
+// ConvertToCNF converts CFG grammar to CNF (Debug mode). It triggers
+// log.Fatal when the grammar is malformed
 func (g *Grammar) ConvertToCNF() *CNFGrammar {
+	cnfGrammar, err := g.convertToCNF()
+	checkAndFatal(err)
+	return cnfGrammar
+}
+
+// ConvertToCNFTemperature converts grammar to CNF like ConvertToCNF, but each
+// rule weight is raised to 1/t before normalization. t < 1 sharpens the
+// preference of rules, and t > 1 flattens it. It triggers log.Fatal when t is
+// not positive
+func (g *Grammar) ConvertToCNFTemperature(t float64) *CNFGrammar {
+	if t <= 0 {
+		checkAndFatal(errors.New(fmt.Sprintf(
+			"Grammar.ConvertToCNFTemperature: temperature %f is not positive",
+			t)))
+	}
+	g.temperature = t
+	return g.ConvertToCNF()
+}
+
+// applyTemperature raises the normalized weights to 1/t and normalizes them
+// again. It's computed relative to the best rule of each left symbol in log
+// space, so that a small t won't underflow all of the weights
+func (g *Grammar) applyTemperature(t float64) {
+	maxWeights := map[Symbol]float64{}
+	for _, rule := range g.Rules {
+		if rule.Weight > maxWeights[rule.Left] {
+			maxWeights[rule.Left] = rule.Weight
+		}
+	}
+	for _, rule := range g.Rules {
+		if rule.Weight > 0 {
+			rule.Weight = math.Exp((math.Log(rule.Weight) - math.Log(maxWeights[rule.Left])) / t)
+		}
+	}
+	g.normalizeWeight()
+}
+
+// ConversionReport returns the report of the last conversion to CNF
+func (g *Grammar) ConversionReport() ConversionReport {
+	return g.report
+}
+
+// convertToCNF converts CFG grammar to CNF, returns error when the grammar is
+// malformed
+func (g *Grammar) convertToCNF() (*CNFGrammar, error) {
+	g.report = ConversionReport{}
 	if gEnableDebug {
 		fmt.Println("======= Original Grammar =======")
 	}
+	separator, err := g.addSeparatorRule()
+	if err != nil {
+		return nil, err
+	}
+	g.applyPriors()
 	g.normalizeWeight()
+	if g.temperature > 0 && g.temperature != 1 {
+		g.applyTemperature(g.temperature)
+	}
+	if g.minProbability > 0 {
+		g.applyProbabilityFloor(g.minProbability)
+	}
+	phrases := g.dictionaryPhrases()
 	if gEnableDebug {
 		g.Print()
 		fmt.Println("======= Add Term Variables =======")
@@ -88,7 +713,14 @@ func (g *Grammar) ConvertToCNF() *CNFGrammar {
 		g.Print()
 		fmt.Println("======= Reduce Higher Rules =======")
 	}
-	g.reduceHigherRules()
+	if g.factorPrefixes {
+		err = g.factorHigherRules()
+	} else {
+		err = g.reduceHigherRules()
+	}
+	if err != nil {
+		return nil, err
+	}
 	if gEnableDebug {
 		g.Print()
 		fmt.Println("======= Remove Null Rules =======")
@@ -98,7 +730,10 @@ func (g *Grammar) ConvertToCNF() *CNFGrammar {
 		g.Print()
 		fmt.Println("======= Remove Strong Components =======")
 	}
-	g.removeStrongComponents()
+	err = g.removeStrongComponents()
+	if err != nil {
+		return nil, err
+	}
 	if gEnableDebug {
 		g.Print()
 		fmt.Println("======= Remove Unit Rules =======")
@@ -109,6 +744,14 @@ func (g *Grammar) ConvertToCNF() *CNFGrammar {
 	}
 
 	cnfGrammar := NewCNFGrammar()
+	if g.vocabulary != nil {
+		cnfGrammar = NewCNFGrammarWithVocabulary(g.vocabulary)
+	}
+	cnfGrammar.normalizeUnicode = g.normalizeUnicode
+	cnfGrammar.caseInsensitive = g.caseInsensitive
+	if separator != "" {
+		cnfGrammar.separator = cnfGrammar.normalizeToken(separator)
+	}
 	for _, rule := range g.Rules {
 		cnfGrammar.AddRule(rule)
 	}
@@ -116,8 +759,15 @@ func (g *Grammar) ConvertToCNF() *CNFGrammar {
 	for export := range g.Exports {
 		cnfGrammar.AddExportSymbol(export)
 	}
+	for symbol := range g.atomics {
+		cnfGrammar.AddAtomicSymbol(symbol)
+	}
+	cnfGrammar.Compile()
+	if phrases != nil {
+		cnfGrammar.buildDictionary(phrases)
+	}
 
-	return cnfGrammar
+	return cnfGrammar, nil
 }
 
 // normalizeWeight normalize the weight of rule. Make sure that the sum of weight
@@ -135,6 +785,134 @@ func (g *Grammar) normalizeWeight() {
 	}
 }
 
+// NormalizeSymbol normalizes the weights of rules from left so that they sum
+// to 1, and the rules of other symbols are not changed. After rules are added
+// programmatically, it's cheaper than normalizing the whole grammar for each
+// symbol changed. The rules are found by an index of left symbols, which is
+// updated with the rules appended to g.Rules, and rebuilt if g.Rules is
+// replaced. Rules changed in place are not seen by the index
+func (g *Grammar) NormalizeSymbol(left Symbol) {
+	rules := g.rulesOf(left)
+	weight := 0.0
+	for _, rule := range rules {
+		weight += rule.Weight
+	}
+	for _, rule := range rules {
+		rule.Weight /= weight
+	}
+}
+
+// _LeftIndex is the index of rules by left symbols over the rules of grammar
+// when it's updated
+type _LeftIndex struct {
+	rules []*Rule
+	left map[Symbol][]*Rule
+}
+
+// rulesOf returns the rules from left by the occurs index if cached, or by the
+// left index otherwise. The left index is extended with the rules appended
+// since the last call, and rebuilt if g.Rules no longer starts with the rules
+// indexed, like after filtering or reallocation by append
+func (g *Grammar) rulesOf(left Symbol) []*Rule {
+	if g.occurs != nil {
+		return g.occurs.left[left]
+	}
+	index := g.leftIndex
+	if index == nil ||
+		len(g.Rules) < len(index.rules) ||
+		len(index.rules) != 0 && &g.Rules[0] != &index.rules[0] {
+		index = &_LeftIndex{left: map[Symbol][]*Rule{}}
+		g.leftIndex = index
+	}
+	for _, rule := range g.Rules[len(index.rules): ] {
+		index.left[rule.Left] = append(index.left[rule.Left], rule)
+	}
+	index.rules = g.Rules
+	return index.left[left]
+}
+
+// renormalizeWeight normalizes the weights like normalizeWeight after a step of
+// conversion changed them, and records the drift of total probabilities in
+// the conversion report
+func (g *Grammar) renormalizeWeight() {
+	weights := map[Symbol]float64{}
+	for _, rule := range g.Rules {
+		weights[rule.Left] += rule.Weight
+	}
+	for _, weight := range weights {
+		drift := math.Abs(weight - 1)
+		if drift > _DriftTolerance && drift > g.report.ProbabilityDrift {
+			g.report.Approximated = true
+			g.report.ProbabilityDrift = drift
+		}
+	}
+	g.normalizeWeight()
+}
+
+// applyPriors adds the Dirichlet prior alpha of each symbol to the weights of
+// its rules as pseudo-counts. Then normalizeWeight gives the posterior mean
+//     (weight + alpha) / (sum(weights) + n * alpha)
+// for n rules from the symbol. Weights are the observed counts here
+func (g *Grammar) applyPriors() {
+	for _, rule := range g.Rules {
+		rule.Weight += g.priors[rule.Left]
+	}
+}
+
+// MinProbability sets the probability floor of rules in ConvertToCNF. After
+// normalizing, the rules below floor are raised to it and the others of the
+// same left symbol are scaled down to keep the sum 1. If floor * n >= 1 for a
+// symbol with n rules, all its rules get 1/n, so that a high floor on grammars
+// with many alternatives flattens their weights. The floor is about the rules
+// in grammar, the rules combined later in conversion (e.g. by removing unit
+// rules) could be below it
+func (g *Grammar) MinProbability(floor float64) {
+	g.minProbability = floor
+}
+
+// applyProbabilityFloor raises the normalized weights of rules below floor to
+// it, and scales down the others of the same left symbol proportionally
+func (g *Grammar) applyProbabilityFloor(floor float64) {
+	for _, rules := range g.occursLeft() {
+		if floor * float64(len(rules)) >= 1 {
+			for _, rule := range rules {
+				rule.Weight = 1.0 / float64(len(rules))
+			}
+			continue
+		}
+
+		// Raising rules may push others below floor, repeat until stable
+		floored := map[*Rule]bool{}
+		for {
+			free := 0.0
+			for _, rule := range rules {
+				if !floored[rule] {
+					free += rule.Weight
+				}
+			}
+			scale := (1 - floor * float64(len(floored))) / free
+			changed := false
+			for _, rule := range rules {
+				if !floored[rule] && rule.Weight * scale < floor {
+					floored[rule] = true
+					changed = true
+				}
+			}
+			if changed {
+				continue
+			}
+			for _, rule := range rules {
+				if floored[rule] {
+					rule.Weight = floor
+				} else {
+					rule.Weight *= scale
+				}
+			}
+			break
+		}
+	}
+}
+
 // addTermVariables eliminiates terminal symbols except in right hand sides of size 1
 func (g *Grammar) addTermVariables() {
 	termRulesCount := 0
@@ -171,15 +949,25 @@ func (g *Grammar) addTermVariables() {
 
 // reduceHigherRules converts rule with right-hand size larger than 2 into a set
 // of binary rules
-func (g *Grammar) reduceHigherRules() {
+func (g *Grammar) reduceHigherRules() error {
 	binaryRules := []*Rule{}
+
+	// Number of intermediate symbols used by each left symbol, so that the
+	// rules with the same left symbol don't share them
+	counts := map[Symbol]int{}
 	for _, rule := range g.Rules {
-		if rule.IsUnary() || rule.IsBinary() {
+		if len(rule.Right) == 0 {
+			return errors.New(fmt.Sprintf(
+				"reduceHigherRules: empty right side in '%s'",
+				rule.String()))
+		}
+
+		if len(rule.Right) < 3 {
 			// It's already binary rule
 			binaryRules = append(binaryRules, rule)
 		} else {
 			ruleText := rule.Left.Text()
-			count := 1
+			count := counts[rule.Left] + 1
 
 			// Begin rule: U -> W_1 X_0
 			// It's the reference to next rule, so didn't increase count here
@@ -187,7 +975,8 @@ func (g *Grammar) reduceHigherRules() {
 			r := &Rule{
 				Left: rule.Left,
 				Right: []Symbol{rule.Right[0], x0},
-				Weight: rule.Weight}
+				Weight: rule.Weight,
+				Order: rule.Order}
 			binaryRules = append(binaryRules, r)
 
 			// Middle rules: X_i-1 -> W_i X_i
@@ -198,7 +987,8 @@ func (g *Grammar) reduceHigherRules() {
 				r := &Rule{
 					Left: x,
 					Right: []Symbol{rule.Right[i], nextX},
-					Weight: 1.0}
+					Weight: 1.0,
+					Order: rule.Order}
 				binaryRules = append(binaryRules, r)
 			}
 
@@ -209,55 +999,252 @@ func (g *Grammar) reduceHigherRules() {
 			r = &Rule{
 				Left: x,
 				Right: []Symbol{rule.Right[k - 1], rule.Right[k]},
-				Weight: 1.0}
+				Weight: 1.0,
+				Order: rule.Order}
 			binaryRules = append(binaryRules, r)
+			counts[rule.Left] = count - 1
 		}
 	}
 	g.Rules = binaryRules
+	return nil
+}
+
+// _PrefixNode is a node in the trie of right-hand sides used by
+// factorHigherRules
+type _PrefixNode struct {
+	symbols []Symbol
+	children []*_PrefixNode
+
+	// Weight of rules ending at this node
+	end float64
+
+	// Weight of rules continuing below this node
+	cont float64
+
+	// Order of the first rule reaching this node
+	order int
+}
+
+// child gets the child of node by symbol, creates a new one with order if not
+// exist
+func (n *_PrefixNode) child(symbol Symbol, order int) *_PrefixNode {
+	for i, s := range n.symbols {
+		if s == symbol {
+			return n.children[i]
+		}
+	}
+	c := &_PrefixNode{order: order}
+	n.symbols = append(n.symbols, symbol)
+	n.children = append(n.children, c)
+	return c
+}
+
+// factorHigherRules converts rule with right-hand size larger than 2 into a set
+// of binary rules like reduceHigherRules, but rules with the same left symbol
+// share the binary rules of their common prefix. For example
+//     U -> A B C ; 0.2 | A B D E ; 0.3
+// is converted to
+//     U -> A X_1 ; 0.5
+//     X_1 -> B X_2 ; 1.0
+//     X_2 -> C ; 0.4 | D E ; 0.6
+func (g *Grammar) factorHigherRules() error {
+	binaryRules := []*Rule{}
+
+	// Build the trie of right-hand sides for each left symbol
+	lefts := []Symbol{}
+	tries := map[Symbol]*_PrefixNode{}
+	for _, rule := range g.Rules {
+		if len(rule.Right) == 0 {
+			return errors.New(fmt.Sprintf(
+				"factorHigherRules: empty right side in '%s'",
+				rule.String()))
+		}
+		if len(rule.Right) < 3 {
+			// It's already binary rule
+			binaryRules = append(binaryRules, rule)
+			continue
+		}
+		if _, ok := tries[rule.Left]; !ok {
+			lefts = append(lefts, rule.Left)
+			tries[rule.Left] = &_PrefixNode{}
+		}
+		node := tries[rule.Left]
+		node.cont += rule.Weight
+		for i, symbol := range rule.Right {
+			node = node.child(symbol, rule.Order)
+			if i == len(rule.Right) - 1 {
+				node.end += rule.Weight
+			} else {
+				node.cont += rule.Weight
+			}
+		}
+	}
+
+	// expand adds the rules from left to the children of node. Weights are
+	// divided by total, which is 1.0 for the original left symbol
+	count := 1
+	ruleText := ""
+	var expand func(left Symbol, node *_PrefixNode, total float64)
+	expand = func(left Symbol, node *_PrefixNode, total float64) {
+		for i, symbol := range node.symbols {
+			child := node.children[i]
+			if child.end > 0 {
+				binaryRules = append(binaryRules, &Rule{
+					Left: left,
+					Right: []Symbol{symbol},
+					Weight: child.end / total,
+					Order: child.order})
+			}
+			if child.cont == 0 {
+				continue
+			}
+
+			// When the only continuation is a single symbol, add the rule
+			// directly instead of an internal symbol
+			if len(child.children) == 1 && len(child.children[0].children) == 0 {
+				binaryRules = append(binaryRules, &Rule{
+					Left: left,
+					Right: []Symbol{symbol, child.symbols[0]},
+					Weight: child.cont / total,
+					Order: child.order})
+				continue
+			}
+
+			x := InternalSymbol(fmt.Sprintf("p_%s_%d", ruleText, count))
+			count++
+			binaryRules = append(binaryRules, &Rule{
+				Left: left,
+				Right: []Symbol{symbol, x},
+				Weight: child.cont / total,
+				Order: child.order})
+			expand(x, child, child.cont)
+		}
+	}
+	for _, left := range lefts {
+		ruleText = left.Text()
+		expand(left, tries[left], 1.0)
+	}
+	g.Rules = binaryRules
+	return nil
 }
 
 // Gets occurs-right map, that records which rules does a symbol occurs in the
 // right side. assuming all rules are unary or binary
 func (g *Grammar) occursRight() map[Symbol][]*Rule {
+	if g.occurs != nil {
+		return g.occurs.right
+	}
 	occurs := map[Symbol][]*Rule{}
 	for _, rule := range g.Rules {
-		if rule.IsBinary() {
-			// Rule: A -> BC
-			B := rule.Right[0]
-			C := rule.Right[1]
-			if _, ok := occurs[B]; !ok {
-				occurs[B] = []*Rule{}
-			}
-			if _, ok := occurs[C]; !ok {
-				occurs[C] = []*Rule{}
-			}
-			occurs[B] = append(occurs[B], rule)
-			occurs[C] = append(occurs[C], rule)
-		} else if rule.IsUnary() && !rule.Right[0].IsTerminal() {
-			// Rule: A -> B
-			B := rule.Right[0]
-			if _, ok := occurs[B]; !ok {
-				occurs[B] = []*Rule{}
-			}
-			occurs[B] = append(occurs[B], rule)
+		for _, symbol := range occursSymbols(rule) {
+			occurs[symbol] = append(occurs[symbol], rule)
 		}
 	}
 	return occurs
 }
 
+// occursSymbols returns the symbols in the right side of rule that occursRight
+// records rule for. For rule A -> BC, it's B and C. For rule A -> B, it's B if
+// B is not terminal
+func occursSymbols(rule *Rule) []Symbol {
+	if rule.IsBinary() {
+		return rule.Right
+	} else if rule.IsUnary() && !rule.Right[0].IsTerminal() {
+		return rule.Right
+	}
+	return nil
+}
+
 // Gets occurs-left map. For every rule r: A -> BC, add occursLeft[A] = r
 func (g *Grammar) occursLeft() map[Symbol][]*Rule {
+	if g.occurs != nil {
+		return g.occurs.left
+	}
 	occurs := map[Symbol][]*Rule{}
 	for _, rule := range g.Rules {
-		if occurs[rule.Left] == nil {
-			occurs[rule.Left] = []*Rule{rule}
-		} else {
-			occurs[rule.Left] = append(occurs[rule.Left], rule)
-		}
+		occurs[rule.Left] = append(occurs[rule.Left], rule)
 	}
 	return occurs
 }
 
+// _OccursIndex is the cache of occursLeft and occursRight
+type _OccursIndex struct {
+	left map[Symbol][]*Rule
+	right map[Symbol][]*Rule
+}
+
+// indexOccurs caches occursLeft and occursRight until dropOccurs is called,
+// so that the steps removing rules one by one don't rebuild them each time.
+// Meanwhile rules should be changed only by addRules and removeRules, which
+// keep the cache up to date
+func (g *Grammar) indexOccurs() {
+	g.occurs = nil
+	g.occurs = &_OccursIndex{left: g.occursLeft(), right: g.occursRight()}
+}
+
+// dropOccurs drops the cache of occursLeft and occursRight
+func (g *Grammar) dropOccurs() {
+	g.occurs = nil
+}
+
+// addRules appends rules into grammar
+func (g *Grammar) addRules(rules ...*Rule) {
+	g.Rules = append(g.Rules, rules...)
+	if g.occurs == nil {
+		return
+	}
+	for _, rule := range rules {
+		g.occurs.left[rule.Left] = append(g.occurs.left[rule.Left], rule)
+		for _, symbol := range occursSymbols(rule) {
+			g.occurs.right[symbol] = append(g.occurs.right[symbol], rule)
+		}
+	}
+}
+
+// removeRules removes the rules in removed from grammar
+func (g *Grammar) removeRules(removed map[*Rule]bool) {
+	if len(removed) == 0 {
+		return
+	}
+	g.Rules = filterRules(g.Rules, removed)
+	g.unindexRules(removed)
+}
+
+// unindexRules removes the rules in removed from the occurs index only, if
+// it's cached
+func (g *Grammar) unindexRules(removed map[*Rule]bool) {
+	if g.occurs == nil {
+		return
+	}
+
+	// Filter each affected entry once
+	lefts := map[Symbol]bool{}
+	rights := map[Symbol]bool{}
+	for rule := range removed {
+		lefts[rule.Left] = true
+		for _, symbol := range occursSymbols(rule) {
+			rights[symbol] = true
+		}
+	}
+	for symbol := range lefts {
+		g.occurs.left[symbol] = filterRules(g.occurs.left[symbol], removed)
+	}
+	for symbol := range rights {
+		g.occurs.right[symbol] = filterRules(g.occurs.right[symbol], removed)
+	}
+}
+
+// filterRules returns the rules not in removed
+func filterRules(rules []*Rule, removed map[*Rule]bool) []*Rule {
+	kept := []*Rule{}
+	for _, rule := range rules {
+		if !removed[rule] {
+			kept = append(kept, rule)
+		}
+	}
+	return kept
+}
+
 // findNullables finds nullable symbols and its probabilities from grammar
 func (g *Grammar) findNullables() map[Symbol]float64 {
 	occurs := g.occursRight()
@@ -298,9 +1285,56 @@ func (g *Grammar) findNullables() map[Symbol]float64 {
 	return nullable
 }
 
+// nullProbabilities returns the probability of each symbol deriving the empty
+// string. It's solved by fixed-point iteration, which is exact when nullable
+// symbols are not recursive
+func (g *Grammar) nullProbabilities() map[Symbol]float64 {
+	nullable := map[Symbol]float64{}
+	for i := 0; i < _NullMaxIterations; i++ {
+		next := map[Symbol]float64{}
+		for _, rule := range g.Rules {
+			p := rule.Weight
+			for _, symbol := range rule.Right {
+				if symbol != EpsilonSymbol {
+					p *= nullable[symbol]
+				}
+			}
+			if p > 0 {
+				next[rule.Left] += p
+			}
+		}
+		converged := len(next) == len(nullable)
+		for symbol, p := range next {
+			if math.Abs(p - nullable[symbol]) > _NullTolerance {
+				converged = false
+			}
+		}
+		nullable = next
+		if converged {
+			break
+		}
+	}
+	return nullable
+}
+
 // removeNullables remove null rules (A -> <nil>) from grammar
 func (g *Grammar) removeNullRules() {
 	nullables := g.findNullables()
+	if g.preserveDistribution {
+		nullables = g.nullProbabilities()
+
+		// For rule A -> B, only the non-empty derivations of B are kept
+		for _, rule := range g.Rules {
+			if rule.IsUnary() && nullables[rule.Right[0]] > 0 {
+				rule.Weight *= 1 - nullables[rule.Right[0]]
+				g.audit(
+					AuditPhaseNullRules,
+					AuditReweighted,
+					rule,
+					fmt.Sprintf("%s is nullable", rule.Right[0]))
+			}
+		}
+	}
 
 	// Unary rules
 	singleRules := map[[2]Symbol]*Rule{}
@@ -314,6 +1348,12 @@ func (g *Grammar) removeNullRules() {
 	type ruleToAdd struct {
 		A, B Symbol
 		Probability float64
+
+		// Reason for the audit log
+		reason string
+
+		// Order of the binary rule
+		order int
 	}
 	rulesToAdd := []ruleToAdd{}
 	for _, rule := range g.Rules {
@@ -325,14 +1365,51 @@ func (g *Grammar) removeNullRules() {
 		B := rule.Right[0]
 		C := rule.Right[1]
 		probability := rule.Weight
+		ruleText := ""
+		if nullables[B] > 0 || nullables[C] > 0 {
+			ruleText = rule.String()
+		}
+		if g.preserveDistribution {
+			// Split A -> BC by which of B and C derive the empty string, and
+			// drop the case both of them do
+			nullB, nullC := nullables[B], nullables[C]
+			if nullB > 0 {
+				rulesToAdd = append(rulesToAdd, ruleToAdd{
+					A,
+					C,
+					probability * nullB * (1 - nullC),
+					fmt.Sprintf("%s is nullable in '%s'", B, ruleText),
+					rule.Order})
+			}
+			if nullC > 0 {
+				rulesToAdd = append(rulesToAdd, ruleToAdd{
+					A,
+					B,
+					probability * (1 - nullB) * nullC,
+					fmt.Sprintf("%s is nullable in '%s'", C, ruleText),
+					rule.Order})
+			}
+			rule.Weight = probability * (1 - nullB) * (1 - nullC)
+			continue
+		}
 		if nullables[B] > 0 {
 			ruleProb := probability * nullables[B]
-			rulesToAdd = append(rulesToAdd, ruleToAdd{A, C, ruleProb})
+			rulesToAdd = append(rulesToAdd, ruleToAdd{
+				A,
+				C,
+				ruleProb,
+				fmt.Sprintf("%s is nullable in '%s'", B, ruleText),
+				rule.Order})
 			rule.Weight -= ruleProb
 		}
 		if nullables[C] > 0 {
 			ruleProb := probability * nullables[C]
-			rulesToAdd = append(rulesToAdd, ruleToAdd{A, B, ruleProb})
+			rulesToAdd = append(rulesToAdd, ruleToAdd{
+				A,
+				B,
+				ruleProb,
+				fmt.Sprintf("%s is nullable in '%s'", C, ruleText),
+				rule.Order})
 			rule.Weight -= ruleProb
 		}
 	}
@@ -342,11 +1419,15 @@ func (g *Grammar) removeNullRules() {
 		if targetRule, ok := singleRules[[2]Symbol{rule.A, rule.B}]; ok {
 			// If A -> B already exists
 			targetRule.Weight += rule.Probability
+			g.audit(AuditPhaseNullRules, AuditReweighted, targetRule, rule.reason)
 		} else {
-			g.Rules = append(g.Rules, &Rule{
+			newRule := &Rule{
 				Left: rule.A,
 				Right: []Symbol{rule.B},
-				Weight: rule.Probability})
+				Weight: rule.Probability,
+				Order: rule.order}
+			g.Rules = append(g.Rules, newRule)
+			g.audit(AuditPhaseNullRules, AuditAdded, newRule, rule.reason)
 		}
 	}
 
@@ -355,27 +1436,36 @@ func (g *Grammar) removeNullRules() {
 	for _, rule := range g.Rules {
 		if !(rule.IsUnary() && rule.Right[0] == EpsilonSymbol) {
 			rules = append(rules, rule)
+		} else {
+			g.audit(AuditPhaseNullRules, AuditRemoved, rule, "null rule")
 		}
 	}
 	g.Rules = rules
 
 	// Normalize probabilities after empty rules removed
 	// Only influences directly nullables symbols like A with A -> <nil>
-	g.normalizeWeight();
+	g.renormalizeWeight()
 }
 
-// replaceStrongComponents replaces strong component with a single symbol/vertex.
-// Then stores such replacement into g.Alternatives
-func (g *Grammar) findStrongComponents() [][]Symbol {
-	// Find each strong component with Kosaraju's algorithm
-	// Here strong component will only occur in unary rules like A -> B
+// DependencyGraph returns the graph of unary rules between non-terminal
+// symbols. For each rule A -> B, there is an arc from A to B weighted by the
+// weight of rule
+func (g *Grammar) DependencyGraph() *DirectedGraph {
 	graph := NewDirectedGraph()
 	for _, rule := range g.Rules {
 		if rule.IsUnary() && !rule.Right[0].IsTerminal() {
 			graph.Add(Vertex(rule.Left), Vertex(rule.Right[0]), rule.Weight)
 		}
 	}
+	return graph
+}
 
+// replaceStrongComponents replaces strong component with a single symbol/vertex.
+// Then stores such replacement into g.Alternatives
+func (g *Grammar) findStrongComponents() [][]Symbol {
+	// Find each strong component with Kosaraju's algorithm
+	// Here strong component will only occur in unary rules like A -> B
+	graph := g.DependencyGraph()
 	components := graph.StrongComponents()
 	symbolComps := [][]Symbol{}
 	for _, c := range components {
@@ -388,8 +1478,9 @@ func (g *Grammar) findStrongComponents() [][]Symbol {
 	return symbolComps
 }
 
-// removeStrongComponent removes a strong component from graph
-func (g *Grammar) removeStrongComponent(strongComponent []Symbol) {
+// removeStrongComponent removes a strong component from graph. Returns an
+// error if the probability of a rule replacing the component underflows
+func (g *Grammar) removeStrongComponent(strongComponent []Symbol) error {
 	graph := NewDirectedGraph()
 	occursLeft := g.occursLeft()
 	occursRight := g.occursRight()
@@ -408,26 +1499,30 @@ func (g *Grammar) removeStrongComponent(strongComponent []Symbol) {
 			}
 		}
 	}
-	distance := graph.Floyd()
-	transProbs := map[Symbol]map[Symbol]float64{}
+	distance, next := graph.FloydPaths()
+	transLogProbs := map[Symbol]map[Symbol]float64{}
 	for s, ts := range distance {
 		for t, negativeLogP := range ts {
-			if _, ok := transProbs[Symbol(s)]; !ok {
-				transProbs[Symbol(s)] = map[Symbol]float64{}
+			if _, ok := transLogProbs[Symbol(s)]; !ok {
+				transLogProbs[Symbol(s)] = map[Symbol]float64{}
 			}
-			transProbs[Symbol(s)][Symbol(t)] = math.Exp(-negativeLogP)
+			transLogProbs[Symbol(s)][Symbol(t)] = -negativeLogP
 		}
 	}
 
 	// Symbols only referenced inside the component
 	internals := map[Symbol]bool{}
 
+	// Rules are added after all symbols processed, so that occursLeft doesn't
+	// see them
+	newRules := []*Rule{}
+
 	// For symbols S, T in components. if P(S->T) = 0.2 after floyd algorithm,
 	// and "T -> BC; 0.4". Then add rule "S -> BC; innerProb*0.2*0.4"
 	for symbol, _ := range component {
 		// Ignore this symbol if it is only referenced inside the strong
-		// connected component
-		isExternal := false
+		// connected component. <root> is referenced as the start symbol
+		isExternal := symbol == RootSymbol
 		for _, rule := range occursRight[symbol] {
 			if rule.IsBinary() || !component[rule.Left] {
 				isExternal = true
@@ -457,128 +1552,175 @@ func (g *Grammar) removeStrongComponent(strongComponent []Symbol) {
 					// Ignore the rules of this component
 					continue
 				}
-				transProb := transProbs[symbol][targetSymbol]
-				g.Rules = append(g.Rules, &Rule{
+				// Multiply the probabilities in log space
+				logp := math.Log(innerProb) +
+					transLogProbs[symbol][targetSymbol] +
+					math.Log(targetRule.Weight)
+				weight, ok := LinearProb(logp)
+				if !ok {
+					return errors.New(fmt.Sprintf(
+						"removeStrongComponent: probability of '%s' from %s underflows",
+						targetRule.String(),
+						symbol))
+				}
+
+				// Keep the symbols along the path, so that exported symbols in
+				// the component are still in parsing tree
+				path := []Symbol{}
+				for _, v := range ShortestPath(next, Vertex(symbol), Vertex(targetSymbol)) {
+					path = append(path, Symbol(v))
+				}
+				path = append(path, targetRule.Path...)
+				newRules = append(newRules, &Rule{
 					Left: symbol,
 					Right: targetRule.Right,
-					Weight: innerProb * transProb * targetRule.Weight})
+					Weight: weight,
+					Path: path,
+					Order: targetRule.Order})
 			}
 		}
 	}
+	g.addRules(newRules...)
 
 	// Remove useless rules in this strong component, including
 	//   - Strong connected rules, like A -> C in strong component [A, B, C]
 	//   - Unreferenced rules outside the component
-	rules := []*Rule{}
+	removed := map[*Rule]bool{}
 	for _, rule := range g.Rules {
 		if rule.IsUnary() && component[rule.Left] && component[rule.Right[0]] {
-			continue
+			removed[rule] = true
 		}
 		if internals[rule.Left] {
-			continue
+			removed[rule] = true
 		}
-		rules = append(rules, rule)
 	}
-	g.Rules = rules
+	g.removeRules(removed)
+	return nil
 }
 
 // removeStrongComponents removes all strong components from graph
-func (g *Grammar) removeStrongComponents() {
+func (g *Grammar) removeStrongComponents() error {
 	components := g.findStrongComponents()
+	g.indexOccurs()
+	defer g.dropOccurs()
 	for _, component := range components {
-		g.removeStrongComponent(component)
+		if err := g.removeStrongComponent(component); err != nil {
+			return err
+		}
 	}
 
 	// Remove rules like X -> X
-	rules := []*Rule{}
+	removed := map[*Rule]bool{}
 	for _, rule := range g.Rules {
 		if rule.IsUnary() && rule.Left == rule.Right[0] {
-			continue
+			removed[rule] = true
 		}
-		rules = append(rules, rule)
 	}
-	g.Rules = rules
-	g.normalizeWeight()
+	g.removeRules(removed)
+	g.renormalizeWeight()
+	return nil
 }
 
-// Remove one unit rule (left -> right) from grammar
-func (g *Grammar) removeUnitRule(left, right Symbol) {
+// Remove one unit rule (left -> right) from grammar. right should have no unit
+// rules, so that no unit rules are added. The rules removed are dropped from
+// the occurs index only and added into removed, which are filtered out of
+// g.Rules by the caller at last
+func (g *Grammar) removeUnitRule(left, right Symbol, removed map[*Rule]bool) {
 	occursLeft := g.occursLeft()
 	occursRight := g.occursRight()
 
-	// Find rule: left -> right
+	// Find rules: left -> right, all of them are removed so that their weights
+	// are summed up
 	weight := 0.0
+	order := -1
+	unitRules := []*Rule{}
 	for _, rule := range occursLeft[left] {
 		if rule.IsUnary() && rule.Right[0] == right {
-			weight = rule.Weight
-			break
+			weight += rule.Weight
+			if order < 0 {
+				order = rule.Order
+			}
+			unitRules = append(unitRules, rule)
 		}
 	}
 
-	// For any rule like "right -> BC; pr", add rule "left -> BC; weight * pr"
+	// For any rule like "right -> BC; pr", add rule "left -> BC; weight * pr".
+	// It takes the order of left -> right, which is the alternative authored
+	// for left
+	newRules := []*Rule{}
 	for _, rule := range occursLeft[right] {
 		path := []Symbol{right}
 		if rule.Path != nil {
 			path = append(path, rule.Path...)
 		}
-		g.Rules = append(g.Rules, &Rule{
+		newRule := &Rule{
 			Left: left,
 			Right: rule.Right,
 			Weight: rule.Weight * weight,
-			Path: path})
+			Path: path,
+			Order: order}
+		newRules = append(newRules, newRule)
+		g.audit(
+			AuditPhaseUnitRules,
+			AuditAdded,
+			newRule,
+			fmt.Sprintf("unit rule %s ::= %s applied to '%s'", left, right, rule.String()))
 	}
 
 	// Checks if right is only referenced by left
-	isRightUseless := len(occursRight[right]) == 1
+	isRightUseless := len(occursRight[right]) == len(unitRules)
+	g.addRules(newRules...)
 
 	// Remove rule left -> right. If isRightUseless == true, remove rules like
 	// right -> ..
-	rules := []*Rule{}
-	for _, rule := range g.Rules {
-		// Remove the rule: left -> right
-		if rule.IsUnary() && rule.Left == left && rule.Right[0] == right {
-			continue
+	step := map[*Rule]bool{}
+	if isRightUseless {
+		for _, rule := range occursLeft[right] {
+			g.audit(
+				AuditPhaseUnitRules,
+				AuditRemoved,
+				rule,
+				fmt.Sprintf("%s is only referenced by the removed unit rule", right))
+			step[rule] = true
 		}
-
-		// Remove rules: right -> ... when needed
-		if isRightUseless && rule.Left == right {
-			continue
-		}
-		rules = append(rules, rule)
 	}
-	g.Rules = rules
+	for _, rule := range unitRules {
+		g.audit(AuditPhaseUnitRules, AuditRemoved, rule, "unit rule")
+		step[rule] = true
+	}
+	g.unindexRules(step)
+	for rule := range step {
+		removed[rule] = true
+	}
 }
 
-// removeUnitRules removes unit rules like A -> B, B -> C
+// removeUnitRules removes unit rules like A -> B, B -> C. There should be no
+// cycles of unit rules (see removeStrongComponents). Symbols are visited in
+// reversed topological order of the dependency graph, so that the unit rules
+// of right are removed before left -> right, and the graph is built only once
 func (g *Grammar) removeUnitRules() {
-	// Get unit rules in reversed topological order
-	for {
-		graph := NewDirectedGraph()
-		hasUnaryRule := false
-		for _, rule := range g.Rules {
-			if rule.IsUnary() && !rule.Right[0].IsTerminal() {
-				graph.Add(Vertex(rule.Left), Vertex(rule.Right[0]), rule.Weight)
-				hasUnaryRule = true
+	g.indexOccurs()
+	defer g.dropOccurs()
+
+	graph := g.DependencyGraph()
+	order := graph.TopologicalSort()
+	removed := map[*Rule]bool{}
+	for i := len(order) - 1; i >= 0; i-- {
+		left := Symbol(order[i])
+		rights := []Symbol{}
+		visited := map[Symbol]bool{}
+		for _, rule := range g.occursLeft()[left] {
+			if rule.IsUnary() && !rule.Right[0].IsTerminal() && !visited[rule.Right[0]] {
+				visited[rule.Right[0]] = true
+				rights = append(rights, rule.Right[0])
 			}
 		}
-		if !hasUnaryRule {
-			break
-		}
-
-		// Find a leaf rule
-		graphT := graph.Transpose()
-		leafVertex := graphT.TopologicalSort()[0]
-		visited := map[Vertex]bool{}
-		leafRules := graphT.DFS(leafVertex, visited)
-
-		left := leafRules[1]
-		right := leafRules[0]
-		if graph.HasArc(left, right) {
-			// Rule: left -> right exists
+		for _, right := range rights {
 			if g.isDebug {
 				log.Printf("removeUnitRule: %s ::= %s\n", left, right)
 			}
-			g.removeUnitRule(Symbol(left), Symbol(right))
+			g.removeUnitRule(left, right, removed)
 		}
 	}
+	g.Rules = filterRules(g.Rules, removed)
 }
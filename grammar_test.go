@@ -0,0 +1,924 @@
+package pcfg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<a> ::= <b> ; 0.5 | x ; 0.5
+		<b> ::= <a> | <c> y
+		<root> ::= <a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := grammar.DependencyGraph()
+
+	// Arcs of unary non-terminal rules only
+	arcs := [][2]Vertex{{"<a>", "<b>"}, {"<b>", "<a>"}, {"<root>", "<a>"}}
+	for _, arc := range arcs {
+		if !graph.HasArc(arc[0], arc[1]) {
+			t.Fatalf("arc %s -> %s expected", arc[0], arc[1])
+		}
+	}
+	if len(graph.Vertices) != 3 {
+		t.Fatalf("len(graph.Vertices) == 3 expected, but got %d", len(graph.Vertices))
+	}
+
+	components := graph.StrongComponents()
+	if len(components) != 1 || len(components[0]) != 2 {
+		t.Fatalf("one strong component [<a> <b>] expected, but got %v", components)
+	}
+
+	// No strong component in DAG, whichever vertex DFS starts from
+	dag := NewDirectedGraph()
+	dag.Add("<c>", "<a>", 1)
+	dag.Add("<c>", "<b>", 1)
+	dag.Add("<a>", "<b>", 1)
+	for i := 0; i < 20; i++ {
+		if components := dag.StrongComponents(); len(components) != 0 {
+			t.Fatalf("no strong component expected, but got %v", components)
+		}
+	}
+
+	expected := `digraph G {
+  "<a>";
+  "<b>";
+  "<root>";
+  "<a>" -> "<b>" [label="0.5"];
+  "<b>" -> "<a>" [label="1"];
+  "<root>" -> "<a>" [label="1"];
+}
+`
+	if graph.DOT() != expected {
+		t.Fatalf("'%s' != '%s'", graph.DOT(), expected)
+	}
+}
+
+// sharedPrefixGrammar is a grammar that many rules share common prefixes
+const sharedPrefixGrammar = `
+<city> ::= seattle | beijing
+<time> ::= today | tomorrow
+<root> ::= what's the weather in <city> | what's the weather in <city> <time> | what's the weather like in <city> | what's the weather like in <city> <time> ; 2.0
+;!exports: <city> <time>`
+
+func TestRemoveDuplicateUnitRules(t *testing.T) {
+	// Both of the unit rules <a> ::= <b> are removed, so that their weights
+	// are summed up
+	grammar := &Grammar{
+		Rules: []*Rule{
+			{Left: "<root>", Right: []Symbol{"<a>", "x"}, Weight: 1.0},
+			{Left: "<a>", Right: []Symbol{"<b>"}, Weight: 0.3},
+			{Left: "<a>", Right: []Symbol{"z"}, Weight: 0.2},
+			{Left: "<a>", Right: []Symbol{"<b>"}, Weight: 0.5},
+			{Left: "<b>", Right: []Symbol{"y"}, Weight: 1.0},
+		},
+	}
+	grammar.removeUnitRules()
+	weight := 0.0
+	for _, rule := range grammar.Rules {
+		if rule.IsUnary() && !rule.Right[0].IsTerminal() {
+			t.Fatalf("unit rule '%s' not removed", rule.String())
+		}
+		if rule.Left == "<a>" && rule.Right[0] == "y" {
+			weight += rule.Weight
+		}
+	}
+	if math.Abs(weight - 0.8) > 1e-9 {
+		t.Fatalf("%f != 0.8", weight)
+	}
+}
+
+func TestFactorPrefixes(t *testing.T) {
+	queries := []string{
+		"what's the weather in seattle",
+		"what's the weather in seattle tomorrow",
+		"what's the weather like in beijing",
+		"what's the weather like in beijing today",
+		"what's the weather like",
+	}
+
+	grammar, err := ParseGrammar(sharedPrefixGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, err := NewParserFromGrammar(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factoredGrammar, err := ParseGrammar(sharedPrefixGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factoredGrammar.FactorPrefixes(true)
+	factoredParser, err := NewParserFromGrammar(factoredGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(factoredGrammar.Rules) >= len(grammar.Rules) {
+		t.Fatalf(
+			"fewer rules expected, but got %d >= %d",
+			len(factoredGrammar.Rules),
+			len(grammar.Rules))
+	}
+
+	// Both should output the same tree with the same probability
+	for _, query := range queries {
+		words := strings.Fields(query)
+		tree := parser.Parse(words)
+		factoredTree := factoredParser.Parse(words)
+		if fmt.Sprint(tree) != fmt.Sprint(factoredTree) {
+			t.Fatalf("'%v' != '%v'", tree, factoredTree)
+		}
+	}
+}
+
+func benchmarkHigherRules(b *testing.B, factorPrefixes bool) {
+	rules := 0
+	for i := 0; i < b.N; i++ {
+		grammar, err := ParseGrammar(sharedPrefixGrammar)
+		if err != nil {
+			b.Fatal(err)
+		}
+		grammar.FactorPrefixes(factorPrefixes)
+		grammar.ConvertToCNF()
+		rules = len(grammar.Rules)
+	}
+	b.ReportMetric(float64(rules), "rules")
+}
+
+func BenchmarkReduceHigherRules(b *testing.B) {
+	benchmarkHigherRules(b, false)
+}
+
+func BenchmarkFactorHigherRules(b *testing.B) {
+	benchmarkHigherRules(b, true)
+}
+
+func TestApplyWeights(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle ; 0.8 | beijing ; 0.2
+		<root> ::= weather in <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = grammar.ApplyWeights(map[string]float64{
+		"<city> ::= seattle": 1.0,
+		"<city> ::= beijing": 3.0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.ConvertToCNF()
+
+	expected := map[string]float64{
+		"<city> ::= seattle": 0.25,
+		"<city> ::= beijing": 0.75,
+	}
+	found := 0
+	for _, rule := range grammar.Rules {
+		if weight, ok := expected[rule.Id()]; ok {
+			found++
+			if rule.Weight != weight {
+				t.Fatalf("%s: weight %f expected", rule.String(), weight)
+			}
+		}
+	}
+	if found != len(expected) {
+		t.Fatalf("%d rules expected, but found %d", len(expected), found)
+	}
+
+	// Failed cases
+	grammar, err = ParseGrammar("<root> ::= weather")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = grammar.ApplyWeights(map[string]float64{"<root> ::= rain": 1.0})
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+	err = grammar.ApplyWeights(map[string]float64{"<root> ::= weather": -1.0})
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
+
+func TestReduceHigherRules(t *testing.T) {
+	// Rules with right side of length 1 to 4
+	grammar := &Grammar{
+		Rules: []*Rule{
+			{Left: "<a>", Right: []Symbol{"<b>"}, Weight: 1.0},
+			{Left: "<a>", Right: []Symbol{"<b>", "<c>"}, Weight: 1.0},
+			{Left: "<a>", Right: []Symbol{"<b>", "<c>", "<d>"}, Weight: 1.0},
+			{Left: "<b>", Right: []Symbol{"<a>", "<b>", "<c>", "<d>"}, Weight: 1.0},
+			{Left: "<a>", Right: []Symbol{"<d>", "<c>", "<b>"}, Weight: 1.0},
+		},
+	}
+	err := grammar.reduceHigherRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"<a> ::= <b> ; 1.000",
+		"<a> ::= <b> <c> ; 1.000",
+		"<a> ::= <b> <__x_a_1> ; 1.000",
+		"<__x_a_1> ::= <c> <d> ; 1.000",
+		"<b> ::= <a> <__x_b_1> ; 1.000",
+		"<__x_b_1> ::= <b> <__x_b_2> ; 1.000",
+		"<__x_b_2> ::= <c> <d> ; 1.000",
+		"<a> ::= <d> <__x_a_2> ; 1.000",
+		"<__x_a_2> ::= <c> <b> ; 1.000",
+	}
+	if len(grammar.Rules) != len(expected) {
+		t.Fatalf("%d rules expected, but got %d", len(expected), len(grammar.Rules))
+	}
+	for i, rule := range grammar.Rules {
+		if rule.String() != expected[i] {
+			t.Fatalf("'%s' != '%s'", rule.String(), expected[i])
+		}
+	}
+
+	// Failed case: rule with empty right side
+	grammar = &Grammar{
+		Rules: []*Rule{
+			{Left: "<a>", Right: []Symbol{}, Weight: 1.0},
+		},
+	}
+	if err = grammar.reduceHigherRules(); err == nil {
+		t.Fatal("err != nil expected")
+	}
+	grammar = &Grammar{
+		Rules: []*Rule{
+			{Left: "<a>", Right: []Symbol{}, Weight: 1.0},
+		},
+	}
+	if err = grammar.factorHigherRules(); err == nil {
+		t.Fatal("err != nil expected")
+	}
+	if _, err = NewParser("<root> ::= a | "); err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
+
+func TestAnnotateParents(t *testing.T) {
+	grammarText := `
+		<noun> ::= bob
+		<name> ::= bob
+		<np> ::= <noun> | <name>
+		<subject> ::= <np>
+		<object> ::= <np>
+		<root> ::= <subject> sees <object>
+		;!exports: <subject> <object> <noun> <name>`
+	query := strings.Fields("bob sees bob")
+
+	grammar, err := ParseGrammar(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.AnnotateParents()
+	err = grammar.ApplyWeights(map[string]float64{
+		"<np^subject> ::= <noun^np>": 0.1,
+		"<np^subject> ::= <name^np>": 0.9,
+		"<np^object> ::= <noun^np>": 0.9,
+		"<np^object> ::= <name^np>": 0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, err := NewParserFromGrammar(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// <np> derives <name> under <subject> and <noun> under <object>
+	expected := "(<root> \n  (<subject> \n    (<name> \n      bob)) \n  sees \n  (<object> \n    (<noun> \n      bob)))"
+	tree := parser.Parse(query)
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+func TestAnnotateParentsDistinct(t *testing.T) {
+	// <sub-ject> and <sub_ject> have the same Symbol.Text
+	grammar, err := ParseGrammar(`
+		<np> ::= bob | alice
+		<sub-ject> ::= <np>
+		<sub_ject> ::= <np>
+		<root> ::= <sub-ject> sees <sub_ject>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.AnnotateParents()
+	err = grammar.ApplyWeights(map[string]float64{
+		"<np^sub-ject> ::= alice": 0.1,
+		"<np^sub_ject> ::= bob": 0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grammar.Rules) != 7 {
+		t.Fatalf("%d != 7", len(grammar.Rules))
+	}
+}
+
+func TestSynonyms(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		;!synonyms: <size> = big large huge ; 0.6
+		<size> ::= small ; 0.4
+		<root> ::= <size> box
+		;!exports: <size>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+	for _, synonym := range []string{"big", "large", "huge"} {
+		// TestCase-1: the normalized weight
+		rules := cnfGrammar.TerminalRules[synonym]
+		if len(rules) != 1 || math.Abs(float64(rules[0].Probability) - 0.2) > 1e-6 {
+			t.Fatalf("weight 0.2 expected for '%s', but got %v", synonym, rules)
+		}
+
+		// TestCase-2: parsing
+		tree := CYK(cnfGrammar, []string{synonym, "box"})
+		expected := fmt.Sprintf("(<root> \n  (<size> \n    %s) \n  box)", synonym)
+		if tree == nil || tree.String() != expected {
+			t.Fatalf("'%v' != '%s'", tree, expected)
+		}
+	}
+
+	// TestCase-3: invalid commands
+	for _, text := range []string{
+		";!synonyms: <size> big",
+		";!synonyms: size = big",
+		";!synonyms: <size> = <big>",
+		";!synonyms: <size> = big ; x",
+	} {
+		if _, err := ParseGrammar(text); err == nil {
+			t.Fatalf("err != nil expected for '%s'", text)
+		}
+	}
+}
+
+func TestGazetteer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"grammar.txt": "<root> ::= weather in <city>\n;!gazetteer: <city> data/cities.txt\n;!exports: <city>\n",
+		"data/cities.txt": "seattle\n\nnew york\nbeijing\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// TestCase-1: entries with uniform weight
+	grammar, err := ParseGrammarFile(filepath.Join(dir, "grammar.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"<root> ::= weather in <city>",
+		"<city> ::= seattle",
+		"<city> ::= new york",
+		"<city> ::= beijing",
+	}
+	if len(grammar.Rules) != len(expected) {
+		t.Fatalf("%d != %d", len(grammar.Rules), len(expected))
+	}
+	for i, rule := range grammar.Rules {
+		if rule.Id() != expected[i] {
+			t.Fatalf("'%s' != '%s'", rule.Id(), expected[i])
+		}
+		if i > 0 && math.Abs(rule.Weight - 1.0 / 3) > 1e-9 {
+			t.Fatalf("%f != %f", rule.Weight, 1.0 / 3)
+		}
+	}
+
+	// TestCase-2: parse an entry
+	parser, err := NewParserFromGrammar(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse(strings.Fields("weather in new york"))
+	expectedTree := "(<root> \n  weather \n  in \n  (<city> \n    new \n    york))"
+	if tree == nil || tree.String() != expectedTree {
+		t.Fatalf("'%v' != '%s'", tree, expectedTree)
+	}
+
+	// TestCase-3: missing file
+	if _, err := ParseGrammar(";!gazetteer: <city> " + filepath.Join(dir, "missing.txt")); err == nil {
+		t.Fatalf("err != nil expected")
+	}
+}
+
+// writeFiles writes the files of name to content under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"weather.pcfg": "<root> ::= weather in <city>\n;!include: shared/cities.pcfg\n<city> ::= beijing\n",
+		"shared/cities.pcfg": "<city> ::= seattle\n;!include: more/cities.pcfg\n;!exports: <city>\n",
+		"shared/more/cities.pcfg": "<city> ::= new york\n",
+		"a.pcfg": "<root> ::= a\n;!include: b.pcfg\n",
+		"b.pcfg": "<root> ::= b\n;!include: a.pcfg\n",
+		"bad.pcfg": "<root> ::= x\n;!include: shared/bad.pcfg\n",
+		"shared/bad.pcfg": "<root> ::= y\n\n<root> ::= z ; abc\n",
+	})
+
+	// TestCase-1: rules of <city> from 3 files are unioned
+	grammar, err := ParseGrammarFile(filepath.Join(dir, "weather.pcfg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"<root> ::= weather in <city>",
+		"<city> ::= seattle",
+		"<city> ::= new york",
+		"<city> ::= beijing",
+	}
+	if len(grammar.Rules) != len(expected) {
+		t.Fatalf("%d != %d", len(grammar.Rules), len(expected))
+	}
+	for i, rule := range grammar.Rules {
+		if rule.Id() != expected[i] {
+			t.Fatalf("'%s' != '%s'", rule.Id(), expected[i])
+		}
+		if rule.Order != i {
+			t.Fatalf("%d != %d", rule.Order, i)
+		}
+	}
+	if !grammar.Exports["<city>"] {
+		t.Fatal("<city> exported expected")
+	}
+	parser, err := NewParserFromGrammar(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse(strings.Fields("weather in new york"))
+	expectedTree := "(<root> \n  weather \n  in \n  (<city> \n    new \n    york))"
+	if tree == nil || tree.String() != expectedTree {
+		t.Fatalf("'%v' != '%s'", tree, expectedTree)
+	}
+
+	// TestCase-2: cycle
+	_, err = ParseGrammarFile(filepath.Join(dir, "a.pcfg"))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("error of cycle expected, but got %v", err)
+	}
+
+	// TestCase-3: file and line of the error in nested file
+	_, err = ParseGrammarFile(filepath.Join(dir, "bad.pcfg"))
+	if err == nil || !strings.Contains(err.Error(), filepath.Join("shared", "bad.pcfg") + " line 3") {
+		t.Fatalf("error at line 3 of shared/bad.pcfg expected, but got %v", err)
+	}
+
+	// TestCase-4: missing file
+	if _, err := ParseGrammar(";!include: " + filepath.Join(dir, "missing.pcfg")); err == nil {
+		t.Fatalf("err != nil expected")
+	}
+}
+
+func TestParseGrammarLineNumber(t *testing.T) {
+	// TestCase-1: bad rule on line 3
+	_, err := ParseGrammar(`<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		<root> ::= <city> weather ; high`)
+	prefix := "grammar line 3: "
+	if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+		t.Fatalf("'%v' should start with '%s'", err, prefix)
+	}
+
+	// TestCase-2: bad export symbol on line 2
+	_, err = ParseGrammarReader(strings.NewReader("<root> ::= weather\n;!exports: time\n"))
+	prefix = "grammar line 2: "
+	if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+		t.Fatalf("'%v' should start with '%s'", err, prefix)
+	}
+}
+
+func TestParseGrammarAll(t *testing.T) {
+	grammar, errs := ParseGrammarAll(`
+		<city> ::= seattle | beijing
+		<time> = today
+		<root> ::= weather in <city>
+		;!exports: <city> time
+		<root> ::= <city> weather ; high
+		<root> ::= weather <time>`)
+	if len(errs) != 3 {
+		t.Fatalf("3 errors expected, but got %v", errs)
+	}
+
+	// TestCase-1: errors with line numbers
+	for i, line := range []int{3, 5, 6} {
+		prefix := fmt.Sprintf("ParseGrammarAll: line %d: ", line)
+		if !strings.HasPrefix(errs[i].Error(), prefix) {
+			t.Fatalf("'%s' should start with '%s'", errs[i].Error(), prefix)
+		}
+	}
+
+	// TestCase-2: partial grammar
+	if len(grammar.Rules) != 4 {
+		t.Fatalf("4 rules expected, but got %d", len(grammar.Rules))
+	}
+
+	// TestCase-3: no errors
+	if _, errs := ParseGrammarAll("<root> ::= weather"); len(errs) != 0 {
+		t.Fatalf("no errors expected, but got %v", errs)
+	}
+}
+
+func TestNormalizeUnicode(t *testing.T) {
+	nfc := "caf\u00e9"
+	nfd := "cafe\u0301"
+	text := fmt.Sprintf(`
+		<place> ::= %s | park
+		<root> ::= go to <place>
+		;!exports: <place>`, nfc)
+
+	// TestCase-1: byte-exact matching by default
+	grammar, err := ParseGrammar(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+	if tree := CYK(cnfGrammar, []string{"go", "to", nfd}); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+
+	// TestCase-2: both NFC and NFD match with normalization
+	grammar, err = ParseGrammar(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.NormalizeUnicode(true)
+	cnfGrammar = grammar.ConvertToCNF()
+	for _, word := range []string{nfc, nfd} {
+		if tree := CYK(cnfGrammar, []string{"go", "to", word}); tree == nil {
+			t.Fatalf("tree != nil expected for '%+q'", word)
+		}
+	}
+}
+
+func TestMinProbability(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle ; 100 | beijing ; 10 | paris ; 0.01 | rome ; 0.001
+		<root> ::= weather in <city> ; 1000 | <city> weather ; 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	floor := 0.05
+	grammar.MinProbability(floor)
+	cnfGrammar := grammar.ConvertToCNF()
+
+	// TestCase-1: terminal rules of <city>
+	sum := 0.0
+	cityId := cnfGrammar.SymbolIds["<city>"]
+	for _, city := range []string{"seattle", "beijing", "paris", "rome"} {
+		for _, rule := range cnfGrammar.TerminalRules[city] {
+			if rule.Source != cityId {
+				continue
+			}
+			if float64(rule.Probability) < floor - probTolerance {
+				t.Fatalf("P(<city> -> %s) = %f < %f", city, rule.Probability, floor)
+			}
+			sum += float64(rule.Probability)
+		}
+	}
+	if math.Abs(sum - 1) > probTolerance {
+		t.Fatalf("%f != 1", sum)
+	}
+
+	// TestCase-2: all rules
+	for _, secondRules := range cnfGrammar.Rules {
+		for _, rules := range secondRules {
+			for _, rule := range rules {
+				if float64(rule.Probability) < floor - probTolerance {
+					t.Fatalf("P(%s) = %f < %f", cnfGrammar.Symbols[rule.Source], rule.Probability, floor)
+				}
+			}
+		}
+	}
+
+	// TestCase-3: floor higher than 1/n
+	grammar, err = ParseGrammar(`<root> ::= a ; 1 | b ; 100 | c ; 1000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.MinProbability(0.5)
+	cnfGrammar = grammar.ConvertToCNF()
+	for _, word := range []string{"a", "b", "c"} {
+		if p := float64(cnfGrammar.TerminalRules[word][0].Probability); math.Abs(p - 1.0 / 3.0) > probTolerance {
+			t.Fatalf("P(<root> -> %s) = %f != 1/3", word, p)
+		}
+	}
+}
+
+func TestPrior(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		;!prior: <city> 0.5
+		<city> ::= seattle ; 7 | beijing ; 2 | paris ; 0
+		<root> ::= weather in <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+
+	// Posterior mean (count + alpha) / (total + n * alpha)
+	for city, count := range map[string]float64{"seattle": 7, "beijing": 2, "paris": 0} {
+		expected := (count + 0.5) / (9 + 3 * 0.5)
+		p := float64(cnfGrammar.TerminalRules[city][0].Probability)
+		if math.Abs(p - expected) > probTolerance {
+			t.Fatalf("P(<city> -> %s): %f != %f", city, p, expected)
+		}
+	}
+
+	// Invalid commands
+	for _, text := range []string{";!prior: <city>", ";!prior: city 0.5", ";!prior: <city> -1"} {
+		if _, err := ParseGrammar(text); err == nil {
+			t.Fatalf("err != nil expected for '%s'", text)
+		}
+	}
+}
+
+func TestStrongComponentUnderflow(t *testing.T) {
+	// <a> -> <b> -> y is 1e-400, which underflows to 0
+	grammar, err := ParseGrammar(`
+		<a> ::= <b> ; 1e-200 | x
+		<b> ::= <a> ; 1e-200 | y
+		<root> ::= <a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = grammar.convertToCNF(); err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
+
+// strongComponentsGrammar returns a grammar with n strong components of unit
+// rules, <a_i> -> <b_i> -> <a_i>, and n chains of unit rules
+func strongComponentsGrammar(n int) string {
+	lines := []string{}
+	roots := []string{}
+	for i := 0; i < n; i++ {
+		lines = append(lines,
+			fmt.Sprintf("<a%d> ::= <b%d> | x%d | <c%d> y%d", i, i, i, i, i),
+			fmt.Sprintf("<b%d> ::= <a%d> | y%d | <c%d>", i, i, i, i),
+			fmt.Sprintf("<c%d> ::= <d%d> | z%d", i, i, i),
+			fmt.Sprintf("<d%d> ::= w%d | w%d w%d", i, i, i, i))
+		roots = append(roots, fmt.Sprintf("<a%d>", i), fmt.Sprintf("<b%d> x%d", i, i))
+	}
+	lines = append(lines, "<root> ::= " + strings.Join(roots, " | "))
+	return strings.Join(lines, "\n")
+}
+
+func BenchmarkConvertStrongComponents(b *testing.B) {
+	grammarText := strongComponentsGrammar(200)
+	for i := 0; i < b.N; i++ {
+		grammar, err := ParseGrammar(grammarText)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = grammar.convertToCNF(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// gazetteerGrammar returns a grammar with n terminal alternatives of <city>
+func gazetteerGrammar(n int) string {
+	cities := []string{}
+	for i := 0; i < n; i++ {
+		if i % 2 == 0 {
+			cities = append(cities, fmt.Sprintf("city%d", i))
+		} else {
+			cities = append(cities, fmt.Sprintf("new city%d", i))
+		}
+	}
+	return `
+		<city> ::= ` + strings.Join(cities, " | ") + `
+		<root> ::= weather in <city> | <city> weather`
+}
+
+func TestConvertGazetteerAllocs(t *testing.T) {
+	const n = 10000
+	grammarText := gazetteerGrammar(n)
+	allocs := testing.AllocsPerRun(1, func() {
+		grammar, err := ParseGrammar(grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = grammar.convertToCNF(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Parsing and conversion should allocate a few objects per alternative
+	if allocs > 25 * n {
+		t.Fatalf("%.0f allocs for %d alternatives", allocs, n)
+	}
+}
+
+func BenchmarkConvertGazetteer(b *testing.B) {
+	grammarText := gazetteerGrammar(50000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		grammar, err := ParseGrammar(grammarText)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = grammar.convertToCNF(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// sentenceDistribution returns the probability of each sentence derived from
+// symbol in g, whose rules should be normalized and not recursive. The
+// sentences are tokens joined by space
+func sentenceDistribution(g *Grammar, symbol Symbol) map[string]float64 {
+	if symbol == EpsilonSymbol {
+		return map[string]float64{"": 1}
+	} else if symbol.IsTerminal() {
+		return map[string]float64{string(symbol): 1}
+	}
+	distribution := map[string]float64{}
+	for _, rule := range g.Rules {
+		if rule.Left != symbol {
+			continue
+		}
+		sentences := map[string]float64{"": rule.Weight}
+		for _, right := range rule.Right {
+			next := map[string]float64{}
+			for prefix, p := range sentences {
+				for sentence, q := range sentenceDistribution(g, right) {
+					next[strings.TrimSpace(prefix + " " + sentence)] += p * q
+				}
+			}
+			sentences = next
+		}
+		for sentence, p := range sentences {
+			distribution[sentence] += p
+		}
+	}
+	return distribution
+}
+
+func TestPreserveDistribution(t *testing.T) {
+	grammarText := `
+		<root> ::= <a> y | x <b> <c> | x y z <b> w | <c> <c> <c>
+		<a> ::= <nil> ; 0.2 | <b> ; 0.3 | x ; 0.5
+		<b> ::= <nil> ; 0.5 | z ; 0.5
+		<c> ::= <b> w | <nil> | <a> <b>`
+	grammar, err := ParseGrammar(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.normalizeWeight()
+	expected := sentenceDistribution(grammar, RootSymbol)
+
+	// The empty sentence is never parsed, others are conditioned on non-empty
+	empty := expected[""]
+	delete(expected, "")
+	for sentence := range expected {
+		expected[sentence] /= 1 - empty
+	}
+
+	grammar, err = ParseGrammar(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.PreserveDistribution(true)
+	cnfGrammar, err := grammar.convertToCNF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sentences of probability 0 come from the rules whose symbols always
+	// derive the empty string
+	actual := map[string]float64{}
+	for _, sentence := range cnfGrammar.Expand(string(RootSymbol), 10) {
+		if sentence.Probability > 0 {
+			actual[strings.Join(sentence.Tokens, " ")] = sentence.Probability
+		}
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("%d != %d", len(actual), len(expected))
+	}
+	for sentence, p := range expected {
+		if math.Abs(actual[sentence] - p) > probTolerance {
+			t.Fatalf("%s: %f != %f", sentence, actual[sentence], p)
+		}
+	}
+}
+
+func TestConversionReport(t *testing.T) {
+	cases := []struct {
+		grammarText string
+
+		// Minimum drift expected, 0 for no approximation
+		drift float64
+	}{
+		// TestCase-1: no approximation
+		{`
+			<city> ::= seattle | beijing
+			<root> ::= weather in <city> | <city>`, 0},
+
+		// TestCase-2: null rule removed
+		{`
+			<a> ::= <nil> ; 0.25 | x ; 0.75
+			<root> ::= <a> y`, 0.25},
+
+		// TestCase-3: null rules and cycles, <nil> and the cycle <b> -> <a> ->
+		// <b> are removed from <b>
+		{`
+			<a> ::= <b> ; 0.5 | x ; 0.5
+			<b> ::= <a> ; 0.5 | <nil> ; 0.3 | y ; 0.2
+			<root> ::= <a> z | <b>`, 0.3},
+	}
+	for i, c := range cases {
+		grammar, err := ParseGrammar(c.grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grammar.ConvertToCNF()
+		report := grammar.ConversionReport()
+		if c.drift == 0 && (report.Approximated || report.ProbabilityDrift != 0) {
+			t.Fatalf("case %d: no approximation expected, but got %v", i + 1, report)
+		}
+		if c.drift > 0 && (!report.Approximated || report.ProbabilityDrift < c.drift - 1e-9) {
+			t.Fatalf("case %d: drift >= %f expected, but got %v", i + 1, c.drift, report)
+		}
+	}
+}
+
+func TestConvertToCNFTemperature(t *testing.T) {
+	grammarText := `
+		<a> ::= w ; 0.5 | v ; 0.5
+		<b> ::= w
+		<root> ::= <a> ; 0.6 | <b> ; 0.4
+		;!exports: <a> <b>`
+	query := []string{"w"}
+	bestTree := func(temperature float64) *Tree {
+		grammar, err := ParseGrammar(grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return CYK(grammar.ConvertToCNFTemperature(temperature), query)
+	}
+
+	// TestCase-1: <b> is the best derivation, 0.4 > 0.6 * 0.5
+	expected := "(<root> \n  (<b> \n    w))"
+	if tree := bestTree(1); tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: at low temperature, the rule of the highest weight from
+	// <root> dominates
+	expected = "(<root> \n  (<a> \n    w))"
+	for _, temperature := range []float64{0.2, 0.01, 0.0001} {
+		if tree := bestTree(temperature); tree == nil || tree.String() != expected {
+			t.Fatalf("T = %f: '%v' != '%s'", temperature, tree, expected)
+		}
+	}
+
+	// TestCase-3: at high temperature, <root> -> <b> is close to 0.5
+	grammar, err := ParseGrammar(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.ConvertToCNFTemperature(100)
+	for _, rule := range grammar.Rules {
+		if rule.Left == RootSymbol && len(rule.Path) > 0 && rule.Path[0] == "<b>" && math.Abs(rule.Weight - 0.5) > 0.01 {
+			t.Fatalf("%s: 0.5 expected", rule.String())
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package pcfg
+
+import (
+	"sort"
+	"strings"
+)
+
+// Inventory is the symbols that a grammar contains, see
+// Grammar.SymbolInventory
+type Inventory struct {
+	// Sorted terminals and non-terminals in rules, exports and atomic symbols.
+	// <nil> is not included
+	Terminals []Symbol
+	NonTerminals []Symbol
+
+	// Sorted symbols that fail Symbol.IsValid, which are neither terminals nor
+	// non-terminals
+	Invalid []Symbol
+
+	// Number of rules in grammar
+	Rules int
+}
+
+// SymbolInventory returns the complete inventory of symbols in grammar for
+// reviewing, with the whitespace around symbols trimmed. Like Lint, it should
+// be called before ConvertToCNF, which adds internal symbols
+func (g *Grammar) SymbolInventory() *Inventory {
+	symbols := map[Symbol]bool{}
+	for _, rule := range g.Rules {
+		symbols[rule.Left] = true
+		for _, symbol := range rule.Right {
+			symbols[symbol] = true
+		}
+	}
+	for symbol := range g.Exports {
+		symbols[symbol] = true
+	}
+	for symbol := range g.atomics {
+		symbols[symbol] = true
+	}
+
+	inventory := &Inventory{
+		Terminals: []Symbol{},
+		NonTerminals: []Symbol{},
+		Invalid: []Symbol{},
+		Rules: len(g.Rules),
+	}
+	trimmed := map[Symbol]bool{}
+	for symbol := range symbols {
+		symbol = Symbol(strings.TrimSpace(string(symbol)))
+		if trimmed[symbol] || symbol == EpsilonSymbol {
+			continue
+		}
+		trimmed[symbol] = true
+		if !symbol.IsValid() {
+			inventory.Invalid = append(inventory.Invalid, symbol)
+		} else if symbol.IsTerminal() {
+			inventory.Terminals = append(inventory.Terminals, symbol)
+		} else {
+			inventory.NonTerminals = append(inventory.NonTerminals, symbol)
+		}
+	}
+	for _, list := range [][]Symbol{inventory.Terminals, inventory.NonTerminals, inventory.Invalid} {
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	}
+	return inventory
+}
@@ -0,0 +1,47 @@
+package pcfg
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSymbolInventory(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle | beijing
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <city> | "good morning"
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: terminals and non-terminals sorted
+	inventory := grammar.SymbolInventory()
+	expected := "[\"good morning\" beijing in seattle the weather what's]"
+	if fmt.Sprint(inventory.Terminals) != expected {
+		t.Fatalf("'%v' != '%s'", inventory.Terminals, expected)
+	}
+	expected = "[<city> <root> <whats>]"
+	if fmt.Sprint(inventory.NonTerminals) != expected {
+		t.Fatalf("'%v' != '%s'", inventory.NonTerminals, expected)
+	}
+	if len(inventory.Invalid) != 0 || inventory.Rules != 6 {
+		t.Fatalf("'%v' != '[]' or %d != 6", inventory.Invalid, inventory.Rules)
+	}
+
+	// TestCase-2: symbols added without parsing are trimmed, and the invalid
+	// ones are flagged
+	grammar.Rules = append(grammar.Rules, &Rule{
+		Left: " <city> ",
+		Right: []Symbol{" seattle", "<new york>", "new<york"},
+		Weight: 1.0,
+	})
+	inventory = grammar.SymbolInventory()
+	expected = "[<new york> new<york]"
+	if fmt.Sprint(inventory.Invalid) != expected {
+		t.Fatalf("'%v' != '%s'", inventory.Invalid, expected)
+	}
+	if len(inventory.Terminals) != 7 || len(inventory.NonTerminals) != 3 || inventory.Rules != 7 {
+		t.Fatalf("unexpected counts %d, %d, %d", len(inventory.Terminals), len(inventory.NonTerminals), inventory.Rules)
+	}
+}
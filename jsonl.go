@@ -0,0 +1,35 @@
+package pcfg
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// _JSONLine is a line in the output of ParseToJSONL
+type _JSONLine struct {
+	Query []string `json:"query"`
+	Tree *Node `json:"tree,omitempty"`
+	LogProb *float64 `json:"logprob,omitempty"`
+	Parsed bool `json:"parsed"`
+}
+
+// ParseToJSONL parses each query and writes the results to w in JSON lines
+// format, one line per query in the same order, like
+//     {"query":["seattle"],"tree":{...},"logprob":-0.69,"parsed":true}
+// For the query not matched, tree and logprob are omitted and parsed is false
+func (p *Parser) ParseToJSONL(w io.Writer, queries [][]string) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, query := range queries {
+		line := _JSONLine{Query: query}
+		if candidate := p.parse(query); candidate != nil {
+			line.Tree = candidate.Tree.Node
+			line.LogProb = &candidate.LogProb
+			line.Parsed = true
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
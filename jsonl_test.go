@@ -0,0 +1,61 @@
+package pcfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseToJSONL(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle ; 0.5 | beijing ; 0.5
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := [][]string{
+		strings.Fields("weather in seattle"),
+		strings.Fields("seattle weather"),
+		strings.Fields("weather in beijing"),
+	}
+	var buffer bytes.Buffer
+	if err = parser.ParseToJSONL(&buffer, queries); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) != len(queries) {
+		t.Fatalf("%d lines expected, but got %d", len(queries), len(lines))
+	}
+	// The log-probability is computed in Prob
+	logprob, _ := json.Marshal(float64(Prob(math.Log(0.5))))
+	expected := `{"query":["weather","in","seattle"],"tree":{"children":[{"symbol":"weather"},{"symbol":"in"},{"children":[{"symbol":"seattle"}],"symbol":"<city>"}],"symbol":"<root>"},"logprob":` + string(logprob) + `,"parsed":true}`
+	if lines[0] != expected {
+		t.Fatalf("'%s' != '%s'", lines[0], expected)
+	}
+
+	for i, line := range lines {
+		var result struct {
+			Query []string
+			Tree *Node
+			LogProb *float64
+			Parsed bool
+		}
+		if err = json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(result.Query, " ") != strings.Join(queries[i], " ") {
+			t.Fatalf("line %d: query '%v' expected", i, queries[i])
+		}
+		matched := i != 1
+		if result.Parsed != matched || (result.Tree != nil) != matched {
+			t.Fatalf("line %d: parsed == %v expected", i, matched)
+		}
+		if matched && math.Abs(*result.LogProb - math.Log(0.5)) > probTolerance {
+			t.Fatalf("line %d: logprob %f expected", i, math.Log(0.5))
+		}
+	}
+}
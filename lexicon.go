@@ -0,0 +1,119 @@
+package pcfg
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// _LexiconEntry is an entry in lexicon: word is derived from symbol with
+// weight
+type _LexiconEntry struct {
+	word Symbol
+	symbol Symbol
+	weight float64
+}
+
+// parseLexicon parses the lexicon from r. Each line of lexicon is like
+//     word <symbol> weight
+// weight is optional, 1.0 by default. Lines starting with ";" are comments
+func parseLexicon(r io.Reader) ([]_LexiconEntry, error) {
+	entries := []_LexiconEntry{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, errors.New(fmt.Sprintf(
+				"parseLexicon: line %d: unexpected number of fields in '%s'",
+				lineNumber,
+				line))
+		}
+		entry := _LexiconEntry{
+			word: Symbol(fields[0]),
+			symbol: Symbol(fields[1]),
+			weight: 1.0,
+		}
+		if !entry.word.IsValid() || !entry.word.IsTerminal() || entry.word == EpsilonSymbol {
+			return nil, errors.New(fmt.Sprintf(
+				"parseLexicon: line %d: unexpected word '%s'",
+				lineNumber,
+				entry.word))
+		}
+		if !entry.symbol.IsValid() || entry.symbol.IsTerminal() {
+			return nil, errors.New(fmt.Sprintf(
+				"parseLexicon: line %d: unexpected symbol '%s'",
+				lineNumber,
+				entry.symbol))
+		}
+		if len(fields) == 3 {
+			weight, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil || !(weight > 0) {
+				return nil, errors.New(fmt.Sprintf(
+					"parseLexicon: line %d: unexpected weight '%s'",
+					lineNumber,
+					fields[2]))
+			}
+			entry.weight = weight
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LoadLexicon reads the lexicon from r and merges it into the terminal rules
+// of grammar. Each line of lexicon is like
+//     word <symbol> weight
+// which adds rule "<symbol> ::= word ; weight", or overrides the weight if the
+// rule already exists. Weight is optional, 1.0 by default. The weights of
+// rules from the same source symbol are normalized again after merging
+func (p *Parser) LoadLexicon(r io.Reader) error {
+	entries, err := parseLexicon(r)
+	if err != nil {
+		return err
+	}
+
+	original := p.original.clone()
+	terminalRules := map[string]*Rule{}
+	for _, rule := range original.Rules {
+		if rule.IsUnary() && rule.Right[0].IsTerminal() {
+			terminalRules[rule.Id()] = rule
+		}
+	}
+	for _, entry := range entries {
+		rule := &Rule{
+			Left: entry.symbol,
+			Right: []Symbol{entry.word},
+			Weight: entry.weight,
+			Order: len(original.Rules),
+		}
+		if existing, ok := terminalRules[rule.Id()]; ok {
+			existing.Weight = entry.weight
+		} else {
+			terminalRules[rule.Id()] = rule
+			original.Rules = append(original.Rules, rule)
+		}
+	}
+
+	grammar := original.clone()
+	cnfGrammar, err := grammar.convertToCNF()
+	if err != nil {
+		return err
+	}
+	p.original = original
+	p.grammar = grammar
+	p.cnfGrammar = cnfGrammar
+	return nil
+}
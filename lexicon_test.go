@@ -0,0 +1,46 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadLexicon(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("weather in shanghai")
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+
+	lexicon := `
+		; Cities
+		shanghai <city> 2.0
+		seattle <city>`
+	if err = parser.LoadLexicon(strings.NewReader(lexicon)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "(<root> \n  weather \n  in \n  (<city> \n    shanghai))"
+	tree := parser.Parse(query)
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// Weights are normalized per source symbol: 2.0 / (1.0 + 1.0 + 2.0)
+	for _, rule := range parser.cnfGrammar.TerminalRules["shanghai"] {
+		if rule.Probability != 0.5 {
+			t.Fatalf("probability 0.5 expected, but got %f", rule.Probability)
+		}
+	}
+
+	// Failed case
+	err = parser.LoadLexicon(strings.NewReader("shanghai city"))
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
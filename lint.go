@@ -0,0 +1,338 @@
+package pcfg
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// LintSeverity is the severity of a LintIssue
+type LintSeverity int
+
+const (
+	// LintWarning means the grammar works but probably not as expected
+	LintWarning LintSeverity = iota
+
+	// LintError means the grammar is broken
+	LintError
+)
+
+// String converts severity to string
+func (s LintSeverity) String() string {
+	if s == LintError {
+		return "error"
+	}
+	return "warning"
+}
+
+// LintCategory is the category of check that found a LintIssue
+type LintCategory string
+
+const (
+	LintUnreachable LintCategory = "unreachable"
+	LintUndefined LintCategory = "undefined"
+	LintUnexportedIntent LintCategory = "unexported-intent"
+	LintWeight LintCategory = "weight"
+	LintReservedPrefix LintCategory = "reserved-prefix"
+	LintEmptyRight LintCategory = "empty-right"
+	LintDuplicate LintCategory = "duplicate"
+)
+
+// LintIssue is a problem found by Grammar.Lint
+type LintIssue struct {
+	Severity LintSeverity
+	Category LintCategory
+
+	// Symbol that the issue is about
+	Symbol Symbol
+
+	// Line number of the rule that the issue is about, 0 if unknown
+	Line int
+
+	Message string
+}
+
+// String converts issue to string, like
+//     3: error: undefined: <city> is referenced but not defined
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%d: %s: %s: %s", i.Line, i.Severity, i.Category, i.Message)
+}
+
+// The tolerance when checking the sum of weights
+const lintWeightTolerance = 1e-6
+
+// Lint runs all checks on grammar and returns the issues found, ordered by
+// line number. It should be called before ConvertToCNF, which rewrites rules
+func (g *Grammar) Lint() []LintIssue {
+	issues := []LintIssue{}
+	issues = append(issues, g.lintEmptyRight()...)
+	issues = append(issues, g.lintReservedPrefix()...)
+	issues = append(issues, g.lintUndefined()...)
+	issues = append(issues, g.lintUnreachable()...)
+	issues = append(issues, g.lintUnexportedIntent()...)
+	issues = append(issues, g.lintWeight()...)
+	issues = append(issues, g.lintDuplicate()...)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Line < issues[j].Line
+	})
+	return issues
+}
+
+// lintDefinitions returns the first rule defining each non-terminal symbol
+func (g *Grammar) lintDefinitions() map[Symbol]*Rule {
+	definitions := map[Symbol]*Rule{}
+	for _, rule := range g.Rules {
+		if _, ok := definitions[rule.Left]; !ok {
+			definitions[rule.Left] = rule
+		}
+	}
+	return definitions
+}
+
+// lintEmptyRight finds rules with nothing in the right side, like "<a> ::= "
+func (g *Grammar) lintEmptyRight() []LintIssue {
+	issues := []LintIssue{}
+	for _, rule := range g.Rules {
+		if len(rule.Right) == 0 {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Category: LintEmptyRight,
+				Symbol: rule.Left,
+				Line: rule.Line,
+				Message: fmt.Sprintf(
+					"%s has an empty alternative, use %s instead",
+					rule.Left,
+					EpsilonSymbol),
+			})
+		}
+	}
+	return issues
+}
+
+// lintReservedPrefix finds symbols that collide with internal symbols
+func (g *Grammar) lintReservedPrefix() []LintIssue {
+	issues := []LintIssue{}
+	reported := map[Symbol]bool{}
+	for _, rule := range g.Rules {
+		symbols := append([]Symbol{rule.Left}, rule.Right...)
+		for _, symbol := range symbols {
+			if reported[symbol] || !strings.HasPrefix(string(symbol), "<__") {
+				continue
+			}
+			reported[symbol] = true
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Category: LintReservedPrefix,
+				Symbol: symbol,
+				Line: rule.Line,
+				Message: fmt.Sprintf(
+					"%s uses the prefix reserved for internal symbols",
+					symbol),
+			})
+		}
+	}
+	return issues
+}
+
+// lintUndefined finds non-terminal symbols that referenced or exported but
+// not defined
+func (g *Grammar) lintUndefined() []LintIssue {
+	issues := []LintIssue{}
+	definitions := g.lintDefinitions()
+	if _, ok := definitions[RootSymbol]; !ok {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Category: LintUndefined,
+			Symbol: RootSymbol,
+			Message: fmt.Sprintf("%s is not defined", RootSymbol),
+		})
+	}
+
+	reported := map[Symbol]bool{}
+	for _, rule := range g.Rules {
+		for _, symbol := range rule.Right {
+			if symbol.IsTerminal() || reported[symbol] {
+				continue
+			}
+			if _, ok := definitions[symbol]; !ok {
+				reported[symbol] = true
+				issues = append(issues, LintIssue{
+					Severity: LintError,
+					Category: LintUndefined,
+					Symbol: symbol,
+					Line: rule.Line,
+					Message: fmt.Sprintf(
+						"%s is referenced but not defined",
+						symbol),
+				})
+			}
+		}
+	}
+
+	exports := []string{}
+	for export := range g.Exports {
+		if _, ok := definitions[export]; !ok {
+			exports = append(exports, string(export))
+		}
+	}
+	sort.Strings(exports)
+	for _, export := range exports {
+		issues = append(issues, LintIssue{
+			Severity: LintError,
+			Category: LintUndefined,
+			Symbol: Symbol(export),
+			Message: fmt.Sprintf("%s is exported but not defined", export),
+		})
+	}
+	return issues
+}
+
+// lintUnreachable finds non-terminal symbols that can't be reached from <root>
+func (g *Grammar) lintUnreachable() []LintIssue {
+	occurs := g.occursLeft()
+	reachable := map[Symbol]bool{RootSymbol: true}
+	todo := []Symbol{RootSymbol}
+	for len(todo) != 0 {
+		var symbol Symbol
+		symbol, todo = todo[0], todo[1: ]
+		for _, rule := range occurs[symbol] {
+			for _, s := range rule.Right {
+				if !s.IsTerminal() && !reachable[s] {
+					reachable[s] = true
+					todo = append(todo, s)
+				}
+			}
+		}
+	}
+
+	issues := []LintIssue{}
+	for _, rule := range g.Rules {
+		if reachable[rule.Left] {
+			continue
+		}
+		// Report each symbol once, on its first rule
+		reachable[rule.Left] = true
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Category: LintUnreachable,
+			Symbol: rule.Left,
+			Line: rule.Line,
+			Message: fmt.Sprintf(
+				"%s is unreachable from %s",
+				rule.Left,
+				RootSymbol),
+		})
+	}
+	return issues
+}
+
+// lintUnexportedIntent finds intents, the non-terminal symbols that <root>
+// directly derives as a whole, which are not exported. The parsing tree could
+// not tell which of them was matched
+func (g *Grammar) lintUnexportedIntent() []LintIssue {
+	issues := []LintIssue{}
+	reported := map[Symbol]bool{}
+	for _, rule := range g.Rules {
+		if rule.Left != RootSymbol || !rule.IsUnary() {
+			continue
+		}
+		symbol := rule.Right[0]
+		if symbol.IsTerminal() || g.Exports[symbol] || reported[symbol] {
+			continue
+		}
+		reported[symbol] = true
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Category: LintUnexportedIntent,
+			Symbol: symbol,
+			Line: rule.Line,
+			Message: fmt.Sprintf(
+				"intent %s is derived by %s but not exported",
+				symbol,
+				RootSymbol),
+		})
+	}
+	return issues
+}
+
+// lintWeight finds invalid weights and the symbols whose weights don't sum up
+// to 1.0. Symbols using the default weight only are not reported, since they
+// will be normalized to a uniform distribution
+func (g *Grammar) lintWeight() []LintIssue {
+	issues := []LintIssue{}
+	sums := map[Symbol]float64{}
+	weighted := map[Symbol]bool{}
+	firstRules := []*Rule{}
+	for _, rule := range g.Rules {
+		if rule.Weight <= 0 || math.IsInf(rule.Weight, 0) || math.IsNaN(rule.Weight) {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Category: LintWeight,
+				Symbol: rule.Left,
+				Line: rule.Line,
+				Message: fmt.Sprintf(
+					"%s has an invalid weight %g",
+					rule.Left,
+					rule.Weight),
+			})
+		}
+		if _, ok := sums[rule.Left]; !ok {
+			firstRules = append(firstRules, rule)
+		}
+		sums[rule.Left] += rule.Weight
+		if rule.Weight != 1.0 {
+			weighted[rule.Left] = true
+		}
+	}
+
+	for _, rule := range firstRules {
+		sum := sums[rule.Left]
+		if !weighted[rule.Left] || math.Abs(sum - 1.0) < lintWeightTolerance {
+			continue
+		}
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Category: LintWeight,
+			Symbol: rule.Left,
+			Line: rule.Line,
+			Message: fmt.Sprintf(
+				"weights of %s sum up to %g instead of 1.0",
+				rule.Left,
+				sum),
+		})
+	}
+	return issues
+}
+
+// lintDuplicate finds the rules written more than once with different weights,
+// which are summed up silently by ConvertToCNF. The issue is on the later
+// rule, and its message points to the earlier one
+func (g *Grammar) lintDuplicate() []LintIssue {
+	issues := []LintIssue{}
+	firstRules := map[string]*Rule{}
+	for _, rule := range g.Rules {
+		first, ok := firstRules[rule.Id()]
+		if !ok {
+			firstRules[rule.Id()] = rule
+			continue
+		}
+		if first.Weight == rule.Weight {
+			continue
+		}
+		issues = append(issues, LintIssue{
+			Severity: LintWarning,
+			Category: LintDuplicate,
+			Symbol: rule.Left,
+			Line: rule.Line,
+			Message: fmt.Sprintf(
+				"'%s' has weight %g here but %g at line %d, they will be summed up",
+				rule.Id(),
+				rule.Weight,
+				first.Weight,
+				first.Line),
+		})
+	}
+	return issues
+}
@@ -0,0 +1,98 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+// lintGrammar parses grammarText and returns the issues found by Lint
+func lintGrammar(t *testing.T, grammarText string) []LintIssue {
+	grammar, err := ParseGrammar(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grammar.Lint()
+}
+
+// expectIssue checks there is an issue of category on symbol at line
+func expectIssue(t *testing.T, issues []LintIssue, category LintCategory, symbol Symbol, line int) {
+	for _, issue := range issues {
+		if issue.Category == category && issue.Symbol == symbol && issue.Line == line {
+			return
+		}
+	}
+	t.Fatalf("issue (%s, %s, %d) expected in %v", category, symbol, line, issues)
+}
+
+func TestLint(t *testing.T) {
+	// TestCase-1: clean grammar
+	issues := lintGrammar(t, `
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if len(issues) != 0 {
+		t.Fatalf("no issue expected, but got %v", issues)
+	}
+
+	// TestCase-2: unreachable symbol
+	issues = lintGrammar(t, `<root> ::= weather
+		<city> ::= seattle`)
+	expectIssue(t, issues, LintUnreachable, "<city>", 2)
+
+	// TestCase-3: undefined reference, export and <root>
+	issues = lintGrammar(t, `<weather> ::= weather in <city>
+		;!exports: <time>`)
+	expectIssue(t, issues, LintUndefined, "<city>", 1)
+	expectIssue(t, issues, LintUndefined, "<time>", 0)
+	expectIssue(t, issues, LintUndefined, RootSymbol, 0)
+
+	// TestCase-4: unexported intent
+	issues = lintGrammar(t, `<root> ::= <weather> | <music>
+		<weather> ::= weather
+		<music> ::= music
+		;!exports: <music>`)
+	expectIssue(t, issues, LintUnexportedIntent, "<weather>", 1)
+	if len(issues) != 1 {
+		t.Fatalf("len(issues) == 1 expected, but got %v", issues)
+	}
+
+	// TestCase-5: weights
+	issues = lintGrammar(t, `<root> ::= <a> | <b>
+		<a> ::= x ; 0.3 | y ; 0.3
+		<b> ::= x ; 0`)
+	expectIssue(t, issues, LintWeight, "<a>", 2)
+	expectIssue(t, issues, LintWeight, "<b>", 3)
+	hasError := false
+	for _, issue := range issues {
+		hasError = hasError || issue.Symbol == "<b>" && issue.Severity == LintError
+	}
+	if !hasError {
+		t.Fatal("severity of invalid weight should be LintError")
+	}
+
+	// TestCase-6: reserved prefix
+	issues = lintGrammar(t, `<root> ::= <__x>
+		<__x> ::= x`)
+	expectIssue(t, issues, LintReservedPrefix, "<__x>", 1)
+
+	// TestCase-7: empty right-hand side
+	issues = lintGrammar(t, `<root> ::= x | `)
+	expectIssue(t, issues, LintEmptyRight, RootSymbol, 1)
+
+	// TestCase-8: duplicated rules with different weights
+	issues = lintGrammar(t, `<root> ::= <city>
+		<city> ::= seattle ; 0.4 | beijing ; 0.6
+		<city> ::= seattle ; 0.7`)
+	expectIssue(t, issues, LintDuplicate, "<city>", 3)
+	for _, issue := range issues {
+		if issue.Category == LintDuplicate && !strings.Contains(issue.Message, "line 2") {
+			t.Fatalf("line 2 expected in '%s'", issue.Message)
+		}
+	}
+	issues = lintGrammar(t, `<root> ::= x | x`)
+	for _, issue := range issues {
+		if issue.Category == LintDuplicate {
+			t.Fatalf("no duplicate issue expected for the same weight, but got %v", issue)
+		}
+	}
+}
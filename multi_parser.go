@@ -0,0 +1,102 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// MultiParser parses a query against several grammars in one pass. The
+// grammars share the symbol ids from the same vocabulary (see
+// Grammar.UseVocabulary), the terminal rules of all grammars are indexed
+// together, and the nodes of all CYK tables are allocated from one pool
+type MultiParser struct {
+	parsers []*Parser
+
+	// Map from terminal string to the terminal rules of it in each grammar
+	terminalRules map[string][][]*CNFTerminalRule
+}
+
+// NewMultiParser creates a new instance of MultiParser with parsers. All the
+// grammars of parsers should be built with the same vocabulary and the same
+// NormalizeUnicode setting. The terminal index is built once here, so
+// LoadLexicon on parsers after that is not seen by MultiParser
+func NewMultiParser(parsers ...*Parser) (*MultiParser, error) {
+	if len(parsers) == 0 {
+		return nil, errors.New("NewMultiParser: no parser")
+	}
+	first := parsers[0].cnfGrammar
+	for i, parser := range parsers {
+		if parser.cnfGrammar.vocabulary == nil ||
+			parser.cnfGrammar.vocabulary != first.vocabulary {
+			return nil, errors.New(fmt.Sprintf(
+				"NewMultiParser: grammar %d not built with the shared vocabulary",
+				i))
+		}
+		if parser.cnfGrammar.normalizeUnicode != first.normalizeUnicode {
+			return nil, errors.New(fmt.Sprintf(
+				"NewMultiParser: NormalizeUnicode of grammar %d differs",
+				i))
+		}
+		if parser.cnfGrammar.caseInsensitive != first.caseInsensitive {
+			return nil, errors.New(fmt.Sprintf(
+				"NewMultiParser: SetCaseInsensitive of grammar %d differs",
+				i))
+		}
+	}
+
+	terminalRules := map[string][][]*CNFTerminalRule{}
+	for i, parser := range parsers {
+		for terminal, rules := range parser.cnfGrammar.TerminalRules {
+			if _, ok := terminalRules[terminal]; !ok {
+				terminalRules[terminal] = make([][]*CNFTerminalRule, len(parsers))
+			}
+			terminalRules[terminal][i] = rules
+		}
+	}
+	return &MultiParser{
+		parsers: parsers,
+		terminalRules: terminalRules,
+	}, nil
+}
+
+// Parse parses query against the grammar of each parser with the options of
+// it. Returns the best parsing tree of each grammar in the order of parsers,
+// nil for the grammars not matched
+func (m *MultiParser) Parse(query []string) []*Tree {
+	trees := make([]*Tree, len(m.parsers))
+	if len(query) == 0 {
+		return trees
+	}
+
+	// Look up the terminal rules of each distinct token once for all grammars
+	grammarRules := make([]map[string][]*CNFTerminalRule, len(m.parsers))
+	for i := range grammarRules {
+		grammarRules[i] = map[string][]*CNFTerminalRule{}
+	}
+	for _, tok := range query {
+		tok = m.parsers[0].cnfGrammar.normalizeToken(tok)
+		for i, rules := range m.terminalRules[tok] {
+			if rules != nil {
+				grammarRules[i][tok] = rules
+			}
+		}
+	}
+
+	pool := newNodePool()
+	for i, parser := range m.parsers {
+		grammar := parser.cnfGrammar
+		startId, ok := grammar.SymbolIds[string(RootSymbol)]
+		if !ok {
+			continue
+		}
+		table := buildTableWith(grammar, query, &parser.options, pool, grammarRules[i], nil)
+		if table == nil {
+			continue
+		}
+		candidate := bestCandidate(grammar, table, startId, RootSymbol, query, &parser.options)
+		if candidate != nil {
+			trees[i] = parser.stripTree(candidate.Tree)
+		}
+	}
+	return trees
+}
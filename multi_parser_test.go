@@ -0,0 +1,115 @@
+package pcfg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var multiParserGrammars = []string{`
+	<city> ::= seattle | beijing
+	<root> ::= weather in <city>
+	;!exports: <city>`, `
+	<song> ::= hello | yesterday
+	<city> ::= seattle | beijing
+	<root> ::= play <song> in <city>
+	;!exports: <song> <city>`, `
+	<city> ::= seattle | beijing
+	<root> ::= <city>
+	;!exports: <city>`,
+}
+
+func newMultiParserParsers(t testing.TB, grammarTexts []string) []*Parser {
+	vocabulary := NewVocabulary()
+	parsers := []*Parser{}
+	for _, grammarText := range grammarTexts {
+		grammar, err := ParseGrammar(grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grammar.UseVocabulary(vocabulary)
+		parser, err := NewParserFromGrammar(grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsers = append(parsers, parser)
+	}
+	return parsers
+}
+
+func TestMultiParser(t *testing.T) {
+	parsers := newMultiParserParsers(t, multiParserGrammars)
+	parsers[1].StripRoot(true)
+	multiParser, err := NewMultiParser(parsers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: same trees as parsing separately
+	for _, query := range []string{
+		"weather in seattle",
+		"play hello in beijing",
+		"beijing",
+		"play seattle",
+	} {
+		tokens := strings.Fields(query)
+		trees := multiParser.Parse(tokens)
+		for i, parser := range parsers {
+			expected := parser.Parse(tokens)
+			if expected == nil && trees[i] == nil {
+				continue
+			}
+			if expected == nil || trees[i] == nil || !expected.Equal(trees[i]) {
+				t.Fatalf("%s: '%v' != '%v'", query, trees[i], expected)
+			}
+		}
+	}
+
+	// TestCase-2: grammar without shared vocabulary
+	other, err := NewParser(multiParserGrammars[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMultiParser(parsers[0], other); err == nil {
+		t.Fatal("error expected for grammar without shared vocabulary")
+	}
+}
+
+// benchmarkGrammars returns n grammars sharing a lexicon of cities
+func benchmarkGrammars(n int) []string {
+	cities := []string{}
+	for i := 0; i < 1000; i++ {
+		cities = append(cities, fmt.Sprintf("city%d", i))
+	}
+	grammarTexts := []string{}
+	for i := 0; i < n; i++ {
+		grammarTexts = append(grammarTexts, fmt.Sprintf(`
+			<city> ::= %s
+			<root> ::= intent%d in <city> | <city>
+			;!exports: <city>`, strings.Join(cities, " | "), i))
+	}
+	return grammarTexts
+}
+
+func BenchmarkMultiParser(b *testing.B) {
+	multiParser, err := NewMultiParser(newMultiParserParsers(b, benchmarkGrammars(8))...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	query := strings.Fields("intent3 in city42")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		multiParser.Parse(query)
+	}
+}
+
+func BenchmarkSeparateParsers(b *testing.B) {
+	parsers := newMultiParserParsers(b, benchmarkGrammars(8))
+	query := strings.Fields("intent3 in city42")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, parser := range parsers {
+			parser.Parse(query)
+		}
+	}
+}
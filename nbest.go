@@ -0,0 +1,173 @@
+package pcfg
+
+import (
+	"container/heap"
+)
+
+// _RootHeap is a max-heap of root nodes by log-probability. Ties are broken by
+// the orders of rules (see compareOrder), so that the order is stable
+type _RootHeap []_RootNode
+
+func (h _RootHeap) Len() int { return len(h) }
+func (h _RootHeap) Less(i, j int) bool {
+	if h[i].node.logp != h[j].node.logp {
+		return h[i].node.logp > h[j].node.logp
+	}
+	if c := compareOrder(h[i].node, h[j].node); c != 0 {
+		return c < 0
+	}
+	return h[i].pathIndex < h[j].pathIndex
+}
+func (h _RootHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *_RootHeap) Push(x interface{}) { *h = append(*h, x.(_RootNode)) }
+func (h *_RootHeap) Pop() interface{} {
+	old := *h
+	root := old[len(old) - 1]
+	*h = old[: len(old) - 1]
+	return root
+}
+
+// enumerationOptions returns the options of parser for enumerating the
+// derivations in CYK table. The table is not pruned by CompactForest, which
+// keeps only the best node of each symbol, and the statistics are not stored
+func (p *Parser) enumerationOptions() _ParseOptions {
+	options := p.options
+	options.compactForest = false
+	options.stats = nil
+	return options
+}
+
+// CYKNBest parses query like CYK, and returns at most k best derivations from
+// <root> ordered by log-probability, k <= 0 for all of them. Returns nil if
+// query doesn't match grammar. The derivations are extracted lazily from the
+// table, and it stops once k trees are reconstructed. So that a small k skips
+// most of the work on ambiguous queries, but the trees after the k-th are
+// never seen. Different derivations may have the same tree if they differ in
+// the symbols not exported
+func CYKNBest(grammar *CNFGrammar, query []string, k int) []*Candidate {
+	return cykNBest(grammar, query, k, &_ParseOptions{})
+}
+
+// CYKNBest returns at most k best derivations of query like the function
+// CYKNBest, but the table is built with the options of parser, so that MaxNodes
+// applies. Returns nil if the node limit is exceeded
+func (p *Parser) CYKNBest(query []string, k int) []*Candidate {
+	options := p.enumerationOptions()
+	return cykNBest(p.cnfGrammar, query, k, &options)
+}
+
+// cykNBest returns at most k best derivations of query with the table built
+// with options, see CYKNBest
+func cykNBest(grammar *CNFGrammar, query []string, k int, options *_ParseOptions) []*Candidate {
+	candidates := []*Candidate{}
+	forEachCandidate(grammar, query, options, func(candidate *Candidate) bool {
+		candidates = append(candidates, candidate)
+		return k <= 0 || len(candidates) < k
+	})
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates
+}
+
+// forEachCandidate calls visit on the derivations of query from <root> in
+// descending order of log-probability, until visit returns false. The table is
+// built with options, see enumerationOptions
+func forEachCandidate(
+	grammar *CNFGrammar,
+	query []string,
+	options *_ParseOptions,
+	visit func(*Candidate) bool) {
+	startId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || len(query) == 0 {
+		return
+	}
+	table := buildTable(grammar, query, options)
+	if table == nil {
+		// Deadline or node limit exceeded
+		return
+	}
+	roots := _RootHeap(findRoots(table, startId))
+	heap.Init(&roots)
+	for roots.Len() > 0 {
+		root := heap.Pop(&roots).(_RootNode)
+		candidate := &Candidate{
+			Tree: constructStartTree(grammar, root, RootSymbol, options.withRules, query),
+			LogProb: float64(root.node.logp),
+			Edits: root.node.edits,
+		}
+		if !visit(candidate) {
+			return
+		}
+	}
+}
+
+// ParseNBest parses query and returns at most k distinct parsing trees in
+// descending order of probability, k <= 0 for all of them. The derivations
+// differing only in the symbols not exported have the same tree, and only the
+// most probable of them is kept. Returns nil if query doesn't match the grammar,
+// or the table exceeds MaxNodes like Parse
+func (p *Parser) ParseNBest(query []string, k int) []*Tree {
+	trees := []*Tree{}
+	seen := map[string]bool{}
+	options := p.enumerationOptions()
+	forEachCandidate(p.cnfGrammar, query, &options, func(candidate *Candidate) bool {
+		tree := p.stripTree(candidate.Tree)
+		if text := tree.String(); !seen[text] {
+			seen[text] = true
+			trees = append(trees, tree)
+		}
+		return k <= 0 || len(trees) < k
+	})
+	if len(trees) == 0 {
+		return nil
+	}
+	return trees
+}
+
+// AllParses returns at most max distinct parsing trees of query derived from
+// <root> in descending order of probability, max <= 0 for no limit. The bool
+// is true if there are more distinct trees than max. Unlike CYKNBest, the
+// derivations of the same tree are merged, and the whole forest is enumerated
+// until the cap is exceeded, so it's only for small inputs. Returns nil if
+// query doesn't match grammar
+func AllParses(grammar *CNFGrammar, query []string, max int) ([]*Tree, bool) {
+	return allParses(grammar, query, max, &_ParseOptions{})
+}
+
+// AllParses returns at most max distinct parsing trees of query like the
+// function AllParses, but the table is built with the options of parser, so
+// that MaxNodes applies. Returns nil if the node limit is exceeded
+func (p *Parser) AllParses(query []string, max int) ([]*Tree, bool) {
+	options := p.enumerationOptions()
+	trees, more := allParses(p.cnfGrammar, query, max, &options)
+	for i, tree := range trees {
+		trees[i] = p.stripTree(tree)
+	}
+	return trees, more
+}
+
+// allParses returns at most max distinct parsing trees of query with the table
+// built with options, see AllParses
+func allParses(grammar *CNFGrammar, query []string, max int, options *_ParseOptions) ([]*Tree, bool) {
+	trees := []*Tree{}
+	seen := map[string]bool{}
+	more := false
+	forEachCandidate(grammar, query, options, func(candidate *Candidate) bool {
+		text := candidate.Tree.String()
+		if seen[text] {
+			return true
+		}
+		if max > 0 && len(trees) >= max {
+			more = true
+			return false
+		}
+		seen[text] = true
+		trees = append(trees, candidate.Tree)
+		return true
+	})
+	if len(trees) == 0 {
+		return nil, false
+	}
+	return trees, more
+}
@@ -0,0 +1,164 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+const nbestGrammar = `
+<e> ::= <e> + <e> ; 0.4 | <e> * <e> ; 0.2 | x ; 0.4
+<root> ::= <e>
+;!exports: <e>`
+
+func TestCYKNBest(t *testing.T) {
+	grammar, err := ParseGrammar(nbestGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+	query := strings.Fields("x + x * x + x")
+
+	// TestCase-1: all of the 5 derivations in order
+	all := CYKNBest(cnfGrammar, query, 0)
+	if len(all) != 5 {
+		t.Fatalf("5 derivations expected, but got %d", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].LogProb > all[i - 1].LogProb {
+			t.Fatalf("derivations not ordered: %f > %f", all[i].LogProb, all[i - 1].LogProb)
+		}
+	}
+	if !all[0].Tree.Equal(CYK(cnfGrammar, query)) {
+		t.Fatalf("'%s' != '%s'", all[0].Tree.String(), CYK(cnfGrammar, query).String())
+	}
+
+	// TestCase-2: the first k
+	best := CYKNBest(cnfGrammar, query, 2)
+	if len(best) != 2 || best[1].LogProb != all[1].LogProb {
+		t.Fatalf("the best 2 derivations expected, but got %v", best)
+	}
+
+	// TestCase-3: not matched
+	if candidates := CYKNBest(cnfGrammar, strings.Fields("x +"), 2); candidates != nil {
+		t.Fatalf("nil expected, but got %v", candidates)
+	}
+}
+
+func TestParseNBest(t *testing.T) {
+	parser, err := NewParser(nbestGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("x + x * x")
+
+	// TestCase-1: the 2 trees in descending probability
+	trees := parser.ParseNBest(query, 0)
+	if len(trees) != 2 {
+		t.Fatalf("2 trees expected, but got %d", len(trees))
+	}
+	if trees[0].Equal(trees[1]) {
+		t.Fatalf("distinct trees expected, but got '%s' twice", trees[0].String())
+	}
+	if !trees[0].Equal(parser.Parse(query)) {
+		t.Fatalf("'%s' != '%s'", trees[0].String(), parser.Parse(query).String())
+	}
+
+	// TestCase-2: the first k, stable across runs
+	for i := 0; i < 10; i++ {
+		best := parser.ParseNBest(strings.Fields("x + x * x + x"), 3)
+		if len(best) != 3 {
+			t.Fatalf("3 trees expected, but got %d", len(best))
+		}
+		all := parser.ParseNBest(strings.Fields("x + x * x + x"), 0)
+		for j, tree := range best {
+			if !tree.Equal(all[j]) {
+				t.Fatalf("'%s' != '%s'", tree.String(), all[j].String())
+			}
+		}
+	}
+
+	// TestCase-3: derivations of the same tree
+	parser, err = NewParser(`
+		<a> ::= x
+		<b> ::= x
+		<root> ::= <a> ; 0.7 | <b> ; 0.3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trees = parser.ParseNBest(strings.Fields("x"), 5)
+	if len(trees) != 1 {
+		t.Fatalf("1 tree expected, but got %d", len(trees))
+	}
+
+	// TestCase-4: not matched
+	if trees := parser.ParseNBest(strings.Fields("y"), 2); trees != nil {
+		t.Fatalf("nil expected, but got %v", trees)
+	}
+}
+
+func TestAllParses(t *testing.T) {
+	grammar, err := ParseGrammar(nbestGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+
+	// TestCase-1: the exact set of trees
+	trees, more := AllParses(cnfGrammar, strings.Fields("x + x + x"), 0)
+	expected := map[string]bool{
+		"(<root> \n  (<e> \n    (<e> \n      (<e> \n        x) \n      + \n      (<e> \n        x)) \n    + \n    (<e> \n      x)))": true,
+		"(<root> \n  (<e> \n    (<e> \n      x) \n    + \n    (<e> \n      (<e> \n        x) \n      + \n      (<e> \n        x))))": true,
+	}
+	if len(trees) != len(expected) || more {
+		t.Fatalf("%d trees expected, but got (%d, %v)", len(expected), len(trees), more)
+	}
+	for _, tree := range trees {
+		if !expected[tree.String()] {
+			t.Fatalf("unexpected tree '%s'", tree.String())
+		}
+	}
+
+	// TestCase-2: 5 trees, capped by max
+	query := strings.Fields("x + x * x + x")
+	trees, more = AllParses(cnfGrammar, query, 3)
+	if len(trees) != 3 || !more {
+		t.Fatalf("(3, true) expected, but got (%d, %v)", len(trees), more)
+	}
+	trees, more = AllParses(cnfGrammar, query, 5)
+	if len(trees) != 5 || more {
+		t.Fatalf("(5, false) expected, but got (%d, %v)", len(trees), more)
+	}
+	seen := map[string]bool{}
+	for _, tree := range trees {
+		if seen[tree.String()] {
+			t.Fatalf("distinct trees expected, but got '%s' twice", tree.String())
+		}
+		seen[tree.String()] = true
+	}
+
+	// TestCase-3: not matched
+	if trees, more := AllParses(cnfGrammar, strings.Fields("x +"), 0); trees != nil || more {
+		t.Fatalf("(nil, false) expected, but got (%v, %v)", trees, more)
+	}
+}
+
+func benchmarkCYKNBest(b *testing.B, k int) {
+	grammar, err := ParseGrammar(nbestGrammar)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cnfGrammar := grammar.ConvertToCNF()
+	query := strings.Fields("x + x * x + x * x + x * x + x")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CYKNBest(cnfGrammar, query, k)
+	}
+}
+
+func BenchmarkCYKNBest1(b *testing.B) {
+	benchmarkCYKNBest(b, 1)
+}
+
+func BenchmarkCYKNBestAll(b *testing.B) {
+	benchmarkCYKNBest(b, 0)
+}
@@ -1,14 +1,41 @@
 package pcfg
 
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
 // Parser is the struct for PCFG parsing
 type Parser struct {
 	grammar *Grammar
 	cnfGrammar *CNFGrammar
+
+	// Copy of grammar before converting to CNF
+	original *Grammar
+
+	// If return the only child of <root> as the tree instead of <root>
+	stripRoot bool
+
+	// Number of the best parsing trees scored by ParseBy, 0 for the default
+	parseByLimit int
+
+	// Splits text into tokens in ParseString, nil for strings.Fields
+	tokenizer func(string) []string
+
+	options _ParseOptions
 }
 
 // If enable debug model when converting grammar or parsing
 var gEnableDebug bool
 
+// Default number of the best parsing trees scored by ParseBy
+const _DefaultParseByLimit = 10
+
 // NewParser creates a new instance of PCFG parser with pcfgGrammar
 func NewParser(pcfgGrammar string) (parser *Parser, err error) {
 	parser = new(Parser)
@@ -16,18 +43,472 @@ func NewParser(pcfgGrammar string) (parser *Parser, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = parser.grammar.checkRoot(); err != nil {
+		return nil, err
+	}
 
-	parser.cnfGrammar = parser.grammar.ConvertToCNF()
+	parser.original = parser.grammar.clone()
+	parser.cnfGrammar, err = parser.grammar.convertToCNF()
+	if err != nil {
+		return nil, err
+	}
 	return
 }
 
+// NewParserFromReader creates a new instance of PCFG parser with the grammar
+// text read from r, see ParseGrammarReader
+func NewParserFromReader(r io.Reader) (*Parser, error) {
+	grammar, err := ParseGrammarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewParserFromGrammar(grammar)
+}
+
+// NewParserFromFile creates a new instance of PCFG parser with the grammar
+// file, see ParseGrammarFile
+func NewParserFromFile(path string) (*Parser, error) {
+	grammar, err := ParseGrammarFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewParserFromGrammar(grammar)
+}
+
+// NewParserFromGrammar creates a new instance of PCFG parser with a parsed
+// grammar. The grammar is converted to CNF in place. Returns an error if there
+// are no rules of <root> or the exported symbols, from which queries are parsed
+func NewParserFromGrammar(grammar *Grammar) (*Parser, error) {
+	if err := grammar.checkRoot(); err != nil {
+		return nil, err
+	}
+	original := grammar.clone()
+	cnfGrammar, err := grammar.convertToCNF()
+	if err != nil {
+		return nil, err
+	}
+	if grammar.maxAmbiguity > 0 {
+		ambiguity := estimateAmbiguity(original, cnfGrammar)
+		if ambiguity > grammar.maxAmbiguity {
+			return nil, errors.New(fmt.Sprintf(
+				"NewParserFromGrammar: ambiguity of grammar %.1f exceeds %.1f",
+				ambiguity,
+				grammar.maxAmbiguity))
+		}
+	}
+	return &Parser{
+		grammar: grammar,
+		cnfGrammar: cnfGrammar,
+		original: original,
+	}, nil
+}
+
 // Enable debug model
 func DebugMode() {
 	gEnableDebug = true
 }
 
+// StripRoot sets whether to strip the <root> node from parsing tree. When
+// enabled and <root> has a single child, Parse returns the child as the tree
+func (p *Parser) StripRoot(strip bool) {
+	p.stripRoot = strip
+}
+
+// TieBreak sets the secondary criterion to choose among the parsing trees
+// whose log-probability within epsilon of the best one. better returns true
+// if a should be chosen over b. Set better to nil to disable it
+func (p *Parser) TieBreak(epsilon float64, better func(a, b *Candidate) bool) {
+	p.options.tieEpsilon = epsilon
+	p.options.tieBreak = better
+}
+
+// FuzzyTerminal sets the maximum edit distance to match the query tokens not
+// in grammar with terminals, 0 to disable it. A fuzzy match subtracts the edit
+// distance from the log-probability of terminal rule, and the leaf records the
+// original token in Node.Original
+func (p *Parser) FuzzyTerminal(maxDistance int) {
+	p.options.fuzzyDistance = maxDistance
+}
+
+// ForbidCombination forbids symbols a and b to occur in the same subtree of
+// parsing tree, or a to occur twice if a == b. Nodes violating it are not
+// built when parsing. At most 32 distinct symbols could be constrained
+func (p *Parser) ForbidCombination(a, b string) error {
+	symbols := map[string]bool{a: true, b: true}
+	for _, pair := range p.options.forbidden {
+		symbols[pair[0]] = true
+		symbols[pair[1]] = true
+	}
+	if len(symbols) > _MaxConstrainedSymbols {
+		return errors.New(fmt.Sprintf(
+			"Parser.ForbidCombination: more than %d symbols constrained",
+			_MaxConstrainedSymbols))
+	}
+	p.options.forbidden = append(p.options.forbidden, [2]string{a, b})
+	return nil
+}
+
+// CompactForest sets whether to parse in the memory-conscious way. When
+// enabled, only the best node of each symbol is kept in each cell of CYK
+// table, and the best tree is detached from the table before constructing the
+// parsing tree, so that the table could be released early. It reduces the
+// memory for long and ambiguous queries, but TieBreak only compares the best
+// tree of each root node
+func (p *Parser) CompactForest(enable bool) {
+	p.options.compactForest = enable
+}
+
+// Provenance sets whether the nodes of parsing tree reference the CNF rules
+// producing them in Node.Rule, for debugging and mapping back to the grammar.
+// Leaves have no rule
+func (p *Parser) Provenance(enable bool) {
+	p.options.withRules = enable
+}
+
+// LongestMatch sets whether ParseUnsegmented prefers the longest matches of
+// terminals, where terminals of different lengths could match at the same
+// position. When enabled, segmentations are compared by the lengths of their
+// pieces from left to right, so that a terminal of more characters beats the
+// one matching its prefix, e.g. "北京大学" beats "北京" followed by "大学",
+// whatever their probabilities are. Probabilities only choose among the trees
+// of the same segmentation. Unlike greedy matching, a shorter match is still
+// taken if the longer one leads to no parsing tree. It doesn't affect Parse,
+// where each terminal matches exactly one token
+func (p *Parser) LongestMatch(enable bool) {
+	p.options.longestMatch = enable
+}
+
+// MaxNodes sets the maximum number of nodes allocated in the CYK table for one
+// parse, 0 for no limit (default). When the limit is exceeded, parsing is
+// abandoned and nil returned as not matched, so that crafted queries against
+// ambiguous grammars can't exhaust the memory. It's deterministic unlike
+// ParseTimeout, since the nodes allocated depend on the query only
+func (p *Parser) MaxNodes(limit int) {
+	p.options.maxNodes = limit
+}
+
+// SetCaseInsensitive sets whether to match terminals and query tokens
+// case-insensitively. When enabled, the terminals of grammar are lowercased
+// when it's converted to CNF again, and so are the query tokens when parsing.
+// Only the terminals are affected, and the leaves of tree keep the tokens in
+// query. Regex terminals are matched with (?i) instead
+func (p *Parser) SetCaseInsensitive(enable bool) error {
+	if p.cnfGrammar.caseInsensitive == enable {
+		return nil
+	}
+	original := p.original.clone()
+	original.caseInsensitive = enable
+	grammar := original.clone()
+	cnfGrammar, err := grammar.convertToCNF()
+	if err != nil {
+		return err
+	}
+	p.original = original
+	p.grammar = grammar
+	p.cnfGrammar = cnfGrammar
+	return nil
+}
+
+// ApplyWeights sets the weights of rules in the grammar of parser, like
+// Grammar.ApplyWeights, and converts it to CNF again. So that the weights
+// retrained could be applied to a live parser without parsing the grammar.
+// The parser is unchanged if any weight is invalid
+func (p *Parser) ApplyWeights(weights map[string]float64) error {
+	original := p.original.clone()
+	if err := original.ApplyWeights(weights); err != nil {
+		return err
+	}
+	grammar := original.clone()
+	cnfGrammar, err := grammar.convertToCNF()
+	if err != nil {
+		return err
+	}
+	p.original = original
+	p.grammar = grammar
+	p.cnfGrammar = cnfGrammar
+	return nil
+}
+
+// InternTokens converts tokens to the ids of terminals for ParseIDs, see
+// CNFGrammar.InternTokens
+func (p *Parser) InternTokens(tokens []string) []int {
+	return p.cnfGrammar.InternTokens(tokens)
+}
+
+// ParseIDs parses the tokens interned by InternTokens like Parse. Converting
+// repeated queries to ids once saves looking up terminals by string in each
+// parse. The leaves of tree are the terminals of ids. The tokens not in
+// terminals (-1 from InternTokens) match the rules of <?unk> only, with the
+// leaves of <?unk>, since their text is unknown here and can't match regex
+// terminals. Parse them by Parse if regex terminals should match. nil is
+// returned for other invalid ids. Since the tokens are terminals already,
+// FuzzyTerminal doesn't apply
+func (p *Parser) ParseIDs(tokenIds []int) *Tree {
+	grammar := p.cnfGrammar
+	query := make([]string, len(tokenIds))
+	hasUnknown := false
+	for i, id := range tokenIds {
+		if id == -1 {
+			query[i] = string(UnknownSymbol)
+			hasUnknown = true
+			continue
+		}
+		if id < 0 || id >= len(grammar.terminals) {
+			return nil
+		}
+		query[i] = grammar.terminals[id]
+	}
+	rootId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || len(query) == 0 {
+		return nil
+	}
+
+	var candidate *Candidate
+	if grammar.dictionary != nil && p.options.isPlain() && !hasUnknown {
+		candidate = grammar.matchDictionary(query)
+	} else if table := buildTableWith(
+		grammar,
+		query,
+		&p.options,
+		newNodePool(),
+		grammar.TerminalRules,
+		tokenIds); table != nil {
+		candidate = bestCandidate(grammar, table, rootId, RootSymbol, query, &p.options)
+	}
+	if candidate == nil {
+		return nil
+	}
+	return p.stripTree(candidate.Tree)
+}
+
+// ParseByLimit sets the number of the most probable parsing trees scored by
+// ParseBy, m <= 0 for the default 10
+func (p *Parser) ParseByLimit(m int) {
+	p.parseByLimit = m
+}
+
+// ParseBy parses query like Parse, but chooses the parsing tree maximizing
+// score instead of the probability. Only the m most probable distinct trees
+// are scored (see ParseByLimit), and the more probable one wins the tie of
+// score. Like ParseNBest, the derivations with the same tree are scored once.
+// A larger m lets score find trees far from the best, but costs more: the
+// trees are extracted one by one from the CYK table built with the options of
+// parser, and score runs on each of them. Returns nil if query doesn't match
+// the grammar
+func (p *Parser) ParseBy(query []string, score func(*Tree) float64) *Tree {
+	m := p.parseByLimit
+	if m <= 0 {
+		m = _DefaultParseByLimit
+	}
+	var best *Tree
+	bestScore := math.Inf(-1)
+	seen := map[string]bool{}
+	options := p.enumerationOptions()
+	forEachCandidate(p.cnfGrammar, query, &options, func(candidate *Candidate) bool {
+		tree := p.stripTree(candidate.Tree)
+		text := tree.String()
+		if seen[text] {
+			return true
+		}
+		seen[text] = true
+		if s := score(tree); best == nil || s > bestScore {
+			best = tree
+			bestScore = s
+		}
+		return len(seen) < m
+	})
+	return best
+}
+
+// SetTokenizer sets the function splitting text into tokens for ParseString,
+// like a word segmenter for Chinese. Set it to nil to split text by Unicode
+// whitespace. See also ParseUnsegmented, which segments text by the grammar
+func (p *Parser) SetTokenizer(tokenizer func(string) []string) {
+	p.tokenizer = tokenizer
+}
+
+// ParseString splits s into tokens by the tokenizer (see SetTokenizer) and
+// parses them like Parse. By default, s is split by runs of Unicode
+// whitespace, and the empty tokens are dropped
+func (p *Parser) ParseString(s string) *Tree {
+	var tokens []string
+	if p.tokenizer == nil {
+		tokens = strings.Fields(s)
+	} else {
+		for _, token := range p.tokenizer(s) {
+			if token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return p.Parse(tokens)
+}
+
 // Parse parses query using the PCFG grammar. If query matches the grammar,
 // returns the parsing tree. Otherwise, return nil
 func (p *Parser) Parse(query []string) *Tree {
-	return CYK(p.cnfGrammar, query)
+	candidate := p.parse(query)
+	if candidate == nil {
+		return nil
+	}
+	return candidate.Tree
+}
+
+// ParseTimeout parses query like Parse, but abandons parsing and returns nil
+// if it takes longer than d. The deadline is checked periodically when filling
+// the CYK table, so that parsing may run slightly longer than d. Note that nil
+// is returned for both timeout and not matched
+func (p *Parser) ParseTimeout(query []string, d time.Duration) *Tree {
+	options := p.options
+	options.deadline = time.Now().Add(d)
+	candidate := p.parseWithOptions(query, &options)
+	if candidate == nil {
+		return nil
+	}
+	return candidate.Tree
+}
+
+// ParseWithThreshold parses query like Parse, but returns nil if the
+// log-probability of the best parsing tree is below minLogProb. It's compared
+// with Tree.LogProb, the natural log accumulated by CYK, and the tree whose
+// log-probability equals minLogProb is kept
+func (p *Parser) ParseWithThreshold(query []string, minLogProb float64) *Tree {
+	candidate := p.parse(query)
+	if candidate == nil || candidate.LogProb < minLogProb {
+		return nil
+	}
+	return candidate.Tree
+}
+
+// ParseExporting parses query like Parse, but only the symbols in only that
+// exported by grammar are nodes in the parsing tree, the other exported
+// symbols are collapsed like the symbols not exported
+func (p *Parser) ParseExporting(query []string, only []Symbol) *Tree {
+	// Parse with a shallow copy of grammar that exports fewer symbols
+	grammar := *p.cnfGrammar
+	grammar.Exports = map[int]bool{}
+	for _, symbol := range only {
+		if symbolId, ok := p.cnfGrammar.SymbolIds[string(symbol)]; ok && p.cnfGrammar.Exports[symbolId] {
+			grammar.Exports[symbolId] = true
+		}
+	}
+	candidate := cyk(&grammar, RootSymbol, query, &p.options)
+	if candidate == nil {
+		return nil
+	}
+	return p.stripTree(candidate.Tree)
+}
+
+// ParseWithDeletion parses query like Parse, but allows deleting the tokens
+// could not be parsed. deletionPenalty (should be negative) is added to the
+// log-probability for each deleted token. The deleted tokens are marked as
+// Deleted in the parsing tree
+func (p *Parser) ParseWithDeletion(query []string, deletionPenalty float64) *Tree {
+	tree, _ := p.ParseApprox(query, EditCosts{
+		Insertion: math.Inf(-1),
+		Deletion: deletionPenalty,
+		Substitution: math.Inf(-1),
+	})
+	return tree
+}
+
+// ParseApprox parses query with error-correcting, which allows inserting,
+// deleting and substituting tokens with the penalties in costs. Returns the
+// best parsing tree with the number of edits, or (nil, 0) if not matched. The
+// edited tokens are marked in the parsing tree, see Node
+func (p *Parser) ParseApprox(query []string, costs EditCosts) (*Tree, int) {
+	options := p.options
+	options.edits = &costs
+	candidate := p.parseWithOptions(query, &options)
+	if candidate == nil {
+		return nil, 0
+	}
+	return candidate.Tree, candidate.Edits
+}
+
+// parse parses query and returns the parsing tree with its log-probability,
+// nil if not matched
+func (p *Parser) parse(query []string) *Candidate {
+	return p.parseWithOptions(query, &p.options)
+}
+
+// parseWithOptions parses query with options, and returns the parsing tree
+// with its log-probability, nil if not matched
+func (p *Parser) parseWithOptions(query []string, options *_ParseOptions) *Candidate {
+	candidate := cyk(p.cnfGrammar, RootSymbol, query, options)
+	if candidate != nil {
+		candidate.Tree = p.stripTree(candidate.Tree)
+	}
+	return candidate
+}
+
+// stripTree strips the <root> node from tree if StripRoot is enabled
+func (p *Parser) stripTree(tree *Tree) *Tree {
+	if p.stripRoot && len(tree.Children) == 1 {
+		return &Tree{Node: tree.Children[0], LogProb: tree.LogProb}
+	}
+	return tree
+}
+
+// ParseStats parses query like Parse, and returns the statistics of parsing
+// as well
+func (p *Parser) ParseStats(query []string) (*Tree, ParseStats) {
+	stats := ParseStats{}
+	options := p.options
+	options.stats = &stats
+	candidate := p.parseWithOptions(query, &options)
+	if candidate == nil {
+		return nil, stats
+	}
+	return candidate.Tree, stats
+}
+
+// ParseIntent parses query with the exported symbol intent as start symbol. If
+// the whole query derives from intent, returns the parsing tree rooted at
+// intent. Otherwise, or intent is not exported, returns nil
+func (p *Parser) ParseIntent(intent Symbol, query []string) *Tree {
+	if !p.grammar.Exports[intent] {
+		return nil
+	}
+	candidate := cyk(p.cnfGrammar, intent, query, &p.options)
+	if candidate == nil {
+		return nil
+	}
+	return candidate.Tree
+}
+
+// ParseMultiStart parses query with several start symbols competing, each
+// weighted by its prior probability in starts. Like ParseIntent, a start
+// symbol should be exported, except <root>. Returns the parsing tree rooted at
+// the start symbol maximizing log(prior) + Tree.LogProb, and the start symbol.
+// Tree.LogProb doesn't include the prior. Ties are broken by the order of start
+// symbols. Returns (nil, "") if query derives from none of them
+func (p *Parser) ParseMultiStart(query []string, starts map[Symbol]float64) (*Tree, Symbol) {
+	symbols := []Symbol{}
+	for start, prior := range starts {
+		if prior > 0 && (start == RootSymbol || p.grammar.Exports[start]) {
+			symbols = append(symbols, start)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i] < symbols[j] })
+
+	var best *Tree
+	bestStart := Symbol("")
+	maxScore := math.Inf(-1)
+	for _, start := range symbols {
+		candidate := cyk(p.cnfGrammar, start, query, &p.options)
+		if candidate == nil {
+			continue
+		}
+		score := math.Log(starts[start]) + candidate.LogProb
+		if best == nil || score > maxScore {
+			best = candidate.Tree
+			bestStart = start
+			maxScore = score
+		}
+	}
+	if bestStart == RootSymbol {
+		best = p.stripTree(best)
+	}
+	return best, bestStart
 }
@@ -0,0 +1,1148 @@
+package pcfg
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const intentGrammar = `
+<city> ::= seattle | beijing
+<song> ::= yesterday | hello
+<weather> ::= weather in <city> | <city> weather
+<music> ::= play <song>
+<root> ::= <weather> | <music>
+;!exports: <weather> <music> <city> <song>`
+
+func TestParseIntent(t *testing.T) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: query matches the intent
+	tree := parser.ParseIntent("<weather>", strings.Fields("weather in seattle"))
+	if tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+	expected := "(<weather> \n  weather \n  in \n  (<city> \n    seattle))"
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+
+	// TestCase-2: query matches another intent
+	tree = parser.ParseIntent("<music>", strings.Fields("weather in seattle"))
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	tree = parser.ParseIntent("<music>", strings.Fields("play hello"))
+	if tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+	expected = "(<music> \n  play \n  (<song> \n    hello))"
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+
+	// TestCase-3: intent is not exported
+	tree = parser.ParseIntent("<root>", strings.Fields("play hello"))
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+}
+
+func TestStripRoot(t *testing.T) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("seattle weather")
+
+	// TestCase-1: unstripped
+	expected := "(<root> \n  (<weather> \n    (<city> \n      seattle) \n    weather))"
+	tree := parser.Parse(query)
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+
+	// TestCase-2: stripped
+	parser.StripRoot(true)
+	expected = "(<weather> \n  (<city> \n    seattle) \n  weather)"
+	tree = parser.Parse(query)
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+
+	// TestCase-3: <root> with more than one child is kept
+	parser, err = NewParser(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.StripRoot(true)
+	expected = "(<root> \n  weather \n  in \n  (<city> \n    beijing))"
+	tree = parser.Parse(strings.Fields("weather in beijing"))
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+}
+
+// countNodes counts the non-leaf nodes in tree
+func countNodes(node *Node) int {
+	if node.Children == nil {
+		return 0
+	}
+	count := 1
+	for _, child := range node.Children {
+		count += countNodes(child)
+	}
+	return count
+}
+
+func TestTieBreak(t *testing.T) {
+	parser, err := NewParser(`
+		<song> ::= hello world
+		<root> ::= play <song> ; 0.45 | play hello world ; 0.55
+		;!exports: <song>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("play hello world")
+
+	// TestCase-1: the best one
+	expected := "(<root> \n  play \n  hello \n  world)"
+	tree := parser.Parse(query)
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+
+	// TestCase-2: prefer the tree covers most exported symbols
+	parser.TieBreak(0.5, func(a, b *Candidate) bool {
+		return countNodes(a.Tree.Node) > countNodes(b.Tree.Node)
+	})
+	expected = "(<root> \n  play \n  (<song> \n    hello \n    world))"
+	tree = parser.Parse(query)
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+
+	// TestCase-3: out of the epsilon band
+	parser.TieBreak(0.1, func(a, b *Candidate) bool {
+		return countNodes(a.Tree.Node) > countNodes(b.Tree.Node)
+	})
+	expected = "(<root> \n  play \n  hello \n  world)"
+	tree = parser.Parse(query)
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+}
+
+func TestParseWithDeletion(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("weather umm in seattle")
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+
+	tree := parser.ParseWithDeletion(query, -5.0)
+	if tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+	expected := "(<root> \n  weather \n  umm \n  in \n  (<city> \n    seattle))"
+	if tree.String() != expected {
+		t.Fatalf("'%s' != '%s'", tree.String(), expected)
+	}
+	for i, child := range tree.Children {
+		if child.Deleted != (i == 1) {
+			t.Fatalf("only 'umm' should be deleted, but got %v", child)
+		}
+	}
+
+	// Deleting at the beginning and the end
+	tree = parser.ParseWithDeletion(strings.Fields("so weather in beijing please"), -5.0)
+	if tree == nil || !tree.Children[0].Deleted || !tree.Children[4].Deleted {
+		t.Fatalf("'so' and 'please' should be deleted, but got '%v'", tree)
+	}
+}
+
+func TestParseApprox(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	costs := EditCosts{Insertion: -5.0, Deletion: -5.0, Substitution: -5.0}
+
+	// TestCase-1: substitution
+	query := strings.Fields("weather in seatle")
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	tree, edits := parser.ParseApprox(query, costs)
+	if tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+	if edits != 1 {
+		t.Fatalf("%d != 1", edits)
+	}
+	city := tree.Children[2].Children[0]
+	if city.Original != "seatle" || city.Symbol == "seatle" {
+		t.Fatalf("'seatle' should be substituted, but got %v", city)
+	}
+
+	// TestCase-2: insertion
+	tree, edits = parser.ParseApprox(strings.Fields("weather seattle"), costs)
+	if tree == nil || edits != 1 {
+		t.Fatalf("tree with 1 edit expected, but got '%v' with %d", tree, edits)
+	}
+	if !tree.Children[1].Inserted || tree.Children[1].Symbol != "in" {
+		t.Fatalf("'in' should be inserted, but got %v", tree.Children[1])
+	}
+
+	// TestCase-3: no edits
+	tree, edits = parser.ParseApprox(strings.Fields("weather in beijing"), costs)
+	if tree == nil || edits != 0 {
+		t.Fatalf("tree with 0 edits expected, but got '%v' with %d", tree, edits)
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	parser, err := NewParser(`
+		<w> ::= a | b
+		<s> ::= <w> | <s> <w>
+		<root> ::= <s>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last ParseStats
+	for _, query := range []string{"a", "a b", "a b a", "a b a b"} {
+		tree, stats := parser.ParseStats(strings.Fields(query))
+		if tree == nil {
+			t.Fatalf("tree != nil expected for '%s'", query)
+		}
+		if stats.Cells <= last.Cells ||
+			stats.Combinations < last.Combinations ||
+			stats.Nodes <= last.Nodes {
+			t.Fatalf("stats of '%s' %v should be larger than %v", query, stats, last)
+		}
+		last = stats
+	}
+	if last.Combinations == 0 || last.Duration <= 0 {
+		t.Fatalf("stats not populated: %v", last)
+	}
+}
+
+func TestCompactForest(t *testing.T) {
+	parser, err := NewParser(`
+		<e> ::= <e> <e> ; 0.3 | <e> + <e> ; 0.3 | x ; 0.4
+		<root> ::= <e>
+		;!exports: <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("x x + x x x + x x + x x x")
+	_, stats := parser.ParseStats(query)
+	candidate := parser.parse(query)
+
+	// Trees may differ in ties, compare the log-probability only
+	parser.CompactForest(true)
+	_, compactStats := parser.ParseStats(query)
+	compactCandidate := parser.parse(query)
+	if math.Abs(compactCandidate.LogProb - candidate.LogProb) > 1e-9 {
+		t.Fatalf("%f != %f", compactCandidate.LogProb, candidate.LogProb)
+	}
+	if compactStats.Nodes * 10 > stats.Nodes {
+		t.Fatalf("nodes %d should be much less than %d", compactStats.Nodes, stats.Nodes)
+	}
+}
+
+func TestFuzzyTerminal(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("wether in seattle")
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+
+	// TestCase-1: "wether" matches "weather" at distance 1
+	parser.FuzzyTerminal(1)
+	candidate := parser.parse(query)
+	if candidate == nil {
+		t.Fatal("tree != nil expected")
+	}
+	leaf := candidate.Tree.Children[0]
+	if leaf.Symbol != "weather" || leaf.Original != "wether" {
+		t.Fatalf("'weather' with original 'wether' expected, but got %v", leaf)
+	}
+	exact := parser.parse(strings.Fields("weather in seattle"))
+	if math.Abs(exact.LogProb - candidate.LogProb - 1.0) > 1e-9 {
+		t.Fatalf("penalty 1.0 expected, but got %f", exact.LogProb - candidate.LogProb)
+	}
+
+	// TestCase-2: too far
+	if tree := parser.Parse(strings.Fields("wethr in seattle")); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	parser.FuzzyTerminal(2)
+	if tree := parser.Parse(strings.Fields("wtr in seattle")); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		distance int
+	}{
+		{"weather", "wether", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+		{"北京", "南京", 1},
+		{"same", "same", 0},
+	} {
+		if distance := editDistance(c.a, c.b); distance != c.distance {
+			t.Fatalf("editDistance('%s', '%s'): %d != %d", c.a, c.b, distance, c.distance)
+		}
+	}
+}
+
+func TestRecursiveRoot(t *testing.T) {
+	for _, c := range []struct {
+		grammar string
+		query string
+		expected string
+	}{
+		// TestCase-1: <root> in binary rules
+		{
+			`<root> ::= <root> and <root> | a | b`,
+			"a and b",
+			"(<root> \n  (<root> \n    a) \n  and \n  (<root> \n    b))",
+		},
+
+		// TestCase-2: <root> through a unit rule
+		{
+			`<x> ::= <root>
+			 <root> ::= <x> and <x> | a | b`,
+			"a and b",
+			"(<root> \n  (<root> \n    a) \n  and \n  (<root> \n    b))",
+		},
+
+		// TestCase-3: <root> in a cycle of unit rules with exported symbol
+		{
+			`<root> ::= <x> | a | <root> and <root>
+			 <x> ::= <root> | b
+			 ;!exports: <x>`,
+			"a and b",
+			"(<root> \n  (<root> \n    a) \n  and \n  (<root> \n    (<x> \n      b)))",
+		},
+		{
+			`<root> ::= <x> | a | <root> and <root>
+			 <x> ::= <root> | b
+			 ;!exports: <x>`,
+			"b",
+			"(<root> \n  (<x> \n    b))",
+		},
+	} {
+		parser, err := NewParser(c.grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree := parser.Parse(strings.Fields(c.query))
+		if tree == nil || tree.String() != c.expected {
+			t.Fatalf("'%v' != '%s'", tree, c.expected)
+		}
+	}
+}
+
+func TestForbidCombination(t *testing.T) {
+	grammar := `
+		<city> ::= seattle | beijing
+		<time> ::= today | tomorrow
+		<when> ::= <time> | <time> <time>
+		<root> ::= weather <when> | weather in <city> <when>
+		;!exports: <city> <time>`
+	parser, err := NewParser(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoTimes := strings.Fields("weather today tomorrow")
+	if tree := parser.Parse(twoTimes); tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+
+	// TestCase-1: two <time>
+	if err := parser.ForbidCombination("<time>", "<time>"); err != nil {
+		t.Fatal(err)
+	}
+	if tree := parser.Parse(twoTimes); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	if tree := parser.Parse(strings.Fields("weather in seattle today")); tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+
+	// TestCase-2: <city> with <time>
+	parser, err = NewParser(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.ForbidCombination("<city>", "<time>"); err != nil {
+		t.Fatal(err)
+	}
+	if tree := parser.Parse(strings.Fields("weather in seattle today")); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	if tree := parser.Parse(twoTimes); tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	parser, err := NewParser(`
+		<e> ::= <e> + <e> | <e> * <e> | x
+		<root> ::= <e>
+		;!exports: <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: enough time
+	query := strings.Fields("x + x * x")
+	tree := parser.ParseTimeout(query, time.Minute)
+	if tree == nil || !tree.Equal(parser.Parse(query)) {
+		t.Fatalf("'%v' != '%v'", tree, parser.Parse(query))
+	}
+
+	// TestCase-2: timeout on a long and ambiguous query
+	query = strings.Fields("x" + strings.Repeat(" + x * x", 30))
+	start := time.Now()
+	if tree := parser.ParseTimeout(query, time.Millisecond); tree != nil {
+		t.Fatalf("nil expected, but got '%v'", tree)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("parsing not abandoned in time: %v", elapsed)
+	}
+}
+
+func TestParseExporting(t *testing.T) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("weather in seattle")
+
+	// TestCase-1: full tree
+	tree := parser.Parse(query)
+	expected := "(<root> \n  (<weather> \n    weather \n    in \n    (<city> \n      seattle)))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: only <city> and a symbol not exported
+	tree = parser.ParseExporting(query, []Symbol{"<city>", "<root>", "<unknown>"})
+	expected = "(<root> \n  weather \n  in \n  (<city> \n    seattle))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: dictionary grammar
+	parser, err = NewParser(dictionaryGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.ParseExporting(strings.Fields("switch on"), nil)
+	expected = "(<root> \n  switch \n  on)"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+func TestTieBreakByOrder(t *testing.T) {
+	grammarTemplate := `
+		<root> ::= %s
+		<a> ::= x y
+		<b> ::= x y
+		<c> ::= <a> | <b>
+		;!exports: <a> <b> <c>`
+	cases := []struct {
+		root string
+		query string
+		expected string
+	}{
+		// TestCase-1: dictionary grammar, <a> comes first
+		{"<a> | <b>", "x y", "(<root> \n  (<a> \n    x \n    y))"},
+
+		// TestCase-2: dictionary grammar, <b> comes first
+		{"<b> | <a>", "x y", "(<root> \n  (<b> \n    x \n    y))"},
+
+		// TestCase-3: CYK, <a> comes first
+		{"<a> z | <b> z", "x y z", "(<root> \n  (<a> \n    x \n    y) \n  z)"},
+
+		// TestCase-4: CYK, <b> comes first
+		{"<b> z | <a> z", "x y z", "(<root> \n  (<b> \n    x \n    y) \n  z)"},
+
+		// TestCase-5: nodes of <c> in the same cell
+		{"<c> z", "x y z", "(<root> \n  (<c> \n    (<a> \n      x \n      y)) \n  z)"},
+	}
+	for _, c := range cases {
+		parser, err := NewParser(strings.Replace(grammarTemplate, "%s", c.root, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree := parser.Parse(strings.Fields(c.query))
+		if tree == nil || tree.String() != c.expected {
+			t.Fatalf("'%v' != '%s'", tree, c.expected)
+		}
+	}
+
+	// TestCase-6: reorder the alternatives of <c>
+	parser, err := NewParser(`
+		<root> ::= <c> z
+		<a> ::= x y
+		<b> ::= x y
+		<c> ::= <b> | <a>
+		;!exports: <a> <b> <c>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse(strings.Fields("x y z"))
+	expected := "(<root> \n  (<c> \n    (<b> \n      x \n      y)) \n  z)"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("weather in seattle")
+
+	// TestCase-1: disabled by default
+	tree := parser.Parse(query)
+	if tree == nil || tree.Rule != nil || tree.Children[0].Rule != nil {
+		t.Fatalf("rules referenced without provenance")
+	}
+
+	// TestCase-2: <weather> ::= weather in <city>, and <city> ::= seattle
+	parser.Provenance(true)
+	tree = parser.Parse(query)
+	weatherId := parser.cnfGrammar.SymbolIds["<weather>"]
+	weather := tree.Children[0]
+	if weather.Rule == nil ||
+		weather.Rule.Source != weatherId && indexOfSymbol(weather.Rule.Path, weatherId) < 0 {
+		t.Fatalf("unexpected rule of <weather>: %v", weather.Rule)
+	}
+	city := weather.Children[2]
+	cityRules := parser.cnfGrammar.TerminalRules["seattle"]
+	if city.Rule == nil || len(cityRules) != 1 || city.Rule != &cityRules[0].CNFRuleBase {
+		t.Fatalf("unexpected rule of <city>: %v", city.Rule)
+	}
+	if city.Children[0].Rule != nil {
+		t.Fatalf("leaf references rule %v", city.Children[0].Rule)
+	}
+}
+
+func TestParseBy(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= new york ; 0.1 | york ; 0.9
+		<place> ::= new <city>
+		<root> ::= <place> ; 0.8 | <city> ; 0.2
+		;!exports: <city> <place>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("new york")
+
+	// TestCase-1: the most probable tree
+	tree := parser.Parse(query)
+	expected := "(<root> \n  (<place> \n    new \n    (<city> \n      york)))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: prefer the trees with fewer nodes
+	size := func(tree *Tree) float64 {
+		return -float64(strings.Count(tree.String(), "("))
+	}
+	tree = parser.ParseBy(query, size)
+	expected = "(<root> \n  (<city> \n    new \n    york))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: the other tree is cut off
+	parser.ParseByLimit(1)
+	tree = parser.ParseBy(query, size)
+	expected = "(<root> \n  (<place> \n    new \n    (<city> \n      york)))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: not matched
+	if tree := parser.ParseBy(strings.Fields("new"), size); tree != nil {
+		t.Fatalf("nil expected, but got '%s'", tree.String())
+	}
+
+	// TestCase-5: the derivations of the same tree count once in the limit
+	parser, err = NewParser(`
+		<c1> ::= york
+		<c2> ::= york
+		<city> ::= <c1> | <c2>
+		<place> ::= york
+		<root> ::= <city> ; 0.9 | <place> ; 0.1
+		;!exports: <city> <place>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.ParseByLimit(2)
+	isPlace := func(tree *Tree) float64 {
+		if strings.Contains(tree.String(), "<place>") {
+			return 1
+		}
+		return 0
+	}
+	tree = parser.ParseBy(strings.Fields("york"), isPlace)
+	expected = "(<root> \n  (<place> \n    york))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-6: options of parser apply
+	parser.MaxNodes(1)
+	if tree := parser.ParseBy(strings.Fields("york"), isPlace); tree != nil {
+		t.Fatalf("nil expected, but got '%s'", tree.String())
+	}
+}
+
+func TestExportedRoot(t *testing.T) {
+	cases := []struct {
+		grammarText string
+		query string
+		expected string
+	}{
+		// TestCase-1: <root> exported
+		{`
+			<city> ::= seattle | beijing
+			<root> ::= weather in <city> | <city>
+			;!exports: <root> <city>`,
+			"seattle",
+			"(<root> \n  (<city> \n    seattle))"},
+
+		// TestCase-2: <root> in the cycle of unit rules
+		{`
+			<a> ::= <root> | x
+			<root> ::= <a> | <a> y
+			;!exports: <root> <a>`,
+			"x",
+			"(<root> \n  (<a> \n    x))"},
+
+		// TestCase-3: inner <root> derived from <root>
+		{`
+			<a> ::= <root> | x
+			<root> ::= <a> | <a> y
+			;!exports: <root> <a>`,
+			"x y",
+			"(<root> \n  (<a> \n    x) \n  y)"},
+	}
+	for _, c := range cases {
+		parser, err := NewParser(c.grammarText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree := parser.Parse(strings.Fields(c.query))
+		if tree == nil || tree.String() != c.expected {
+			t.Fatalf("'%v' != '%s'", tree, c.expected)
+		}
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: same tree as Parse
+	for _, query := range []string{"weather in seattle", "beijing weather", "play hello"} {
+		ids := parser.InternTokens(strings.Fields(query))
+		tree := parser.ParseIDs(ids)
+		expected := parser.Parse(strings.Fields(query))
+		if tree == nil || !tree.Equal(expected) {
+			t.Fatalf("'%v' != '%v'", tree, expected)
+		}
+	}
+
+	// TestCase-2: token not in terminals
+	ids := parser.InternTokens(strings.Fields("weather in shanghai"))
+	if ids[2] != -1 {
+		t.Fatalf("%d != -1", ids[2])
+	}
+	if tree := parser.ParseIDs(ids); tree != nil {
+		t.Fatalf("nil expected, but got '%s'", tree.String())
+	}
+
+	// TestCase-3: tokens not in terminals match <?unk>
+	parser, err = NewParser(`
+		<city> ::= seattle | <?unk> ; 0.1
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = parser.InternTokens(strings.Fields("weather in shanghai"))
+	tree := parser.ParseIDs(ids)
+	expected := "(<root> \n  weather \n  in \n  (<city> \n    <?unk>))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+// repeatedQueries returns the queries of intentGrammar for the benchmarks of
+// parsing repeated inputs
+func repeatedQueries() [][]string {
+	return [][]string{
+		strings.Fields("weather in seattle"),
+		strings.Fields("beijing weather"),
+		strings.Fields("play yesterday"),
+		strings.Fields("weather in beijing"),
+	}
+}
+
+func BenchmarkParseStrings(b *testing.B) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		b.Fatal(err)
+	}
+	queries := repeatedQueries()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.Parse(queries[i % len(queries)])
+	}
+}
+
+func BenchmarkParseIDs(b *testing.B) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ids := [][]int{}
+	for _, query := range repeatedQueries() {
+		ids = append(ids, parser.InternTokens(query))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.ParseIDs(ids[i % len(ids)])
+	}
+}
+
+func TestParseWithThreshold(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle ; 0.8 | beijing ; 0.2
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("weather in beijing")
+	logp := parser.Parse(query).LogProb
+
+	// TestCase-1: above the threshold
+	tree := parser.ParseWithThreshold(query, math.Log(0.1))
+	if tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+
+	// TestCase-2: equals the threshold
+	tree = parser.ParseWithThreshold(query, logp)
+	if tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+
+	// TestCase-3: below the threshold
+	tree = parser.ParseWithThreshold(query, math.Nextafter(logp, 0))
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+	if tree := parser.ParseWithThreshold(strings.Fields("weather in seattle"), math.Log(0.5)); tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+
+	// TestCase-4: not matched
+	if tree := parser.ParseWithThreshold(strings.Fields("weather"), math.Inf(-1)); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+}
+
+func TestParseString(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= beijing | 上海
+		<root> ::= weather in <city> | <city> 天气
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: runs of whitespace are collapsed
+	tree := parser.ParseString("  weather   in \t beijing ")
+	expected := parser.Parse(strings.Fields("weather in beijing"))
+	if tree == nil || !tree.Equal(expected) {
+		t.Fatalf("'%v' != '%v'", tree, expected)
+	}
+
+	// TestCase-2: custom tokenizer
+	parser.SetTokenizer(func(s string) []string {
+		return []string{"", s[: len("上海")], s[len("上海"): ]}
+	})
+	tree = parser.ParseString("上海天气")
+	expectedText := "(<root> \n  (<city> \n    上海) \n  天气)"
+	if tree == nil || tree.String() != expectedText {
+		t.Fatalf("'%v' != '%s'", tree, expectedText)
+	}
+
+	// TestCase-3: empty string
+	parser.SetTokenizer(nil)
+	if tree := parser.ParseString("   "); tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+}
+
+// failingReader returns the text then fails
+type failingReader struct {
+	text string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.text == "" {
+		return 0, errors.New("read failed")
+	}
+	n := copy(p, r.text)
+	r.text = r.text[n: ]
+	return n, nil
+}
+
+func TestNewParserFromReader(t *testing.T) {
+	grammarText := "; weather\r\n" + intentGrammar + "\n<song> ::= hey jude ; 0.5"
+	expected, err := NewParser(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("weather in seattle")
+
+	// TestCase-1: same as NewParser, with line numbers
+	parser, err := NewParserFromReader(strings.NewReader(grammarText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parser.Parse(query).Equal(expected.Parse(query)) {
+		t.Fatalf("'%s' != '%s'", parser.Parse(query).String(), expected.Parse(query).String())
+	}
+	if len(parser.original.Rules) != len(expected.original.Rules) {
+		t.Fatalf("%d != %d", len(parser.original.Rules), len(expected.original.Rules))
+	}
+	for i, rule := range parser.original.Rules {
+		if rule.Id() != expected.original.Rules[i].Id() || rule.Line != expected.original.Rules[i].Line {
+			t.Fatalf("'%s' at %d != '%s' at %d",
+				rule.Id(),
+				rule.Line,
+				expected.original.Rules[i].Id(),
+				expected.original.Rules[i].Line)
+		}
+	}
+
+	// TestCase-2: from file
+	file, err := ioutil.TempFile("", "pcfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(grammarText); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	parser, err = NewParserFromFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parser.Parse(query).Equal(expected.Parse(query)) {
+		t.Fatalf("'%s' != '%s'", parser.Parse(query).String(), expected.Parse(query).String())
+	}
+
+	// TestCase-3: errors
+	if _, err := NewParserFromFile(file.Name() + ".missing"); err == nil {
+		t.Fatal("err != nil expected")
+	}
+	if _, err := NewParserFromReader(&failingReader{text: intentGrammar}); err == nil {
+		t.Fatal("err != nil expected")
+	}
+	if _, err := NewParserFromReader(strings.NewReader("<root> ::= <a")); err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
+
+func TestMissingRoot(t *testing.T) {
+	// TestCase-1: the grammar uses another start symbol
+	_, err := NewParser(`
+		<city> ::= seattle | beijing
+		<start> ::= weather in <city>`)
+	if err == nil || !strings.Contains(err.Error(), string(RootSymbol)) {
+		t.Fatalf("error of missing <root> expected, but got %v", err)
+	}
+
+	// TestCase-2: <root> used but not defined
+	if _, err := NewParser("<weather> ::= weather <root>"); err == nil {
+		t.Fatal("err != nil expected")
+	}
+
+	// TestCase-3: exported start symbols without <root>, for ParseIntent
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<weather> ::= weather in <city>
+		;!exports: <weather>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree := parser.Parse(strings.Fields("weather in seattle")); tree != nil {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+	tree := parser.ParseIntent("<weather>", strings.Fields("weather in seattle"))
+	expected := "(<weather> \n  weather \n  in \n  seattle)"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<number> ::= /[a-c]+/
+		<root> ::= weather in <city> | <number> <city>
+		;!exports: <city> <number>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("Weather In Beijing")
+
+	// TestCase-1: case-sensitive by default
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+
+	// TestCase-2: the leaves keep the tokens in query
+	if err := parser.SetCaseInsensitive(true); err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse(query)
+	expected := "(<root> \n  Weather \n  In \n  (<city> \n    Beijing))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: regex terminals are matched case-insensitively
+	tree = parser.Parse(strings.Fields("ABC Seattle"))
+	expected = "(<root> \n  (<number> \n    ABC) \n  (<city> \n    Seattle))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: disabled again
+	if err := parser.SetCaseInsensitive(false); err != nil {
+		t.Fatal(err)
+	}
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+
+	// TestCase-5: repeated tokens in different cases keep their own text
+	parser, err = NewParser(`
+		<city> ::= x
+		<root> ::= <city> to <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.SetCaseInsensitive(true); err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.Parse(strings.Fields("x to X"))
+	expected = "(<root> \n  (<city> \n    x) \n  to \n  (<city> \n    X))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+func TestParserApplyWeights(t *testing.T) {
+	parser, err := NewParser(`
+		<a> ::= x
+		<b> ::= x
+		<root> ::= <a> ; 0.6 | <b> ; 0.4
+		;!exports: <a> <b>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := []string{"x"}
+
+	// TestCase-1: the weights of grammar
+	tree := parser.Parse(query)
+	expected := "(<root> \n  (<a> \n    x))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: reweighted without parsing the grammar again
+	err = parser.ApplyWeights(map[string]float64{"<root> ::= <b>": 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.Parse(query)
+	expected = "(<root> \n  (<b> \n    x))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: parser unchanged by invalid weights
+	err = parser.ApplyWeights(map[string]float64{
+		"<root> ::= <a>": 1.0,
+		"<root> ::= <c>": 1.0,
+	})
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+	tree = parser.Parse(query)
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
+
+func TestParseMultiStart(t *testing.T) {
+	parser, err := NewParser(`
+		<song> ::= beijing | yesterday
+		<city> ::= beijing | seattle
+		<music> ::= play <song> | <song>
+		<weather> ::= weather in <city> | <city>
+		;!exports: <music> <weather>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := []string{"beijing"}
+
+	// TestCase-1: the prior determines the winner of an ambiguous query
+	tree, start := parser.ParseMultiStart(query, map[Symbol]float64{"<music>": 0.7, "<weather>": 0.3})
+	expected := "(<music> \n  beijing)"
+	if start != "<music>" || tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s' or %s != <music>", tree, expected, start)
+	}
+	tree, start = parser.ParseMultiStart(query, map[Symbol]float64{"<music>": 0.2, "<weather>": 0.8})
+	expected = "(<weather> \n  beijing)"
+	if start != "<weather>" || tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s' or %s != <weather>", tree, expected, start)
+	}
+
+	// TestCase-2: only one start symbol derives the query
+	tree, start = parser.ParseMultiStart(
+		strings.Fields("weather in seattle"),
+		map[Symbol]float64{"<music>": 0.9, "<weather>": 0.1})
+	if start != "<weather>" || tree == nil {
+		t.Fatalf("%s != <weather>", start)
+	}
+
+	// TestCase-3: not matched, or the start symbols are not exported
+	tree, start = parser.ParseMultiStart(
+		strings.Fields("play seattle"),
+		map[Symbol]float64{"<music>": 0.5, "<weather>": 0.5})
+	if tree != nil || start != "" {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+	tree, start = parser.ParseMultiStart(query, map[Symbol]float64{"<city>": 1.0})
+	if tree != nil || start != "" {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+}
+
+func TestMaxNodes(t *testing.T) {
+	parser, err := NewParser(`
+		<x> ::= <x> <x> | a
+		<root> ::= <x>
+		;!exports: <x>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.MaxNodes(100000)
+
+	// TestCase-1: the nodes of every bracketing are kept in table, whose
+	// number is the Catalan number and never fits into memory
+	query := strings.Fields(strings.Repeat("a ", 60))
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+	if tree, _ := parser.ParseStats(query); tree != nil {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+	if explanation := parser.Explain(query); explanation != nil {
+		t.Fatal("explanation == nil expected")
+	}
+
+	// TestCase-2: queries within the limit
+	query = strings.Fields("a a a a")
+	tree, stats := parser.ParseStats(query)
+	if tree == nil || stats.Nodes > 100000 {
+		t.Fatalf("tree != nil expected, with %d nodes", stats.Nodes)
+	}
+	parser.MaxNodes(stats.Nodes - 1)
+	if tree := parser.Parse(query); tree != nil {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+	parser.MaxNodes(stats.Nodes)
+	if tree := parser.Parse(query); tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+
+	// TestCase-3: the limit applies to the other ways of parsing
+	parser.MaxNodes(1)
+	if trees := parser.ParseNBest(query, 0); trees != nil {
+		t.Fatalf("'%v' != '<nil>'", trees)
+	}
+	if candidates := parser.CYKNBest(query, 0); candidates != nil {
+		t.Fatalf("'%v' != '<nil>'", candidates)
+	}
+	if trees, _ := parser.AllParses(query, 0); trees != nil {
+		t.Fatalf("'%v' != '<nil>'", trees)
+	}
+	if _, err := parser.DumpChart(query); err == nil {
+		t.Fatal("err != nil expected")
+	}
+	if _, err := parser.ValidateTree(tree); err == nil {
+		t.Fatal("err != nil expected")
+	}
+	if tree := parser.ParseUnsegmented("aaaa"); tree != nil {
+		t.Fatalf("'%v' != '<nil>'", tree)
+	}
+	parser.MaxNodes(0)
+	if trees := parser.ParseNBest(query, 0); len(trees) != 5 {
+		t.Fatalf("%d != 5", len(trees))
+	}
+	if tree := parser.ParseUnsegmented("aaaa"); tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+}
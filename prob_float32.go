@@ -0,0 +1,8 @@
+//go:build pcfg_float32
+// +build pcfg_float32
+
+package pcfg
+
+// Prob is the floating-point type of the probabilities in CNF grammar and CYK
+// table, float32 with the build tag pcfg_float32. See prob_float64.go
+type Prob = float32
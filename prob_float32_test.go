@@ -0,0 +1,8 @@
+//go:build pcfg_float32
+// +build pcfg_float32
+
+package pcfg
+
+// probTolerance is the tolerance of comparing the probabilities computed in
+// Prob with the expected ones, for the precision of float32
+const probTolerance = 1e-5
@@ -0,0 +1,11 @@
+//go:build !pcfg_float32
+// +build !pcfg_float32
+
+package pcfg
+
+// Prob is the floating-point type of the probabilities in CNF grammar and CYK
+// table. It's float64 by default, and float32 with the build tag pcfg_float32,
+// which saves memory for large grammars and charts. The values exposed, like
+// Candidate.LogProb, are float64 in both cases. Tests compare the probabilities
+// within probTolerance, which depends on Prob
+type Prob = float64
@@ -0,0 +1,139 @@
+package pcfg
+
+import (
+	"math"
+)
+
+// Probability returns the total probability of query under the grammar, which
+// is the sum of the probabilities of all its parsing trees (the inside
+// probability of <root> over query). It's 0 if query doesn't match the
+// grammar or is empty. Tokens match the terminals as in Parse, including the
+// regex terminals and <?unk>. The probability is linear, so that it may
+// underflow to 0 for very long queries
+func (p *Parser) Probability(query []string) float64 {
+	cells := make([]map[int]float64, len(query))
+	for i, tok := range query {
+		cells[i] = map[int]float64{}
+		for _, rule := range tokenRules(p.cnfGrammar, tok, p.options.unknown) {
+			cells[i][rule.Source] += float64(rule.Probability)
+		}
+	}
+	return insideProbability(p.cnfGrammar, cells)
+}
+
+// InsideProbability returns the natural log of the total probability of query,
+// like Probability. The probabilities are summed by log-sum-exp, so that it
+// won't underflow for long queries. It's -Inf if query doesn't match the
+// grammar or is empty
+func (p *Parser) InsideProbability(query []string) float64 {
+	grammar := p.cnfGrammar
+	rootId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || len(query) == 0 {
+		return math.Inf(-1)
+	}
+
+	// inside[length][start] maps symbol to the log-probability of it deriving
+	// span [start, start + length)
+	inside := make([][]map[int]float64, len(query) + 1)
+	inside[1] = make([]map[int]float64, len(query))
+	for i, tok := range query {
+		cell := map[int]float64{}
+		for _, rule := range tokenRules(grammar, tok, p.options.unknown) {
+			addLogProb(cell, rule.Source, float64(rule.LogProbability))
+		}
+		inside[1][i] = cell
+	}
+	for length := 2; length <= len(query); length++ {
+		columns := len(query) - length + 1
+		inside[length] = make([]map[int]float64, columns)
+		for start := 0; start < columns; start++ {
+			cell := map[int]float64{}
+			for partition := 1; partition < length; partition++ {
+				for first, leftLogp := range inside[partition][start] {
+					right := inside[length - partition][start + partition]
+					for second, rightLogp := range right {
+						for _, rule := range grammar.lookupRules(first, second) {
+							addLogProb(cell, rule.Source, float64(rule.LogProbability) + leftLogp + rightLogp)
+						}
+					}
+				}
+			}
+			inside[length][start] = cell
+		}
+	}
+	if logp, ok := inside[len(query)][0][rootId]; ok {
+		return logp
+	}
+	return math.Inf(-1)
+}
+
+// addLogProb adds the probability exp(logp) to cell[symbol] in log space
+func addLogProb(cell map[int]float64, symbol int, logp float64) {
+	sum, ok := cell[symbol]
+	if !ok || math.IsInf(sum, -1) {
+		cell[symbol] = logp
+		return
+	}
+	if logp > sum {
+		sum, logp = logp, sum
+	}
+	cell[symbol] = sum + math.Log1p(math.Exp(logp - sum))
+}
+
+// ProbabilityGivenLength returns the probability of query among the sentences
+// with the same number of tokens, P(query) / P(length), so that it sums to 1
+// over all sentences of that length. It's 0 if no sentence of that length
+// derives from <root>
+func (p *Parser) ProbabilityGivenLength(query []string) float64 {
+	probability := p.Probability(query)
+	if probability == 0 {
+		return 0
+	}
+
+	// Any token: each symbol derives a token with the total probability of
+	// its terminal rules
+	anyToken := map[int]float64{}
+	for _, rules := range p.cnfGrammar.TerminalRules {
+		for _, rule := range rules {
+			anyToken[rule.Source] += float64(rule.Probability)
+		}
+	}
+	cells := make([]map[int]float64, len(query))
+	for i := range cells {
+		cells[i] = anyToken
+	}
+	return probability / insideProbability(p.cnfGrammar, cells)
+}
+
+// insideProbability returns the inside probability of <root> over a sentence,
+// where cells[i] maps symbol to the probability of it deriving the i-th token
+func insideProbability(grammar *CNFGrammar, cells []map[int]float64) float64 {
+	rootId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || len(cells) == 0 {
+		return 0
+	}
+
+	// inside[length][start] maps symbol to the probability of it deriving span
+	// [start, start + length)
+	inside := make([][]map[int]float64, len(cells) + 1)
+	inside[1] = cells
+	for length := 2; length <= len(cells); length++ {
+		columns := len(cells) - length + 1
+		inside[length] = make([]map[int]float64, columns)
+		for start := 0; start < columns; start++ {
+			cell := map[int]float64{}
+			for partition := 1; partition < length; partition++ {
+				for first, leftP := range inside[partition][start] {
+					right := inside[length - partition][start + partition]
+					for second, rightP := range right {
+						for _, rule := range grammar.lookupRules(first, second) {
+							cell[rule.Source] += float64(rule.Probability) * leftP * rightP
+						}
+					}
+				}
+			}
+			inside[length][start] = cell
+		}
+	}
+	return inside[len(cells)][0][rootId]
+}
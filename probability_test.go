@@ -0,0 +1,113 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestProbability(t *testing.T) {
+	parser, err := NewParser(`
+		<e> ::= <e> + <e> ; 0.4 | x ; 0.3 | y ; 0.3
+		<root> ::= <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		query string
+		probability float64
+		givenLength float64
+	}{
+		// TestCase-1: single token, P(len 1) = 0.6
+		{"x", 0.3, 0.5},
+
+		// TestCase-2: one parse, 0.4 * 0.3 * 0.3 in P(len 3) = 0.4 * 0.6 * 0.6
+		{"x + y", 0.036, 0.25},
+
+		// TestCase-3: two parses, 2 * 0.4^2 * 0.3^3 in P(len 5) =
+		// 2 * 0.4^2 * 0.6^3
+		{"x + y + x", 2 * 0.16 * 0.027, 0.125},
+
+		// TestCase-4: not matched
+		{"x +", 0, 0},
+	}
+	for _, testCase := range testCases {
+		query := strings.Fields(testCase.query)
+		probability := parser.Probability(query)
+		if math.Abs(probability - testCase.probability) > probTolerance {
+			t.Fatalf("%s: %f != %f", testCase.query, probability, testCase.probability)
+		}
+		givenLength := parser.ProbabilityGivenLength(query)
+		if math.Abs(givenLength - testCase.givenLength) > probTolerance {
+			t.Fatalf("%s: %f != %f", testCase.query, givenLength, testCase.givenLength)
+		}
+	}
+}
+
+func TestProbabilityMatchTokens(t *testing.T) {
+	parser, err := NewParser(`
+		<e> ::= <e> + <e> ; 0.4 | X ; 0.2 | /[0-9]+/ ; 0.2 | <?unk> ; 0.2
+		<root> ::= <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.SetCaseInsensitive(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens match case-insensitively, by regex and by <?unk> as in Parse
+	for _, query := range []string{"x", "42", "zz"} {
+		probability := parser.Probability([]string{query})
+		if math.Abs(probability - 0.2) > probTolerance {
+			t.Fatalf("%s: %f != %f", query, probability, 0.2)
+		}
+		logp := parser.InsideProbability([]string{query})
+		if math.Abs(logp - math.Log(0.2)) > probTolerance {
+			t.Fatalf("%s: %f != %f", query, logp, math.Log(0.2))
+		}
+	}
+	query := strings.Fields("x + 42")
+	if probability := parser.Probability(query); math.Abs(probability - 0.016) > probTolerance {
+		t.Fatalf("%f != %f", probability, 0.016)
+	}
+}
+
+func TestInsideProbability(t *testing.T) {
+	parser, err := NewParser(`
+		<e> ::= <e> + <e> ; 0.4 | x ; 0.3 | y ; 0.3
+		<root> ::= <e>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: same as Probability
+	for _, query := range []string{"x", "x + y", "x + y + x"} {
+		logp := parser.InsideProbability(strings.Fields(query))
+		expected := math.Log(parser.Probability(strings.Fields(query)))
+		if math.Abs(logp - expected) > probTolerance {
+			t.Fatalf("%s: %f != %f", query, logp, expected)
+		}
+	}
+
+	// TestCase-2: not matched
+	if logp := parser.InsideProbability(strings.Fields("x +")); !math.IsInf(logp, -1) {
+		t.Fatalf("-Inf expected, but got %f", logp)
+	}
+
+	// TestCase-3: long query underflows in linear space
+	parser, err = NewParser(`
+		<l> ::= x <l> ; 0.001 | x ; 0.999
+		<root> ::= <l>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields(strings.TrimSpace(strings.Repeat("x ", 120)))
+	if probability := parser.Probability(query); probability != 0 {
+		t.Fatalf("underflow expected, but got %g", probability)
+	}
+	logp := parser.InsideProbability(query)
+	expected := 119 * math.Log(0.001) + math.Log(0.999)
+	if math.Abs(logp - expected) > probTolerance * math.Abs(expected) {
+		t.Fatalf("%f != %f", logp, expected)
+	}
+}
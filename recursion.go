@@ -0,0 +1,200 @@
+package pcfg
+
+import (
+	"math"
+	"sort"
+)
+
+// RecursionInfo reports a recursive non-terminal symbol
+type RecursionInfo struct {
+	Symbol Symbol
+
+	// Expected number of tokens derived from Symbol according to the
+	// weights, +Inf if the expansion never stops on average
+	ExpectedLength float64
+}
+
+// The limits of iterations when computing expected lengths
+const (
+	recursionMaxIterations = 10000
+	recursionTolerance = 1e-9
+	recursionMaxLength = 1e9
+)
+
+// RecursionReport finds the recursive non-terminal symbols, which could derive
+// a sequence including themselves, and reports their expected expansion
+// length computed from the weights. Symbols with a large expected length may
+// slow down parsing. The result is ordered by symbol
+func (g *Grammar) RecursionReport() []RecursionInfo {
+	recursive := g.recursiveComponents()
+
+	lengths := g.expectedLengths()
+	report := []RecursionInfo{}
+	for symbol := range recursive {
+		report = append(report, RecursionInfo{
+			Symbol: symbol,
+			ExpectedLength: lengths[symbol],
+		})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Symbol < report[j].Symbol
+	})
+	return report
+}
+
+// recursiveComponents finds the recursive non-terminal symbols, and maps each
+// of them to the index of its strong component in the graph from left symbol
+// to non-terminal symbols in right side
+func (g *Grammar) recursiveComponents() map[Symbol]int {
+	graph := NewDirectedGraph()
+	for _, rule := range g.Rules {
+		for _, symbol := range rule.Right {
+			if !symbol.IsTerminal() {
+				graph.Add(Vertex(rule.Left), Vertex(symbol), 1.0)
+			}
+		}
+	}
+	components := map[Symbol]int{}
+	strongComponents := graph.StrongComponents()
+	for i, component := range strongComponents {
+		for _, v := range component {
+			components[Symbol(v)] = i
+		}
+	}
+	for v := range graph.Vertices {
+		if _, ok := components[Symbol(v)]; !ok && graph.HasArc(v, v) {
+			components[Symbol(v)] = len(strongComponents)
+			strongComponents = append(strongComponents, []Vertex{v})
+		}
+	}
+	return components
+}
+
+// RecursionKind is the kind of recursion in grammar
+type RecursionKind int
+
+const (
+	// No recursive symbols
+	NonRecursive RecursionKind = iota
+
+	// Recursive symbols only occur at the beginning of right side in their
+	// recursive rules, like <a> ::= <a> x
+	LeftLinear
+
+	// Recursive symbols only occur at the end of right side in their
+	// recursive rules, like <a> ::= x <a>
+	RightLinear
+
+	// Others, including grammars mixing left and right recursion
+	CenterRecursive
+)
+
+// String returns the name of kind
+func (k RecursionKind) String() string {
+	switch k {
+	case NonRecursive:
+		return "non-recursive"
+	case LeftLinear:
+		return "left-linear"
+	case RightLinear:
+		return "right-linear"
+	}
+	return "center-recursive"
+}
+
+// RecursionKind classifies the recursion of grammar by the positions of
+// recursive symbols in the right side of rules. A recursive rule is a rule
+// with symbols from the strong component of its left symbol in right side.
+// Left and right linear grammars could be parsed faster than CYK
+func (g *Grammar) RecursionKind() RecursionKind {
+	components := g.recursiveComponents()
+	left, right := false, false
+	for _, rule := range g.Rules {
+		component, ok := components[rule.Left]
+		if !ok {
+			continue
+		}
+		positions := []int{}
+		for i, symbol := range rule.Right {
+			if c, ok := components[symbol]; ok && c == component {
+				positions = append(positions, i)
+			}
+		}
+		switch {
+		case len(positions) == 0:
+			continue
+		case len(positions) > 1:
+			return CenterRecursive
+		case len(rule.Right) == 1:
+			// Unit rule like <a> ::= <b> keeps linearity
+			continue
+		case positions[0] == 0:
+			left = true
+		case positions[0] == len(rule.Right) - 1:
+			right = true
+		default:
+			return CenterRecursive
+		}
+	}
+
+	switch {
+	case left && right:
+		return CenterRecursive
+	case left:
+		return LeftLinear
+	case right:
+		return RightLinear
+	}
+
+	// No recursive symbols, or only unit rules in cycles like
+	// <a> ::= <b> and <b> ::= <a>
+	return NonRecursive
+}
+
+// expectedLengths computes the expected number of tokens derived from each
+// non-terminal symbol by iterating
+//     E[A] = sum(P(A -> B C ...) * (E[B] + E[C] + ...))
+// from E = 0, where E[terminal] = 1 and E[<nil>] = 0
+func (g *Grammar) expectedLengths() map[Symbol]float64 {
+	totals := map[Symbol]float64{}
+	for _, rule := range g.Rules {
+		totals[rule.Left] += rule.Weight
+	}
+
+	lengths := map[Symbol]float64{}
+	lengthOf := func(symbol Symbol) float64 {
+		if symbol == EpsilonSymbol {
+			return 0
+		}
+		if symbol.IsTerminal() {
+			return 1
+		}
+		return lengths[symbol]
+	}
+	for i := 0; i < recursionMaxIterations; i++ {
+		next := map[Symbol]float64{}
+		for _, rule := range g.Rules {
+			length := 0.0
+			for _, symbol := range rule.Right {
+				length += lengthOf(symbol)
+			}
+			next[rule.Left] += rule.Weight / totals[rule.Left] * length
+		}
+
+		delta := 0.0
+		for symbol, length := range next {
+			if length > recursionMaxLength {
+				length = math.Inf(1)
+			}
+			if !math.IsInf(length, 1) {
+				delta = math.Max(delta, math.Abs(length - lengths[symbol]))
+			}
+			next[symbol] = length
+		}
+		lengths = next
+		if delta < recursionTolerance {
+			break
+		}
+	}
+	return lengths
+}
@@ -0,0 +1,59 @@
+package pcfg
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRecursionReport(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<item> ::= apple | banana
+		<list> ::= <item> ; 0.75 | <item> and <list> ; 0.25
+		<explosive> ::= x ; 0.4 | <explosive> <explosive> ; 0.6
+		<root> ::= buy <list> | <explosive>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := grammar.RecursionReport()
+	if len(report) != 2 {
+		t.Fatalf("2 recursive symbols expected, but got %v", report)
+	}
+
+	// E[<list>] = 0.75 * 1 + 0.25 * (1 + 1 + E[<list>]) = 5/3
+	if report[1].Symbol != "<list>" || math.Abs(report[1].ExpectedLength - 5.0 / 3.0) > 1e-6 {
+		t.Fatalf("<list> with expected length 5/3 expected, but got %v", report[1])
+	}
+
+	// <explosive> expands more than stops
+	if report[0].Symbol != "<explosive>" || !math.IsInf(report[0].ExpectedLength, 1) {
+		t.Fatalf("<explosive> with infinite expected length expected, but got %v", report[0])
+	}
+}
+
+func TestRecursionKind(t *testing.T) {
+	for text, expected := range map[string]RecursionKind{
+		`<city> ::= seattle | beijing
+		 <root> ::= weather in <city>`: NonRecursive,
+		`<list> ::= <item> | <list> and <item>
+		 <item> ::= apple | banana
+		 <root> ::= buy <list>`: LeftLinear,
+		`<list> ::= <item> | <item> and <list>
+		 <item> ::= apple | banana
+		 <root> ::= buy <list>`: RightLinear,
+		`<a> ::= x <b> | y
+		 <b> ::= <a> z
+		 <root> ::= <a>`: CenterRecursive,
+		`<e> ::= <e> + <e> | x
+		 <root> ::= <e>`: CenterRecursive,
+		`<a> ::= <a> x | x <a> | x
+		 <root> ::= <a>`: CenterRecursive,
+	} {
+		grammar, err := ParseGrammar(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := grammar.RecursionKind(); kind != expected {
+			t.Fatalf("'%s' != '%s' for grammar:\n%s", kind, expected, text)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package pcfg
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// IsRegex checks if it is a regex terminal, like /[0-9]+/, which matches the
+// query tokens by the pattern between slashes
+func (s Symbol) IsRegex() bool {
+	return len(s) > 2 &&
+		s[0] == '/' &&
+		s[len(s) - 1] == '/' &&
+		!strings.ContainsAny(string(s), " \t\r\n")
+}
+
+// compileRegexTerminal compiles the pattern of regex terminal s, which should
+// match the whole token. The patterns are not lowercased like other terminals,
+// but matched case-insensitively if ignoreCase is true
+func compileRegexTerminal(s Symbol, ignoreCase bool) (*regexp.Regexp, error) {
+	flags := ""
+	if ignoreCase {
+		flags = "(?i)"
+	}
+	return regexp.Compile(flags + "^(?:" + string(s[1: len(s) - 1]) + ")$")
+}
+
+// _RegexTerminal is a regex terminal of CNFGrammar with its compiled pattern.
+// The rules of it are TerminalRules[terminal]
+type _RegexTerminal struct {
+	terminal string
+	pattern *regexp.Regexp
+}
+
+// addRegexTerminal compiles terminal if it's a regex terminal not added yet
+func (g *CNFGrammar) addRegexTerminal(terminal string) {
+	if !Symbol(terminal).IsRegex() {
+		return
+	}
+	for _, regex := range g.regexTerminals {
+		if regex.terminal == terminal {
+			return
+		}
+	}
+	pattern, err := compileRegexTerminal(Symbol(terminal), g.caseInsensitive)
+	assert(err == nil, "CNFGrammar::addRegexTerminal: invalid regex " + terminal)
+	g.regexTerminals = append(g.regexTerminals, _RegexTerminal{terminal, pattern})
+}
+
+// compileRegexTerminals compiles the regex terminals in TerminalRules, for the
+// grammars whose TerminalRules are not added by AddRule
+func (g *CNFGrammar) compileRegexTerminals() {
+	terminals := []string{}
+	for terminal := range g.TerminalRules {
+		terminals = append(terminals, terminal)
+	}
+	sort.Strings(terminals)
+	g.regexTerminals = nil
+	for _, terminal := range terminals {
+		g.addRegexTerminal(terminal)
+	}
+}
+
+// regexRules returns the terminal rules of the regex terminals matching token,
+// nil if none. A token equal to the regex terminal itself matches it exactly,
+// so that it's not matched again here
+func (g *CNFGrammar) regexRules(token string) []*CNFTerminalRule {
+	var rules []*CNFTerminalRule
+	for _, regex := range g.regexTerminals {
+		if regex.terminal != token && regex.pattern.MatchString(token) {
+			rules = append(rules, g.TerminalRules[regex.terminal]...)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,72 @@
+package pcfg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegexTerminal(t *testing.T) {
+	grammarText := `
+		<number> ::= /[0-9]+/ ; 0.6 | /[0-9]+(\.[0-9]+)?|one|two/ ; 0.3 | zero ; 0.1
+		<unit> ::= kg | /lbs?/
+		<root> ::= <number> <unit> | call <number>
+		;!exports: <number> <unit>`
+
+	// TestCase-1: the regex is a single terminal, '|' and '?' in it don't
+	// split the rule
+	rules, err := ParseRule(`<number> ::= /[0-9]+(\.[0-9]+)?|one|two/ ; 0.3 | zero`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || !rules[0].Right[0].IsRegex() || rules[1].Right[0] != "zero" {
+		t.Fatalf("a regex terminal and zero expected, but got %v", rules)
+	}
+
+	// TestCase-2: parse with regex terminals, besides the exact terminals
+	parser, err := NewParser(grammarText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"42 kg": "(<root> \n  (<number> \n    42) \n  (<unit> \n    kg))",
+		"2.5 lb": "(<root> \n  (<number> \n    2.5) \n  (<unit> \n    lb))",
+		"call one": "(<root> \n  call \n  (<number> \n    one))",
+		"zero lbs": "(<root> \n  (<number> \n    zero) \n  (<unit> \n    lbs))",
+		"7": "",
+		"call 4x": "",
+	}
+	for query, tree := range expected {
+		parsed := parser.Parse(strings.Fields(query))
+		if tree == "" && parsed != nil || tree != "" && (parsed == nil || parsed.String() != tree) {
+			t.Fatalf("'%v' != '%s'", parsed, tree)
+		}
+	}
+
+	// TestCase-3: single token without CYK table
+	alone, err := NewParser("<root> ::= /[0-9]+(\\.[0-9]+)?|one|two/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree := alone.Parse([]string{"3"}); tree == nil || tree.String() != "(<root> \n  3)" {
+		t.Fatalf("'%v' != '%s'", tree, "(<root> \n  3)")
+	}
+
+	// TestCase-4: kept by Save and LoadParser
+	buffer := &bytes.Buffer{}
+	if err := parser.Save(buffer); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadParser(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree := loaded.Parse(strings.Fields("42 kg")); tree == nil || tree.String() != expected["42 kg"] {
+		t.Fatalf("'%v' != '%s'", tree, expected["42 kg"])
+	}
+
+	// TestCase-5: invalid regex
+	if _, err := ParseRule("<number> ::= /[0-9/"); err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
@@ -0,0 +1,116 @@
+package pcfg
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// ApplyCNFWeights sets the weights of rules in g from the probabilities of cnf,
+// like the ones re-estimated from a corpus. cnf should be converted from a
+// grammar parsed from the same text as g, since the CNF rules are traced back
+// to their source rules by Rule.Order. The weight of rule is the probability
+// of its CNF rule from the same left symbol, multiplied by the internal rules
+// of its binarization. Returns the rules which couldn't be mapped cleanly and
+// keep their weights:
+//   - the unit rules, and the rules merged into them (see removeUnitRules)
+//   - the rules with nullable symbols, which are split into several rules
+//   - the null rules, which are removed
+//   - the rules in cycles of unit rules (see removeStrongComponents)
+// The rules sharing prefixes (see Grammar.FactorPrefixes) are not supported,
+// since their binarization is shared
+func (g *Grammar) ApplyCNFWeights(cnf *CNFGrammar) []*Rule {
+	// CNF rules by order, from the left symbol of source rule and from the
+	// internal symbols of binarization
+	tops := map[int][]*CNFRuleBase{}
+	internals := map[int]float64{}
+	addRule := func(rule *CNFRuleBase) {
+		source := cnf.Symbols[rule.Source]
+		if strings.HasPrefix(source, "<__") {
+			if _, ok := internals[rule.Order]; !ok {
+				internals[rule.Order] = 1.0
+			}
+			internals[rule.Order] *= float64(rule.Probability)
+		} else if len(rule.Path) == 0 {
+			tops[rule.Order] = append(tops[rule.Order], rule)
+		}
+	}
+	for _, rules := range cnf.TerminalRules {
+		for _, rule := range rules {
+			addRule(&rule.CNFRuleBase)
+		}
+	}
+	for _, secondRules := range cnf.Rules {
+		for _, rules := range secondRules {
+			for _, rule := range rules {
+				addRule(&rule.CNFRuleBase)
+			}
+		}
+	}
+
+	unmapped := []*Rule{}
+	for _, rule := range g.Rules {
+		candidates := []*CNFRuleBase{}
+		for _, cnfRule := range tops[rule.Order] {
+			if cnf.Symbols[cnfRule.Source] == string(rule.Left) {
+				candidates = append(candidates, cnfRule)
+			}
+		}
+		if len(candidates) != 1 || rule.IsUnary() && !rule.Right[0].IsTerminal() {
+			unmapped = append(unmapped, rule)
+			continue
+		}
+		rule.Weight = float64(candidates[0].Probability)
+		if len(rule.Right) > 1 {
+			if p, ok := internals[rule.Order]; ok {
+				rule.Weight *= p
+			}
+		}
+	}
+	return unmapped
+}
+
+// ReweightGrammar rewrites the weights in grammar text from the probabilities
+// of cnf, which is converted from text, see Grammar.ApplyCNFWeights. The rules
+// are re-emitted by FormatGrammar, and the comments and commands are kept.
+// Returns the rules which keep their weights, including the ones from
+// commands like ;!synonyms:
+func ReweightGrammar(text string, cnf *CNFGrammar) (string, []*Rule, error) {
+	grammar, err := ParseGrammar(text)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "ReweightGrammar")
+	}
+	unmapped := grammar.ApplyCNFWeights(cnf)
+	isUnmapped := map[*Rule]bool{}
+	for _, rule := range unmapped {
+		isUnmapped[rule] = true
+	}
+
+	// Rules by line, the lines of commands are kept as they are
+	rulesByLine := map[int][]*Rule{}
+	for _, rule := range grammar.Rules {
+		rulesByLine[rule.Line] = append(rulesByLine[rule.Line], rule)
+	}
+	lines := strings.Split(text, "\n")
+	for lineIdx, line := range lines {
+		rules := rulesByLine[lineIdx + 1]
+		line = strings.TrimSpace(line)
+		if len(rules) == 0 || strings.Index(line, ";") == 0 {
+			for _, rule := range rules {
+				if !isUnmapped[rule] {
+					unmapped = append(unmapped, rule)
+				}
+			}
+			continue
+		}
+		alternatives := []string{}
+		for _, rule := range rules {
+			alternatives = append(alternatives, formatAlternative(rule))
+		}
+		lines[lineIdx] = string(rules[0].Left) + " ::= " + strings.Join(alternatives, " | ")
+	}
+	formatted, err := FormatGrammar(strings.Join(lines, "\n"))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "ReweightGrammar")
+	}
+	return formatted, unmapped, nil
+}
@@ -0,0 +1,93 @@
+package pcfg
+
+import (
+	"math"
+	"testing"
+)
+
+const reweightGrammar = `
+; Cities
+<city> ::= seattle ; 0.5 | beijing ; 0.5
+<root> ::= what is the weather in <city> ; 0.5 | <city> weather ; 0.5
+;!exports: <city>`
+
+func TestReweightGrammar(t *testing.T) {
+	parser, err := NewParser(reweightGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-estimate the probabilities of CNF rules, as training does
+	trained := map[string]float64{
+		"<city> ::= seattle": 0.2,
+		"<city> ::= beijing": 0.8,
+		"<root> ::= what is the weather in <city>": 0.3,
+		"<root> ::= <city> weather": 0.7,
+	}
+	cnf := parser.cnfGrammar
+	for _, rules := range cnf.TerminalRules {
+		for _, rule := range rules {
+			if cnf.Symbols[rule.Source] == "<city>" {
+				rule.Probability = Prob(trained["<city> ::= " + rule.TerminalTarget])
+			}
+		}
+	}
+	for _, secondRules := range cnf.Rules {
+		for _, rules := range secondRules {
+			for _, rule := range rules {
+				if cnf.Symbols[rule.Source] == "<root>" {
+					rule.Probability = Prob(trained[parser.original.Rules[rule.Order].Id()])
+				}
+			}
+		}
+	}
+
+	// TestCase-1: weights in the rewritten text
+	text, unmapped, err := ReweightGrammar(reweightGrammar, cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar, err := ParseGrammar(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range grammar.Rules {
+		expected, ok := trained[rule.Id()]
+		if !ok {
+			continue
+		}
+		if math.Abs(rule.Weight - expected) > 1e-3 {
+			t.Fatalf("%s: %f != %f", rule.Id(), rule.Weight, expected)
+		}
+	}
+
+	if len(unmapped) != 0 {
+		t.Fatalf("no unmapped rule expected, but got %v", unmapped)
+	}
+
+	// TestCase-2: comments and exports are kept
+	expected := `; Cities
+<city> ::= seattle ; 0.200 | beijing ; 0.800
+<root> ::= what is the weather in <city> ; 0.300 | <city> weather ; 0.700
+;!exports: <city>
+`
+	if text != expected {
+		t.Fatalf("'%s' != '%s'", text, expected)
+	}
+
+	// TestCase-3: the unit rule and the rules merged into it are unmapped
+	text = "<city> ::= seattle | beijing\n<weather> ::= <city> weather\n<root> ::= <weather>"
+	parser, err = NewParser(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, unmapped, err = ReweightGrammar(text, parser.cnfGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmapped) != 2 ||
+		unmapped[0].Id() != "<weather> ::= <city> weather" ||
+		unmapped[1].Id() != "<root> ::= <weather>" {
+		t.Fatalf("the rules of <weather> and <root> expected, but got %v", unmapped)
+	}
+}
@@ -20,18 +20,53 @@ func InternalSymbol(name string) Symbol {
 const EpsilonSymbol = Symbol("<nil>")
 const RootSymbol = Symbol("<root>")
 
+// Patterns of Symbol, compiled once since grammars could have a great many
+// symbols
+var (
+	gValidSymbol = regexp.MustCompile("^(<\\??[-\\w]+>|[^<>\"?|]+|\"[^\"]+\"|/\\S+/)$")
+	gNonTextChars = regexp.MustCompile("[^_A-Za-z0-9]+")
+)
+
 // IsValid checks the symbol string is valid
 func (s Symbol) IsValid() bool {
-	matched, err := regexp.MatchString("^(<\\??[-\\w]+>|[^<>\"?|]+)$", string(s))
-	checkAndFatal(err)
-	return matched
+	return gValidSymbol.MatchString(string(s))
 }
 
-// IsTerminal checks if it is a terminal symbol, assuming s.IsValid() == true
+// IsTerminal checks if it is a terminal symbol, assuming s.IsValid() == true.
+// The quoted terminals like "good morning" are terminals as well
 func (s Symbol) IsTerminal() bool {
 	return s[0] != '<' || s == "<nil>" || s[: 2] == "<?"
 }
 
+// IsQuoted checks if it is a quoted terminal, like "good morning"
+func (s Symbol) IsQuoted() bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s) - 1] == '"'
+}
+
+// Literal returns the token matched by terminal s, which is s itself except
+// the quoted terminals, whose quotes are stripped
+func (s Symbol) Literal() string {
+	if s.IsQuoted() {
+		return string(s[1: len(s) - 1])
+	}
+	return string(s)
+}
+
+// quotedSymbol returns the terminal symbol of literal written in quotes. The
+// quotes are kept only if literal couldn't be written without them, so that
+// "weather" and weather are the same symbol
+func quotedSymbol(literal string) Symbol {
+	plain := Symbol(literal)
+	if plain.IsValid() &&
+		plain.IsTerminal() &&
+		!plain.IsRegex() &&
+		!strings.ContainsAny(literal, " \t;[]<") &&
+		!strings.Contains(literal, "::=") {
+		return plain
+	}
+	return Symbol("\"" + literal + "\"")
+}
+
 // Text return the text in Symbol, the text should be [_A-Za-z0-9] only, like
 //     <city-name> -> "city_name"
 //     <?time_s0> -> "time_s0"
@@ -44,7 +79,7 @@ func (s Symbol) Text() string {
 	} else if text[0] == '<' {
 		text = text[1: len(text) - 1]
 	}
-	return regexp.MustCompile("[^_A-Za-z0-9]+").ReplaceAllString(text, "_")
+	return gNonTextChars.ReplaceAllString(text, "_")
 }
 
 // Rule represents a PCFG rule
@@ -58,6 +93,14 @@ type Rule struct {
 	// For example, after PCFG to CNF, rule A->B, B->C, C->DE will merged into
 	// a single rule A->DE and the path is (B C)
 	Path []Symbol
+
+	// Line is the line number of this rule in grammar text, 0 if unknown
+	Line int
+
+	// Order is the index of this rule in grammar text. When parsing trees
+	// tie, the one of earlier rules wins. Rules derived in converting to CNF
+	// take the order of the rule they come from
+	Order int
 }
 
 // IsBinary returns true if it's a binary rule, like A -> BC
@@ -70,15 +113,99 @@ func (r *Rule) IsUnary() bool {
 	return len(r.Right) == 1
 }
 
+// literalMask marks the bytes of text in the literals, which are the double
+// quoted terminals and the regex terminals like /[0-9]+/, including their
+// delimiters. The separators of rule in literals don't split
+func literalMask(text string) []bool {
+	mask := make([]bool, len(text))
+	for i := 0; i < len(text); i++ {
+		end := -1
+		if text[i] == '"' {
+			if j := strings.IndexByte(text[i + 1: ], '"'); j >= 0 {
+				end = i + 1 + j
+			}
+		} else if text[i] == '/' && (i == 0 || strings.IndexByte(" \t|[", text[i - 1]) >= 0) {
+			end = regexEnd(text, i)
+		}
+		if end < 0 {
+			continue
+		}
+		for j := i; j <= end; j++ {
+			mask[j] = true
+		}
+		i = end
+	}
+	return mask
+}
+
+// regexEnd returns the index of the closing '/' of the regex terminal starting
+// at text[start], -1 if it's not a regex terminal. The pattern has no spaces,
+// and the closing '/' ends the symbol
+func regexEnd(text string, start int) int {
+	for j := start + 1; j < len(text); j++ {
+		switch {
+		case text[j] == '\\':
+			j++
+		case strings.IndexByte(" \t\r\n", text[j]) >= 0:
+			return -1
+		case text[j] == '/':
+			if j > start + 1 && (j + 1 == len(text) || strings.IndexByte(" \t\r\n|;]", text[j + 1]) >= 0) {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// splitUnquoted splits text by sep outside of the literals, see literalMask
+func splitUnquoted(text, sep string) []string {
+	mask := literalMask(text)
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if !mask[i] && strings.HasPrefix(text[i: ], sep) {
+			parts = append(parts, text[start: i])
+			start = i + len(sep)
+			i = start - 1
+		}
+	}
+	return append(parts, text[start: ])
+}
+
+// fieldsUnquoted splits text around spaces like strings.Fields, but the
+// spaces in double quotes don't split
+func fieldsUnquoted(text string) []string {
+	mask := literalMask(text)
+	fields := []string{}
+	start := -1
+	for i, c := range text {
+		isSpace := !mask[i] && strings.ContainsRune(" \t\r\n", c)
+		if start < 0 && !isSpace {
+			start = i
+		} else if start >= 0 && isSpace {
+			fields = append(fields, text[start: i])
+			start = -1
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, text[start: ])
+	}
+	return fields
+}
+
 // ParseRule parse rule from string
 // The rule would be like:
 //     <weather-1> ::= "weather" "in" <city-name>, 0.7 | <city-name> weather, 0.3
 // Then returns
 //     [{"<weather-1>", ["weather", "in", "<city-name>"], 0.7},
 //      {"<weather-1>", ["<city-name>", "weather"], 0.3}]
+// A terminal in brackets like [,] is optional, see expandOptionals. A terminal
+// in double quotes like "good morning" could contain spaces and the reserved
+// characters except '"', and it matches the token without quotes. A terminal
+// in slashes like /[0-9]+/ is a regex matching the whole token
 func ParseRule(ruleText string) (rules []*Rule, err error) {
 	rules = make([]*Rule, 0)
-	fields := strings.Split(ruleText, "::=")
+	fields := splitUnquoted(ruleText, "::=")
 	if len(fields) != 2 {
 		err = errors.New(fmt.Sprintf("ParseRule: unexpected number of ::= token in '%s'", ruleText))
 		return
@@ -92,12 +219,12 @@ func ParseRule(ruleText string) (rules []*Rule, err error) {
 	}
 
     // Right part
-	for _, right := range strings.Split(fields[1], "|") {
+	for _, right := range splitUnquoted(fields[1], "|") {
 		rule := new(Rule)
 		rule.Left = leftSymbol
 
 		right = strings.TrimSpace(right)
-		fields := strings.Split(right, ";")
+		fields := splitUnquoted(right, ";")
 		if len(fields) == 2 {
 			// Has the weight value, parse it
 			weightText := strings.TrimSpace(fields[1])
@@ -115,23 +242,85 @@ func ParseRule(ruleText string) (rules []*Rule, err error) {
 			return
 		}
 
-		// Tokens of this rule
+		// Tokens of this rule, the optional terminals like [,] are marked
 		rule.Right = make([]Symbol, 0)
-		for _, symbolString := range strings.Fields(fields[0]) {
+		optional := []bool{}
+		for _, symbolString := range fieldsUnquoted(fields[0]) {
+			isOptional := false
+			if len(symbolString) > 2 && symbolString[0] == '[' && symbolString[len(symbolString) - 1] == ']' {
+				symbolString = symbolString[1: len(symbolString) - 1]
+				isOptional = true
+			}
 			symbol := Symbol(symbolString)
-			if !symbol.IsValid() {
+			if symbol.IsQuoted() && symbol.IsValid() {
+				symbol = quotedSymbol(symbol.Literal())
+			}
+			if !symbol.IsValid() || isOptional && !symbol.IsTerminal() {
 				err = errors.New(fmt.Sprintf("ParseRule: unexpected '%s' in '%s'", symbolString, ruleText))
 				return
 			}
-			rule.Right = append(rule.Right, Symbol(symbolString))
+			if symbol.IsRegex() {
+				if _, regexErr := compileRegexTerminal(symbol, false); regexErr != nil {
+					err = errors.Wrapf(regexErr, "ParseRule: '%s'", ruleText)
+					return
+				}
+			}
+			rule.Right = append(rule.Right, symbol)
+			optional = append(optional, isOptional)
 		}
 
-		rules = append(rules, rule)
+		rules = append(rules, expandOptionals(rule, optional)...)
 	}
 
 	return
 }
 
+// expandOptionals expands the optional terminals in the right of rule into the
+// rules with and without them, and the weight of rule is split equally. For
+// example, <addr> ::= <street> [,] <city> ; 0.6 is expanded to
+//     <addr> ::= <street> <city> ; 0.3
+//     <addr> ::= <street> , <city> ; 0.3
+// The rule without any symbol in the right derives <nil>
+func expandOptionals(rule *Rule, optional []bool) []*Rule {
+	rights := [][]Symbol{{}}
+	for i, symbol := range rule.Right {
+		expanded := [][]Symbol{}
+		for _, right := range rights {
+			if optional[i] {
+				expanded = append(expanded, append([]Symbol{}, right...))
+			}
+			expanded = append(expanded, append(append([]Symbol{}, right...), symbol))
+		}
+		rights = expanded
+	}
+	if len(rights) == 1 {
+		return []*Rule{rule}
+	}
+
+	rules := []*Rule{}
+	for _, right := range rights {
+		if len(right) == 0 {
+			right = []Symbol{EpsilonSymbol}
+		}
+		rules = append(rules, &Rule{
+			Left: rule.Left,
+			Right: right,
+			Weight: rule.Weight / float64(len(rights)),
+		})
+	}
+	return rules
+}
+
+// Id returns the identifier of rule, which is the rule in string format
+// without weight, like "<weather> ::= weather in <city>"
+func (r *Rule) Id() string {
+	symbols := []string{}
+	for _, symbol := range r.Right {
+		symbols = append(symbols, string(symbol))
+	}
+	return fmt.Sprintf("%s ::= %s", string(r.Left), strings.Join(symbols, " "))
+}
+
 // String converts rule to string format
 func (r *Rule) String() string {
 	symbols := []string{}
@@ -152,3 +341,10 @@ func (r *Rule) String() string {
 	}
 	return s
 }
+
+// Canonical converts rule to the string format that ParseRule reads back as
+// an equal rule, with the weight in full precision. Unlike String, Path and
+// Line are not kept
+func (r *Rule) Canonical() string {
+	return fmt.Sprintf("%s ; %s", r.Id(), strconv.FormatFloat(r.Weight, 'g', -1, 64))
+}
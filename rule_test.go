@@ -1,6 +1,7 @@
 package pcfg
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -55,3 +56,133 @@ func TestParseRule(t *testing.T) {
 		t.Fatal("err != nil expected")
 	}
 }
+
+func TestOptionalTerminal(t *testing.T) {
+	// TestCase-1: expanded with split weight
+	r, err := ParseRule("<addr> ::= <street> [,] <city> ; 0.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"<addr> ::= <street> <city> ; 0.300",
+		"<addr> ::= <street> , <city> ; 0.300",
+	}
+	if len(r) != len(expected) {
+		t.Fatalf("%d rules expected, but got %d", len(expected), len(r))
+	}
+	for i, rule := range r {
+		if rule.String() != expected[i] {
+			t.Fatalf("'%s' != '%s'", rule.String(), expected[i])
+		}
+	}
+
+	// TestCase-2: optional non-terminal is not supported
+	_, err = ParseRule("<addr> ::= <street> [<city>]")
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+
+	// TestCase-3: parse with or without the optional terminal
+	parser, err := NewParser(`
+		<street> ::= main street | oak avenue
+		<city> ::= portland | salem
+		<root> ::= <street> [,] <city>
+		;!exports: <street> <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse(strings.Fields("main street portland"))
+	expectedTree := "(<root> \n  (<street> \n    main \n    street) \n  (<city> \n    portland))"
+	if tree == nil || tree.String() != expectedTree {
+		t.Fatalf("'%v' != '%s'", tree, expectedTree)
+	}
+	tree = parser.Parse(strings.Fields("main street , portland"))
+	expectedTree = "(<root> \n  (<street> \n    main \n    street) \n  , \n  (<city> \n    portland))"
+	if tree == nil || tree.String() != expectedTree {
+		t.Fatalf("'%v' != '%s'", tree, expectedTree)
+	}
+}
+
+func TestRuleCanonical(t *testing.T) {
+	rules := []*Rule{
+		{Left: "<a>", Right: []Symbol{"weather", "in", "<city-name>"}, Weight: 1.0},
+		{Left: "<a>", Right: []Symbol{"<b>"}, Weight: 1.0 / 3},
+		{Left: "<a>", Right: []Symbol{"上海", "天气"}, Weight: 1e-20},
+		{Left: "<a>", Right: []Symbol{"<?time>", "<nil>"}, Weight: 123456.789},
+		{Left: "<a>", Right: []Symbol{"x"}, Weight: 0.1, Path: []Symbol{"<b>"}, Line: 3},
+	}
+	for _, rule := range rules {
+		parsed, err := ParseRule(rule.Canonical())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed) != 1 {
+			t.Fatalf("%s: 1 rule expected, but got %d", rule.Canonical(), len(parsed))
+		}
+		if parsed[0].Id() != rule.Id() || parsed[0].Weight != rule.Weight {
+			t.Fatalf("'%s' != '%s'", parsed[0].Canonical(), rule.Canonical())
+		}
+		if parsed[0].Canonical() != rule.Canonical() {
+			t.Fatalf("'%s' != '%s'", parsed[0].Canonical(), rule.Canonical())
+		}
+	}
+}
+
+func TestQuotedTerminal(t *testing.T) {
+	// TestCase-1: quotes kept only when needed
+	r, err := ParseRule(`<greeting> ::= "good morning" | "weather" "a|b;c" | ["<b>"] x ; 0.4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`<greeting> ::= "good morning" ; 1.000`,
+		`<greeting> ::= weather "a|b;c" ; 1.000`,
+		`<greeting> ::= x ; 0.200`,
+		`<greeting> ::= "<b>" x ; 0.200`,
+	}
+	if len(r) != len(expected) {
+		t.Fatalf("%d rules expected, but got %d", len(expected), len(r))
+	}
+	for i, rule := range r {
+		if rule.String() != expected[i] {
+			t.Fatalf("'%s' != '%s'", rule.String(), expected[i])
+		}
+	}
+	if !r[0].Right[0].IsTerminal() || r[0].Right[0].Literal() != "good morning" {
+		t.Fatalf("terminal 'good morning' expected, but got '%s'", r[0].Right[0])
+	}
+
+	// TestCase-2: round trip by Canonical
+	for _, rule := range r {
+		parsed, err := ParseRule(rule.Canonical())
+		if err != nil || len(parsed) != 1 || parsed[0].Canonical() != rule.Canonical() {
+			t.Fatalf("'%s' expected, but got %v (%v)", rule.Canonical(), parsed, err)
+		}
+	}
+
+	// TestCase-3: failed cases
+	for _, text := range []string{`<a> ::= "good morning`, `<a> ::= ""`, `<a> ::= "a"b"`} {
+		if _, err := ParseRule(text); err == nil {
+			t.Fatalf("err != nil expected for '%s'", text)
+		}
+	}
+
+	// TestCase-4: CYK matches the token without quotes
+	parser, err := NewParser(`
+		<greeting> ::= "good morning" | "<hi>"
+		<root> ::= <greeting> "bob's"
+		;!exports: <greeting>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse([]string{"good morning", "bob's"})
+	expectedTree := "(<root> \n  (<greeting> \n    good morning) \n  bob's)"
+	if tree == nil || tree.String() != expectedTree {
+		t.Fatalf("'%v' != '%s'", tree, expectedTree)
+	}
+	tree = parser.Parse([]string{"<hi>", "bob's"})
+	expectedTree = "(<root> \n  (<greeting> \n    <hi>) \n  bob's)"
+	if tree == nil || tree.String() != expectedTree {
+		t.Fatalf("'%v' != '%s'", tree, expectedTree)
+	}
+}
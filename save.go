@@ -0,0 +1,190 @@
+package pcfg
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// Version of the format written by Parser.Save
+const _SaveVersion = 1
+
+// _SavedGrammar is the serialized form of Grammar
+type _SavedGrammar struct {
+	Rules []*Rule
+	Exports map[Symbol]bool
+	FactorPrefixes bool
+	MaxAmbiguity float64
+	NormalizeUnicode bool
+	CaseInsensitive bool
+	MinProbability float64
+	Priors map[Symbol]float64
+	Separator string
+	Atomics map[Symbol]bool
+	PreserveDistribution bool
+	Temperature float64
+}
+
+// _SavedCNFGrammar is the serialized form of CNFGrammar
+type _SavedCNFGrammar struct {
+	SymbolIds map[string]int
+	Symbols []string
+	TerminalRules map[string][]*CNFTerminalRule
+	Rules map[int]map[int][]*CNFRule
+	Exports map[int]bool
+	NormalizeUnicode bool
+	CaseInsensitive bool
+	Separator string
+	Atomics map[int]bool
+}
+
+// _SavedParser is the serialized form of Parser
+type _SavedParser struct {
+	Version int
+	Grammar _SavedGrammar
+	Original _SavedGrammar
+	CNFGrammar _SavedCNFGrammar
+	StripRoot bool
+	FuzzyDistance int
+	CompactForest bool
+	Forbidden [][2]string
+	TieEpsilon float64
+	MaxNodes int
+	WithRules bool
+	LongestMatch bool
+	Unknown string
+	ParseByLimit int
+}
+
+// saveGrammar converts g to _SavedGrammar
+func saveGrammar(g *Grammar) _SavedGrammar {
+	return _SavedGrammar{
+		Rules: g.Rules,
+		Exports: g.Exports,
+		FactorPrefixes: g.factorPrefixes,
+		MaxAmbiguity: g.maxAmbiguity,
+		NormalizeUnicode: g.normalizeUnicode,
+		CaseInsensitive: g.caseInsensitive,
+		MinProbability: g.minProbability,
+		Priors: g.priors,
+		Separator: g.separator,
+		Atomics: g.atomics,
+		PreserveDistribution: g.preserveDistribution,
+		Temperature: g.temperature,
+	}
+}
+
+// loadGrammar converts _SavedGrammar back to Grammar
+func loadGrammar(saved _SavedGrammar) *Grammar {
+	g := newGrammar()
+	g.Rules = append(g.Rules, saved.Rules...)
+	for symbol := range saved.Exports {
+		g.Exports[symbol] = true
+	}
+	g.factorPrefixes = saved.FactorPrefixes
+	g.maxAmbiguity = saved.MaxAmbiguity
+	g.normalizeUnicode = saved.NormalizeUnicode
+	g.caseInsensitive = saved.CaseInsensitive
+	g.minProbability = saved.MinProbability
+	g.priors = saved.Priors
+	g.separator = saved.Separator
+	g.atomics = saved.Atomics
+	g.preserveDistribution = saved.PreserveDistribution
+	g.temperature = saved.Temperature
+	return g
+}
+
+// Save writes the parser, including the original grammar, the converted CNF
+// grammar and the options of parser, to w. LoadParser reads it back without
+// converting the grammar again. The functions and the references to other
+// objects can't be saved, which are
+//   - the shared vocabulary, see Grammar.UseVocabulary
+//   - the TieBreak function, while its epsilon is saved
+//   - the tokenizer of ParseString, see SetTokenizer
+//   - the audit log of conversion, see Grammar.AuditLog
+func (p *Parser) Save(w io.Writer) error {
+	cnf := p.cnfGrammar
+	saved := _SavedParser{
+		Version: _SaveVersion,
+		Grammar: saveGrammar(p.grammar),
+		Original: saveGrammar(p.original),
+		CNFGrammar: _SavedCNFGrammar{
+			SymbolIds: cnf.SymbolIds,
+			Symbols: cnf.Symbols,
+			TerminalRules: cnf.TerminalRules,
+			Rules: cnf.Rules,
+			Exports: cnf.Exports,
+			NormalizeUnicode: cnf.normalizeUnicode,
+			CaseInsensitive: cnf.caseInsensitive,
+			Separator: cnf.separator,
+			Atomics: cnf.atomics,
+		},
+		StripRoot: p.stripRoot,
+		FuzzyDistance: p.options.fuzzyDistance,
+		CompactForest: p.options.compactForest,
+		Forbidden: p.options.forbidden,
+		TieEpsilon: p.options.tieEpsilon,
+		MaxNodes: p.options.maxNodes,
+		WithRules: p.options.withRules,
+		LongestMatch: p.options.longestMatch,
+		Unknown: p.options.unknown,
+		ParseByLimit: p.parseByLimit,
+	}
+	if err := gob.NewEncoder(w).Encode(&saved); err != nil {
+		return errors.Wrap(err, "Parser.Save")
+	}
+	return nil
+}
+
+// LoadParser reads the parser written by Parser.Save from r
+func LoadParser(r io.Reader) (*Parser, error) {
+	saved := _SavedParser{}
+	if err := gob.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, errors.Wrap(err, "LoadParser")
+	}
+	if saved.Version != _SaveVersion {
+		return nil, errors.New(fmt.Sprintf(
+			"LoadParser: unexpected version %d",
+			saved.Version))
+	}
+
+	cnf := NewCNFGrammar()
+	cnf.SymbolIds = saved.CNFGrammar.SymbolIds
+	cnf.Symbols = saved.CNFGrammar.Symbols
+	if saved.CNFGrammar.TerminalRules != nil {
+		cnf.TerminalRules = saved.CNFGrammar.TerminalRules
+	}
+	if saved.CNFGrammar.Rules != nil {
+		cnf.Rules = saved.CNFGrammar.Rules
+	}
+	if saved.CNFGrammar.Exports != nil {
+		cnf.Exports = saved.CNFGrammar.Exports
+	}
+	if cnf.SymbolIds == nil {
+		cnf.SymbolIds = map[string]int{}
+	}
+	cnf.normalizeUnicode = saved.CNFGrammar.NormalizeUnicode
+	cnf.caseInsensitive = saved.CNFGrammar.CaseInsensitive
+	cnf.separator = saved.CNFGrammar.Separator
+	cnf.compileRegexTerminals()
+	cnf.atomics = saved.CNFGrammar.Atomics
+	cnf.Compile()
+
+	parser := &Parser{
+		grammar: loadGrammar(saved.Grammar),
+		cnfGrammar: cnf,
+		original: loadGrammar(saved.Original),
+		stripRoot: saved.StripRoot,
+		parseByLimit: saved.ParseByLimit,
+	}
+	parser.options.fuzzyDistance = saved.FuzzyDistance
+	parser.options.compactForest = saved.CompactForest
+	parser.options.forbidden = saved.Forbidden
+	parser.options.tieEpsilon = saved.TieEpsilon
+	parser.options.maxNodes = saved.MaxNodes
+	parser.options.withRules = saved.WithRules
+	parser.options.longestMatch = saved.LongestMatch
+	parser.options.unknown = saved.Unknown
+	return parser, nil
+}
@@ -0,0 +1,115 @@
+package pcfg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSaveAndLoadParser(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing | new york
+		<time> ::= today | tomorrow | <nil>
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <city> <time> | <city> <time> weather
+		;!exports: <city> <time>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.StripRoot(true)
+
+	var buffer bytes.Buffer
+	if err := parser.Save(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadParser(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: the same parsing trees
+	for _, query := range []string{
+		"what's the weather in seattle today",
+		"weather in new york",
+		"beijing tomorrow weather",
+		"weather in paris",
+	} {
+		tree := parser.Parse(strings.Fields(query))
+		loadedTree := loaded.Parse(strings.Fields(query))
+		if (tree == nil) != (loadedTree == nil) || tree != nil && tree.Diff(loadedTree) != "" {
+			t.Fatalf("'%v' != '%v'", loadedTree, tree)
+		}
+	}
+
+	// TestCase-2: the original grammar is kept for ParseIntent
+	if tree := loaded.ParseIntent("<city>", strings.Fields("new york")); tree == nil {
+		t.Fatal("tree != nil expected")
+	}
+
+	// TestCase-3: bad input
+	if _, err := LoadParser(strings.NewReader("not a parser")); err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
+
+func TestSaveParserOptions(t *testing.T) {
+	grammar, err := ParseGrammar(`
+		<city> ::= seattle | beijing | <?unk> ; 0.01
+		<root> ::= weather in <city>
+		;!exports: <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar.PreserveDistribution(true)
+	grammar.temperature = 2.0
+	parser, err := NewParserFromGrammar(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.TieBreak(0.5, func(a, b *Candidate) bool { return false })
+	parser.MaxNodes(1000)
+	parser.Provenance(true)
+	parser.LongestMatch(true)
+	parser.SetUnknownSymbol("<city>")
+	parser.ParseByLimit(3)
+
+	var buffer bytes.Buffer
+	if err := parser.Save(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadParser(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: options of parser, except the functions
+	expected := parser.options
+	expected.tieBreak = nil
+	options := loaded.options
+	if options.tieEpsilon != expected.tieEpsilon ||
+		options.maxNodes != expected.maxNodes ||
+		options.withRules != expected.withRules ||
+		options.longestMatch != expected.longestMatch ||
+		options.unknown != expected.unknown ||
+		loaded.parseByLimit != parser.parseByLimit {
+		t.Fatalf("'%+v' != '%+v'", options, expected)
+	}
+
+	// TestCase-2: settings of grammar
+	for _, g := range []*Grammar{loaded.grammar, loaded.original} {
+		if !g.preserveDistribution || g.temperature != 2.0 {
+			t.Fatalf(
+				"preserveDistribution = %v, temperature = %g",
+				g.preserveDistribution,
+				g.temperature)
+		}
+	}
+
+	// TestCase-3: the same parsing tree with unknown token
+	query := strings.Fields("weather in paris")
+	tree := parser.Parse(query)
+	loadedTree := loaded.Parse(query)
+	if tree == nil || loadedTree == nil || tree.Diff(loadedTree) != "" {
+		t.Fatalf("'%v' != '%v'", loadedTree, tree)
+	}
+}
@@ -0,0 +1,88 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// SeparatorSymbol is the built-in symbol deriving the separator token, which
+// joins the sentences of an utterance, like
+//     <utterance> ::= <sentence> <SEP> <sentence>
+const SeparatorSymbol = Symbol("<SEP>")
+
+// Separator token by default, which couldn't be a terminal in grammar text
+const _DefaultSeparator = "|"
+
+// Separator sets the token in query derived from <SEP>, "|" by default. The
+// token is reserved for <SEP> and couldn't be a terminal of other rules. It's
+// also set by the ;!separator: command in grammar text
+func (g *Grammar) Separator(token string) {
+	g.separator = token
+}
+
+// separatorToken returns the separator token of grammar
+func (g *Grammar) separatorToken() string {
+	if g.separator == "" {
+		return _DefaultSeparator
+	}
+	return g.separator
+}
+
+// parseSeparator parses the separator command, like
+//     ;!separator: ||
+func parseSeparator(text string) (string, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 1 {
+		return "", errors.New(fmt.Sprintf(
+			"ParseGrammar: one token expected in separator command: %s",
+			text))
+	}
+	return fields[0], nil
+}
+
+// addSeparatorRule adds the rule <SEP> ::= token if <SEP> is in the right of
+// rules. Returns the separator token, empty if <SEP> is not used
+func (g *Grammar) addSeparatorRule() (string, error) {
+	token := g.separatorToken()
+	if !Symbol(token).IsTerminal() {
+		return "", errors.New(fmt.Sprintf(
+			"Grammar.ConvertToCNF: separator '%s' is not a terminal",
+			token))
+	}
+	used := false
+	for _, rule := range g.Rules {
+		if rule.Left == SeparatorSymbol {
+			return "", errors.New(fmt.Sprintf(
+				"Grammar.ConvertToCNF: %s is reserved but defined by '%s'",
+				SeparatorSymbol,
+				rule.Id()))
+		}
+		for _, symbol := range rule.Right {
+			if symbol == SeparatorSymbol {
+				used = true
+			} else if symbol.IsTerminal() && symbol.Literal() == token {
+				return "", errors.New(fmt.Sprintf(
+					"Grammar.ConvertToCNF: separator '%s' is a terminal of '%s'",
+					token,
+					rule.Id()))
+			}
+		}
+	}
+	if !used {
+		return "", nil
+	}
+	g.addRules(&Rule{
+		Left: SeparatorSymbol,
+		Right: []Symbol{quotedSymbol(token)},
+		Weight: 1.0,
+		Order: len(g.Rules),
+	})
+	return token, nil
+}
+
+// isSeparator returns true if token in query is the separator derived from
+// <SEP>
+func (g *CNFGrammar) isSeparator(token string) bool {
+	return g.separator != "" && g.normalizeToken(token) == g.separator
+}
@@ -0,0 +1,57 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+const separatorGrammar = `
+<city> ::= seattle | beijing
+<sentence> ::= weather in <city> | book a flight
+<root> ::= <sentence> | <sentence> <SEP> <sentence>
+;!exports: <sentence> <city>`
+
+func TestSeparator(t *testing.T) {
+	parser, err := NewParser(separatorGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: two sentences joined by the default separator
+	tree := parser.Parse(strings.Fields("weather in seattle | book a flight"))
+	expected := "(<root> \n  (<sentence> \n    weather \n    in \n    (<city> \n      seattle)) \n  | \n  (<sentence> \n    book \n    a \n    flight))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+	if !tree.Children[1].Separator || tree.Children[0].Separator {
+		t.Fatal("only the separator leaf marked as Separator expected")
+	}
+
+	// TestCase-2: separator set by command
+	parser, err = NewParser(separatorGrammar + "\n;!separator: ||")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.Parse(strings.Fields("book a flight || weather in beijing"))
+	if tree == nil || !tree.Children[1].Separator || tree.Children[1].Symbol != "||" {
+		t.Fatalf("separator leaf '||' expected, but got '%v'", tree)
+	}
+	tree = parser.Parse(strings.Fields("book a flight | weather in beijing"))
+	if tree != nil {
+		t.Fatalf("tree == nil expected, but got '%s'", tree.String())
+	}
+
+	// TestCase-3: <SEP> is reserved
+	_, err = NewParser(separatorGrammar + "\n<SEP> ::= and")
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+	_, err = NewParser(separatorGrammar + "\n;!separator: flight")
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+	_, err = NewParser(separatorGrammar + "\n;!separator: <and>")
+	if err == nil {
+		t.Fatal("err != nil expected")
+	}
+}
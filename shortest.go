@@ -0,0 +1,180 @@
+package pcfg
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// _ShortestDerivation is the shortest derivation of a symbol found by
+// ShortestString, by either a terminal rule or a binary rule
+type _ShortestDerivation struct {
+	symbol int
+	length int
+	logp float64
+	order int
+
+	// Terminal of the terminal rule, or the binary rule
+	terminal string
+	rule *CNFRule
+}
+
+// better returns true if d is shorter than other, or more probable with the
+// same length. The order of rules and symbols breaks ties
+func (d *_ShortestDerivation) better(other *_ShortestDerivation) bool {
+	if d.length != other.length {
+		return d.length < other.length
+	}
+	if d.logp != other.logp {
+		return d.logp > other.logp
+	}
+	if d.order != other.order {
+		return d.order < other.order
+	}
+	if d.symbol != other.symbol {
+		return d.symbol < other.symbol
+	}
+	return d.terminal < other.terminal
+}
+
+// _DerivationHeap is a min-heap of derivations by _ShortestDerivation.better
+type _DerivationHeap []*_ShortestDerivation
+
+func (h _DerivationHeap) Len() int { return len(h) }
+func (h _DerivationHeap) Less(i, j int) bool { return h[i].better(h[j]) }
+func (h _DerivationHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *_DerivationHeap) Push(x interface{}) { *h = append(*h, x.(*_ShortestDerivation)) }
+func (h *_DerivationHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old) - 1]
+	*h = old[: len(old) - 1]
+	return d
+}
+
+// ShortestString returns the shortest string of terminals derived from
+// <root>, ties broken by the highest probability. Like parsing, <root> is
+// derived by a rule from itself or through the path of rule. It's a best-first
+// search from the terminals, which finalizes the symbols in the order of their
+// shortest strings (Knuth's generalization of Dijkstra's algorithm). The
+// terminals are returned as written in grammar. <?unk> and regex terminals
+// stand for sets of tokens rather than tokens, so they're not used. Returns an
+// error if <root> derives no finite string of the other terminals
+func (g *CNFGrammar) ShortestString() ([]string, error) {
+	rootId, ok := g.SymbolIds[string(RootSymbol)]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf(
+			"CNFGrammar.ShortestString: %s not defined",
+			RootSymbol))
+	}
+
+	// Binary rules indexed by their targets
+	rulesByTarget := map[int][]*CNFRule{}
+	for _, secondRules := range g.Rules {
+		for _, rules := range secondRules {
+			for _, rule := range rules {
+				rulesByTarget[rule.FirstTarget] = append(rulesByTarget[rule.FirstTarget], rule)
+				if rule.SecondTarget != rule.FirstTarget {
+					rulesByTarget[rule.SecondTarget] = append(rulesByTarget[rule.SecondTarget], rule)
+				}
+			}
+		}
+	}
+
+	// Terminal rules of the terminals could be returned
+	terminalRules := []*CNFTerminalRule{}
+	for terminal, rules := range g.TerminalRules {
+		if terminal == string(UnknownSymbol) || Symbol(terminal).IsRegex() {
+			continue
+		}
+		terminalRules = append(terminalRules, rules...)
+	}
+	terminalDerivation := func(rule *CNFTerminalRule) *_ShortestDerivation {
+		terminal := rule.Literal
+		if terminal == "" {
+			terminal = rule.TerminalTarget
+		}
+		return &_ShortestDerivation{
+			symbol: rule.Source,
+			length: 1,
+			logp: float64(rule.LogProbability),
+			order: rule.Order,
+			terminal: terminal,
+		}
+	}
+
+	derivations := _DerivationHeap{}
+	for _, rule := range terminalRules {
+		derivations = append(derivations, terminalDerivation(rule))
+	}
+	heap.Init(&derivations)
+
+	// Shortest derivation of each symbol finalized
+	best := map[int]*_ShortestDerivation{}
+	binary := func(rule *CNFRule) *_ShortestDerivation {
+		first, second := best[rule.FirstTarget], best[rule.SecondTarget]
+		if first == nil || second == nil {
+			return nil
+		}
+		return &_ShortestDerivation{
+			symbol: rule.Source,
+			length: first.length + second.length,
+			logp: float64(rule.LogProbability) + first.logp + second.logp,
+			order: rule.Order,
+			rule: rule,
+		}
+	}
+	for derivations.Len() > 0 {
+		d := heap.Pop(&derivations).(*_ShortestDerivation)
+		if best[d.symbol] != nil {
+			continue
+		}
+		best[d.symbol] = d
+		for _, rule := range rulesByTarget[d.symbol] {
+			if best[rule.Source] == nil {
+				if next := binary(rule); next != nil {
+					heap.Push(&derivations, next)
+				}
+			}
+		}
+	}
+
+	// The best derivation of <root>, including the rules with <root> in path
+	root := best[rootId]
+	for _, rule := range terminalRules {
+		if indexOfSymbol(rule.Path, rootId) >= 0 {
+			if d := terminalDerivation(rule); root == nil || d.better(root) {
+				root = d
+			}
+		}
+	}
+	for _, secondRules := range g.Rules {
+		for _, rules := range secondRules {
+			for _, rule := range rules {
+				if indexOfSymbol(rule.Path, rootId) >= 0 {
+					if d := binary(rule); d != nil && (root == nil || d.better(root)) {
+						root = d
+					}
+				}
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New(fmt.Sprintf(
+			"CNFGrammar.ShortestString: no finite string derived from %s",
+			RootSymbol))
+	}
+
+	// Expand the derivations from left to right
+	tokens := []string{}
+	stack := []*_ShortestDerivation{root}
+	for len(stack) != 0 {
+		d := stack[len(stack) - 1]
+		stack = stack[: len(stack) - 1]
+		if d.rule == nil {
+			tokens = append(tokens, d.terminal)
+			continue
+		}
+		stack = append(stack, best[d.rule.SecondTarget], best[d.rule.FirstTarget])
+	}
+	return tokens, nil
+}
@@ -0,0 +1,72 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShortestString(t *testing.T) {
+	cases := []struct {
+		grammar string
+		expected string
+	}{
+		// TestCase-1: fewest tokens whatever the probability
+		{`
+			<city> ::= seattle ; 0.3 | new york ; 0.7
+			<root> ::= weather in <city> ; 0.1 | <city> weather forecast for today ; 0.9`,
+			"weather in seattle"},
+
+		// TestCase-2: ties broken by probability
+		{"<root> ::= a b ; 0.3 | c d ; 0.7", "c d"},
+
+		// TestCase-3: through the unit rule and a recursive one
+		{`
+			<city> ::= seattle ; 0.2 | beijing ; 0.8
+			<list> ::= <city> and <list> | <city>
+			<root> ::= <list>`,
+			"beijing"},
+
+		// TestCase-4: <?unk> and regex terminals skipped
+		{`
+			<city> ::= <?unk> ; 0.5 | /[a-z]+/ ; 0.4 | new york ; 0.1
+			<root> ::= <city>`,
+			"new york"},
+	}
+	for i, c := range cases {
+		parser, err := NewParser(c.grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, err := parser.cnfGrammar.ShortestString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(tokens, " ") != c.expected {
+			t.Fatalf("case %d: '%s' != '%s'", i + 1, strings.Join(tokens, " "), c.expected)
+		}
+	}
+
+	// TestCase-5: terminals as written in grammar when case-insensitive
+	parser, err := NewParser("<root> ::= Seattle Weather")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.SetCaseInsensitive(true); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := parser.cnfGrammar.ShortestString()
+	if err != nil || strings.Join(tokens, " ") != "Seattle Weather" {
+		t.Fatalf("'%v' != 'Seattle Weather'", tokens)
+	}
+
+	// TestCase-6: no finite string
+	grammar, err := ParseGrammar(`
+		<root> ::= x <loop> | <loop> y
+		<loop> ::= z <loop>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens, err := grammar.ConvertToCNF().ShortestString(); err == nil {
+		t.Fatalf("err != nil expected, but got %v", tokens)
+	}
+}
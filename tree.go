@@ -8,15 +8,45 @@ import (
 // Node represents a single node in parsing tree
 type Node struct {
 	// Children nodes
-	Children []*Node
+	Children []*Node `json:"children,omitempty"`
 
 	// Symbol in current node
-	Symbol string
+	Symbol string `json:"symbol"`
+
+	// If it's the leaf of a token deleted in parsing
+	Deleted bool `json:"deleted,omitempty"`
+
+	// If it's the leaf of a terminal inserted in parsing
+	Inserted bool `json:"inserted,omitempty"`
+
+	// For the leaf substituted in parsing, the original token in query
+	Original string `json:"original,omitempty"`
+
+	// If it's the leaf of the separator token derived from <SEP>, see
+	// Grammar.Separator
+	Separator bool `json:"separator,omitempty"`
+
+	// Span of tokens [Start, End) in query covered by the node. The leaves
+	// inserted in parsing are zero-width, where Start == End
+	Start int `json:"-"`
+	End int `json:"-"`
+
+	// CNF rule that produced the node, nil for leaves. It's set only when
+	// Parser.Provenance is enabled. The symbol of node is either the source of
+	// the rule or in its path
+	Rule *CNFRuleBase `json:"-"`
 }
 
 // Tree represents the parsing tree
 type Tree struct {
 	*Node
+
+	// Natural log of the probability of derivation, which is the sum of the
+	// log-probabilities of CNF rules used. The rules merged from unit rules
+	// (see Rule.Path) carry the probabilities of the unit rules, so they're
+	// accounted as well. It includes the penalties of fuzzy and
+	// error-correcting parsing, and it's 0 for the trees not from parsing
+	LogProb float64 `json:"logprob,omitempty"`
 }
 
 
@@ -51,3 +81,146 @@ func (n *Node) repr(level int) string {
 }
 
 
+// Equal returns true if t and other have the same symbols and structure
+func (t *Tree) Equal(other *Tree) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	return t.Node.Equal(other.Node)
+}
+
+// Equal returns true if n and other have the same symbols and structure
+// recursively
+func (n *Node) Equal(other *Node) bool {
+	if n == nil || other == nil {
+		return n == other
+	}
+	if n.Symbol != other.Symbol ||
+		n.Deleted != other.Deleted ||
+		n.Inserted != other.Inserted ||
+		n.Original != other.Original ||
+		len(n.Children) != len(other.Children) {
+		return false
+	}
+	for i, child := range n.Children {
+		if !child.Equal(other.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff returns the structural differences between t and other, one line per
+// difference, like
+//     <root>/2:<city>/0:seattle: symbol 'seattle' != 'beijing'
+// Each line starts with the path of node, which is the index and symbol of
+// each node from the root. Returns "" if they are equal
+func (t *Tree) Diff(other *Tree) string {
+	if t == nil || other == nil {
+		if t == other {
+			return ""
+		}
+		return fmt.Sprintf("/: %s != %s", t, other)
+	}
+	lines := diffNode(t.Node, other.Node, t.Symbol)
+	return strings.Join(lines, "\n")
+}
+
+// diffNode returns the differences between node a and b at path
+func diffNode(a, b *Node, path string) []string {
+	if a.Symbol != b.Symbol {
+		return []string{fmt.Sprintf("%s: symbol '%s' != '%s'", path, a.Symbol, b.Symbol)}
+	}
+	if len(a.Children) != len(b.Children) {
+		return []string{fmt.Sprintf(
+			"%s: children (%s) != (%s)",
+			path,
+			childrenSymbols(a),
+			childrenSymbols(b))}
+	}
+
+	lines := []string{}
+	for i, child := range a.Children {
+		childPath := fmt.Sprintf("%s/%d:%s", path, i, child.Symbol)
+		lines = append(lines, diffNode(child, b.Children[i], childPath)...)
+	}
+	return lines
+}
+
+// childrenSymbols returns the symbols of children of n joined by space
+func childrenSymbols(n *Node) string {
+	symbols := []string{}
+	for _, child := range n.Children {
+		symbols = append(symbols, child.Symbol)
+	}
+	return strings.Join(symbols, " ")
+}
+
+// CoNLL returns the tree in CoNLL-style columnar format, one line per token
+// with tab-separated columns: 1-based index, token and the chain of symbols
+// from the root to the parent of token, like
+//     3	seattle	<root>/<city>
+// Inserted leaves are skipped since they are not in query, and substituted
+// leaves print the original token. "_" stands for an empty chain
+func (t *Tree) CoNLL() string {
+	lines := []string{}
+	var walk func(n *Node, ancestors []string)
+	walk = func(n *Node, ancestors []string) {
+		if n.Children == nil {
+			if n.Inserted {
+				return
+			}
+			token := n.Symbol
+			if n.Original != "" {
+				token = n.Original
+			}
+			chain := strings.Join(ancestors, "/")
+			if chain == "" {
+				chain = "_"
+			}
+			lines = append(lines, fmt.Sprintf("%d\t%s\t%s", len(lines) + 1, token, chain))
+			return
+		}
+		ancestors = append(ancestors, n.Symbol)
+		for _, child := range n.Children {
+			walk(child, ancestors[: len(ancestors): len(ancestors)])
+		}
+	}
+	walk(t.Node, []string{})
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Truncate returns a copy of tree with depth at most maxDepth, where the root
+// is at depth 0. The non-leaf nodes at maxDepth are replaced by a leaf of the
+// terminals they cover joined by space. t is not changed
+func (t *Tree) Truncate(maxDepth int) *Tree {
+	return &Tree{Node: truncateNode(t.Node, maxDepth), LogProb: t.LogProb}
+}
+
+// truncateNode returns a copy of n truncated to depth
+func truncateNode(n *Node, depth int) *Node {
+	truncated := *n
+	if n.Children == nil {
+		return &truncated
+	}
+	if depth <= 0 {
+		return &Node{Symbol: strings.Join(n.leaves(), " "), Start: n.Start, End: n.End}
+	}
+	truncated.Children = []*Node{}
+	for _, child := range n.Children {
+		truncated.Children = append(truncated.Children, truncateNode(child, depth - 1))
+	}
+	return &truncated
+}
+
+// leaves returns the symbols of leaves under n from left to right
+func (n *Node) leaves() []string {
+	if n.Children == nil {
+		return []string{n.Symbol}
+	}
+	leaves := []string{}
+	for _, child := range n.Children {
+		leaves = append(leaves, child.leaves()...)
+	}
+	return leaves
+}
@@ -0,0 +1,105 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTreeEqualAndDiff(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <city>
+		;!exports: <city> <whats>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seattle := parser.Parse(strings.Fields("weather in seattle"))
+
+	// TestCase-1: equal trees
+	other := parser.Parse(strings.Fields("weather in seattle"))
+	if !seattle.Equal(other) || seattle.Diff(other) != "" {
+		t.Fatalf("equal trees expected, but got diff '%s'", seattle.Diff(other))
+	}
+
+	// TestCase-2: trees differing in a leaf
+	other = parser.Parse(strings.Fields("weather in beijing"))
+	if seattle.Equal(other) {
+		t.Fatal("different trees expected")
+	}
+	expected := "<root>/2:<city>/0:seattle: symbol 'seattle' != 'beijing'"
+	if seattle.Diff(other) != expected {
+		t.Fatalf("'%s' != '%s'", seattle.Diff(other), expected)
+	}
+
+	// TestCase-3: trees differing in structure
+	other = parser.Parse(strings.Fields("what's the weather in seattle"))
+	if seattle.Equal(other) {
+		t.Fatal("different trees expected")
+	}
+	expected = "<root>: children (weather in <city>) != (<whats> weather in <city>)"
+	if seattle.Diff(other) != expected {
+		t.Fatalf("'%s' != '%s'", seattle.Diff(other), expected)
+	}
+
+	// TestCase-4: nil trees
+	var nilTree *Tree
+	if !nilTree.Equal(nil) || seattle.Equal(nil) || nilTree.Diff(nil) != "" {
+		t.Fatal("nil trees are only equal to nil")
+	}
+}
+
+func TestCoNLL(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <city>
+		;!exports: <city> <whats>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse(strings.Fields("what's the weather in seattle"))
+	expected := "1\twhat's\t<root>/<whats>\n" +
+		"2\tthe\t<root>/<whats>\n" +
+		"3\tweather\t<root>\n" +
+		"4\tin\t<root>\n" +
+		"5\tseattle\t<root>/<city>\n"
+	if tree.CoNLL() != expected {
+		t.Fatalf("'%s' != '%s'", tree.CoNLL(), expected)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= seattle | beijing
+		<place> ::= <city> downtown
+		<whats> ::= what's the | <nil>
+		<root> ::= <whats> weather in <place>
+		;!exports: <city> <whats> <place>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := parser.Parse(strings.Fields("what's the weather in seattle downtown"))
+	original := tree.String()
+
+	// TestCase-1: depth 1
+	expected := "(<root> \n  what's the \n  weather \n  in \n  seattle downtown)"
+	if truncated := tree.Truncate(1); truncated.String() != expected {
+		t.Fatalf("'%s' != '%s'", truncated.String(), expected)
+	}
+
+	// TestCase-2: depth 2
+	expected = "(<root> \n  (<whats> \n    what's \n    the) \n  weather \n  in \n  " +
+		"(<place> \n    seattle \n    downtown))"
+	if truncated := tree.Truncate(2); truncated.String() != expected {
+		t.Fatalf("'%s' != '%s'", truncated.String(), expected)
+	}
+
+	// TestCase-3: deep enough and the original tree
+	if truncated := tree.Truncate(10); !truncated.Equal(tree) {
+		t.Fatalf("'%s' != '%s'", truncated.String(), tree.String())
+	}
+	if tree.String() != original {
+		t.Fatalf("'%s' != '%s'", tree.String(), original)
+	}
+}
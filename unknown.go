@@ -0,0 +1,36 @@
+package pcfg
+
+// UnknownSymbol is the built-in terminal matching any token not in the
+// terminals of grammar, like
+//     <word> ::= <?unk> ; 0.01
+// So that a query with out-of-vocabulary tokens could still be parsed
+const UnknownSymbol = Symbol("<?unk>")
+
+// SetUnknownSymbol sets the non-terminal absorbing the tokens not in grammar.
+// Then only the rules symbol ::= <?unk>, including the ones merged through
+// unit rules, match the unknown tokens. Set symbol to "" for all the rules of
+// <?unk>, which is the default
+func (p *Parser) SetUnknownSymbol(symbol Symbol) {
+	p.options.unknown = string(symbol)
+}
+
+// unknownRules returns the terminal rules matching a token not in grammar,
+// which are the rules of <?unk> deriving symbol unknown, or all of them if
+// unknown is empty
+func unknownRules(grammar *CNFGrammar, unknown string) []*CNFTerminalRule {
+	rules := grammar.TerminalRules[string(UnknownSymbol)]
+	if unknown == "" {
+		return rules
+	}
+	symbolId, ok := grammar.SymbolIds[unknown]
+	if !ok {
+		return nil
+	}
+	matched := []*CNFTerminalRule{}
+	for _, rule := range rules {
+		if rule.Source == symbolId || indexOfSymbol(rule.Path, symbolId) >= 0 {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,70 @@
+package pcfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnknownSymbol(t *testing.T) {
+	parser, err := NewParser(`
+		<name> ::= bob | alice | <?unk> ; 0.01
+		<city> ::= seattle | <?unk> ; 0.01
+		<root> ::= call <name> ; 0.6 | weather in <city> ; 0.4
+		;!exports: <name> <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: known tokens are not affected
+	tree := parser.Parse(strings.Fields("call bob"))
+	expected := "(<root> \n  call \n  (<name> \n    bob))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: the unknown token absorbed by <name> and <city>
+	tree = parser.Parse(strings.Fields("call carol"))
+	expected = "(<root> \n  call \n  (<name> \n    carol))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+	tree = parser.Parse(strings.Fields("weather in tokyo"))
+	expected = "(<root> \n  weather \n  in \n  (<city> \n    tokyo))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: only <name> absorbs unknown tokens
+	parser.SetUnknownSymbol("<name>")
+	if tree := parser.Parse(strings.Fields("weather in tokyo")); tree != nil {
+		t.Fatalf("nil expected, but got '%v'", tree)
+	}
+	tree = parser.Parse(strings.Fields("call carol"))
+	expected = "(<root> \n  call \n  (<name> \n    carol))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: single token and dictionary grammar
+	parser, err = NewParser(`
+		<name> ::= bob | <?unk> ; 0.01
+		<root> ::= <name>
+		;!exports: <name>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.Parse(strings.Fields("carol"))
+	expected = "(<root> \n  (<name> \n    carol))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-5: no rules of <?unk>
+	parser, err = NewParser("<root> ::= call bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree := parser.Parse(strings.Fields("call carol")); tree != nil {
+		t.Fatalf("nil expected, but got '%v'", tree)
+	}
+}
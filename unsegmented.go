@@ -0,0 +1,235 @@
+package pcfg
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// ParseUnsegmented parses text without whitespace between tokens, like text
+// in Chinese or Japanese, by segmenting it with the terminals of grammar
+// jointly. The terminal rules match the substrings of text at each position,
+// and the segmentation of the most probable parsing tree is chosen. The
+// leaves of tree are the substrings matched, and the spans of tree nodes are
+// character offsets in the normalized text. Returns nil if no segmentation
+// matches the grammar, or the table exceeds MaxNodes. Of the parse options,
+// only ForbidCombination, LongestMatch, TieBreak and MaxNodes apply
+func (p *Parser) ParseUnsegmented(text string) *Tree {
+	grammar := p.cnfGrammar
+	rootId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if !ok || text == "" {
+		return nil
+	}
+	table, pieces := buildLatticeTable(grammar, grammar.normalizeToken(text), &p.options)
+	if table == nil {
+		// Deadline or node limit exceeded
+		return nil
+	}
+	if p.options.longestMatch {
+		keepLongestMatches(table, rootId, pieces)
+	}
+	candidate := bestCandidate(grammar, table, rootId, RootSymbol, pieces, &p.options)
+	if candidate == nil {
+		return nil
+	}
+	setCharacterSpans(candidate.Tree.Node, 0)
+	return p.stripTree(candidate.Tree)
+}
+
+// setCharacterSpans sets the spans of node and its descendants to the offsets
+// in characters, where node starts from offset. The leaves are constructed with
+// the positions of pieces in segmentation, so the spans are derived from the
+// characters of leaves. Returns the offset after node
+func setCharacterSpans(node *Node, offset int) int {
+	if len(node.Children) == 0 {
+		// The atomic leaf joins its pieces with spaces, which are not in text
+		length := utf8.RuneCountInString(node.Symbol)
+		if node.End - node.Start > 1 {
+			length -= node.End - node.Start - 1
+		}
+		node.Start, node.End = offset, offset + length
+		return node.End
+	}
+	end := offset
+	for _, child := range node.Children {
+		end = setCharacterSpans(child, end)
+	}
+	node.Start, node.End = offset, end
+	return end
+}
+
+// buildLatticeTable fills the CYK table over the characters of text.
+// table[length][start] is the linked list of nodes for the characters
+// [start, start + length). A terminal of n characters matched at start adds
+// its nodes into table[n][start], whose leaf is the index of the substring
+// in pieces like the index of token in query. Like buildTable, returns nil if
+// the deadline or the node limit of options is exceeded
+func buildLatticeTable(
+	grammar *CNFGrammar,
+	text string,
+	options *_ParseOptions) ([][]*_CYKNode, []string) {
+	expired := func() bool {
+		return !options.deadline.IsZero() && time.Now().After(options.deadline)
+	}
+	runes := []rune(text)
+	pool := newNodePool()
+	exhausted := func() bool {
+		return options.maxNodes > 0 && pool.Size() > options.maxNodes
+	}
+	combinations := 0
+	constraints := newConstraints(grammar, options.forbidden)
+	table := make([][]*_CYKNode, len(runes) + 1)
+	for length := range table {
+		table[length] = make([]*_CYKNode, len(runes) - length + 1)
+	}
+
+	// Terminals: look up the substrings no longer than the longest terminal
+	maxLength := 0
+	for terminal := range grammar.TerminalRules {
+		if n := utf8.RuneCountInString(terminal); n > maxLength {
+			maxLength = n
+		}
+	}
+	pieces := []string{}
+	for start := range runes {
+		for length := 1; length <= maxLength && start + length <= len(runes); length++ {
+			piece := string(runes[start: start + length])
+			rules := grammar.TerminalRules[piece]
+			if len(rules) == 0 {
+				continue
+			}
+			leaf := &_CYKNode{symbol: -len(pieces) - 1}
+			pieces = append(pieces, piece)
+			nodes := table[length][start]
+			for _, rule := range rules {
+				features, ok := constraints.combine(rule.Source, &rule.CNFRuleBase)
+				if !ok {
+					continue
+				}
+				node := pool.Get()
+				node.symbol = rule.Source
+				node.rule = &rule.CNFRuleBase
+				node.logp = rule.LogProbability
+				node.left = leaf
+				node.next = nodes
+				node.features = features
+				nodes = node
+			}
+			table[length][start] = nodes
+		}
+	}
+	if exhausted() {
+		return nil, nil
+	}
+
+	// Spans of 2 or more characters: apply non-terminal rules
+	for length := 2; length <= len(runes); length++ {
+		for start := 0; start + length <= len(runes); start++ {
+			if expired() {
+				return nil, nil
+			}
+			nodes := table[length][start]
+			for partition := 1; partition < length; partition++ {
+				for left := table[partition][start]; left != nil; left = left.next {
+					right := table[length - partition][start + partition]
+					for ; right != nil; right = right.next {
+						combinations++
+						if combinations % _DeadlineCheckInterval == 0 && expired() {
+							return nil, nil
+						}
+						for _, rule := range grammar.lookupRules(left.symbol, right.symbol) {
+							features, ok := constraints.combine(
+								rule.Source,
+								&rule.CNFRuleBase,
+								left,
+								right)
+							if !ok {
+								continue
+							}
+							node := pool.Get()
+							node.symbol = rule.Source
+							node.rule = &rule.CNFRuleBase
+							node.logp = rule.LogProbability + left.logp + right.logp
+							node.left = left
+							node.right = right
+							node.next = nodes
+							node.features = features
+							nodes = node
+						}
+						if exhausted() {
+							return nil, nil
+						}
+					}
+				}
+			}
+			table[length][start] = nodes
+		}
+	}
+	return table, pieces
+}
+
+// keepLongestMatches keeps only the root nodes in the top cell of table whose
+// segmentation has the longest matches. Segmentations are compared by the
+// lengths of their pieces from left to right, and the first longer piece wins
+func keepLongestMatches(table [][]*_CYKNode, rootId int, pieces []string) {
+	var best []int
+	var kept *_CYKNode
+	for _, root := range findRoots(table, rootId) {
+		lengths := matchLengths(root.node, pieces)
+		switch compareMatches(lengths, best) {
+		case 1:
+			best = lengths
+			kept = nil
+			fallthrough
+		case 0:
+			root.node.next = kept
+			kept = root.node
+		}
+	}
+
+	// Reverse to keep the original order of nodes
+	var nodes *_CYKNode
+	for kept != nil {
+		next := kept.next
+		kept.next = nodes
+		nodes = kept
+		kept = next
+	}
+	table[len(table) - 1][0] = nodes
+}
+
+// matchLengths returns the number of characters of each piece matched by the
+// leaves of node from left to right
+func matchLengths(node *_CYKNode, pieces []string) []int {
+	lengths := []int{}
+	stack := []*_CYKNode{node}
+	for len(stack) != 0 {
+		node := stack[len(stack) - 1]
+		stack = stack[: len(stack) - 1]
+		if node.symbol < 0 {
+			lengths = append(lengths, utf8.RuneCountInString(pieces[-node.symbol - 1]))
+			continue
+		}
+		if node.right != nil {
+			stack = append(stack, node.right)
+		}
+		stack = append(stack, node.left)
+	}
+	return lengths
+}
+
+// compareMatches returns 1 if the first different piece of a is longer than b,
+// -1 if shorter, 0 if they are the same. Any segmentation is better than nil
+func compareMatches(a, b []int) int {
+	if b == nil {
+		return 1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] > b[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
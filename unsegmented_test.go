@@ -0,0 +1,136 @@
+package pcfg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUnsegmented(t *testing.T) {
+	parser, err := NewParser(`
+		<city> ::= 上海 | 北京
+		<time> ::= 明天 | 今天
+		<root> ::= <city> <time> 天气 | <city> 天气
+		;!exports: <city> <time>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: segmented by terminals
+	tree := parser.ParseUnsegmented("上海明天天气")
+	expected := "(<root> \n  (<city> \n    上海) \n  (<time> \n    明天) \n  天气)"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: not matched
+	for _, text := range []string{"上海后天天气", "上海明天天", ""} {
+		if tree := parser.ParseUnsegmented(text); tree != nil {
+			t.Fatalf("%s: nil expected, but got '%s'", text, tree.String())
+		}
+	}
+
+	// TestCase-3: the most probable segmentation
+	parser, err = NewParser(`
+		<w> ::= 南京 | 南京市 | 市长 | 长江 | 长江大桥 | 江 | 大桥
+		<root> ::= <w> <w> | <w> <w> <w>
+		;!exports: <w>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.ParseUnsegmented("南京市长江大桥")
+	expected = "(<root> \n  (<w> \n    南京市) \n  (<w> \n    长江大桥))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: spans are the offsets in characters
+	spans := [][2]int{}
+	for _, node := range []*Node{
+		tree.Node,
+		tree.Children[0],
+		tree.Children[0].Children[0],
+		tree.Children[1],
+		tree.Children[1].Children[0]} {
+		spans = append(spans, [2]int{node.Start, node.End})
+	}
+	expectedSpans := [][2]int{{0, 7}, {0, 3}, {0, 3}, {3, 7}, {3, 7}}
+	if !reflect.DeepEqual(spans, expectedSpans) {
+		t.Fatalf("'%v' != '%v'", spans, expectedSpans)
+	}
+
+	// TestCase-5: the atomic leaf spans the characters of its pieces
+	parser, err = NewParser(`
+		<city> ::= 上海 | 北京
+		<time> ::= 明天 | 今天
+		<when> ::= <city> <time>
+		<root> ::= <when> 天气
+		;!atomic: <when>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = parser.ParseUnsegmented("上海明天天气")
+	expected = "(<root> \n  (<when> \n    上海 明天) \n  天气)"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+	leaf := tree.Children[0].Children[0]
+	if leaf.Start != 0 || leaf.End != 4 || tree.Children[1].Start != 4 || tree.End != 6 {
+		t.Fatalf("'[%d, %d)' != '[0, 4)'", leaf.Start, leaf.End)
+	}
+}
+
+func TestLongestMatch(t *testing.T) {
+	parser, err := NewParser(`
+		<w> ::= 北京 ; 9 | 北京大学 ; 1 | 大学 ; 9 | 北京大 ; 1 | 学 ; 0.01
+		<root> ::= <w> | <w> <w>
+		;!exports: <w>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: the most probable segmentation by default
+	tree := parser.ParseUnsegmented("北京大学")
+	expected := "(<root> \n  (<w> \n    北京) \n  (<w> \n    大学))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-2: longest match wins
+	parser.LongestMatch(true)
+	tree = parser.ParseUnsegmented("北京大学")
+	expected = "(<root> \n  (<w> \n    北京大学))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-3: a shorter match if the longer one doesn't parse
+	parser, err = NewParser(`
+		<w> ::= 北京 ; 9 | 北京大学 ; 1 | 大学 ; 9 | 北京大 ; 1 | 学 ; 0.01
+		<root> ::= <w> <w>
+		;!exports: <w>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.LongestMatch(true)
+	tree = parser.ParseUnsegmented("北京大学")
+	expected = "(<root> \n  (<w> \n    北京大) \n  (<w> \n    学))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+
+	// TestCase-4: probabilities choose among the same segmentation
+	parser, err = NewParser(`
+		<a> ::= 北京 ; 1 | 北京大学 ; 3
+		<b> ::= 北京大学 ; 1 | 大学 ; 1
+		<root> ::= <a> | <b> | <a> <b>
+		;!exports: <a> <b>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.LongestMatch(true)
+	tree = parser.ParseUnsegmented("北京大学")
+	expected = "(<root> \n  (<a> \n    北京大学))"
+	if tree == nil || tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", tree, expected)
+	}
+}
@@ -2,6 +2,7 @@ package pcfg
 
 import (
 	"log"
+	"math"
 )
 
 // checkAndFatal check err. If err != nil, trigger log.Fatal
@@ -16,4 +17,17 @@ func assert(exp bool, message string) {
 	if !exp {
 		log.Fatal(message)
 	}
-}
\ No newline at end of file
+}
+
+// MinLogProb is the smallest log-probability that could be converted to a
+// normal float64 probability, log(2.2250738585072014e-308). Below it,
+// math.Exp loses precision and underflows to 0 around -745
+const MinLogProb = -708.3964185322641
+
+// LinearProb converts log-probability to probability. Computations should be
+// kept in log space, and only converted by LinearProb at the end. ok is false
+// when logp < MinLogProb, which means the probability is imprecise or 0 due to
+// underflow
+func LinearProb(logp float64) (p float64, ok bool) {
+	return math.Exp(logp), logp >= MinLogProb || math.IsInf(logp, -1)
+}
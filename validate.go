@@ -0,0 +1,135 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"strings"
+)
+
+// ValidateTree checks that t is a legal parsing tree under the grammar, i.e.
+// it equals the tree of some derivation of its leaves from <root>. Returns the
+// log-probability of the most probable such derivation. Otherwise, returns an
+// error identifying the first illegal node, which is the first node in
+// post-order whose children could not be derived from its symbol. The trees
+// with edited leaves (see Node) are not legal. If StripRoot is enabled, t
+// could be the only child of <root> as well
+func (p *Parser) ValidateTree(t *Tree) (float64, error) {
+	if t == nil || t.Node == nil {
+		return 0, errors.New("Parser.ValidateTree: tree is nil")
+	}
+	node := t.Node
+	if node.Symbol != string(RootSymbol) && p.stripRoot {
+		node = &Node{Children: []*Node{node}, Symbol: string(RootSymbol)}
+	}
+	if node.Symbol != string(RootSymbol) || node.Children == nil {
+		return 0, errors.New(fmt.Sprintf(
+			"Parser.ValidateTree: tree is not rooted at %s",
+			RootSymbol))
+	}
+	if edited := findEdited(node); edited != nil {
+		return 0, errors.New(fmt.Sprintf(
+			"Parser.ValidateTree: edited leaf '%s'",
+			edited.Symbol))
+	}
+
+	// All derivations are needed, so the table shouldn't be pruned
+	grammar := p.cnfGrammar
+	query := node.leaves()
+	startId, ok := grammar.SymbolIds[string(RootSymbol)]
+	if ok {
+		table := buildTable(grammar, query, &_ParseOptions{
+			forbidden: p.options.forbidden,
+			deadline: p.options.deadline,
+			maxNodes: p.options.maxNodes,
+		})
+		if table == nil {
+			return 0, errors.New("Parser.ValidateTree: node limit or deadline exceeded")
+		}
+		logp := math.Inf(-1)
+		for _, root := range findRoots(table, startId) {
+			tree := constructStartTree(grammar, root, RootSymbol, false, query)
+			if float64(root.node.logp) > logp && tree.Node.Equal(node) {
+				logp = float64(root.node.logp)
+			}
+		}
+		if !math.IsInf(logp, -1) {
+			return logp, nil
+		}
+
+		// Find the first node not derived in the table
+		if illegal := findUnderived(grammar, table, node, query); illegal != nil {
+			node = illegal
+		}
+	}
+	children := []string{}
+	for _, child := range node.Children {
+		children = append(children, child.Symbol)
+	}
+	return 0, errors.New(fmt.Sprintf(
+		"Parser.ValidateTree: illegal expansion %s -> %s",
+		node.Symbol,
+		strings.Join(children, " ")))
+}
+
+// findEdited returns the first leaf of n deleted, inserted or substituted in
+// parsing, nil if not found
+func findEdited(n *Node) *Node {
+	if n.Children == nil {
+		if n.Deleted || n.Inserted || n.Original != "" {
+			return n
+		}
+		return nil
+	}
+	for _, child := range n.Children {
+		if edited := findEdited(child); edited != nil {
+			return edited
+		}
+	}
+	return nil
+}
+
+// findUnderived returns the first inner node of root in post-order, excluding
+// root itself, whose subtree is not constructed by any node in its cell of
+// table. Returns nil if all of them are derived
+func findUnderived(
+	grammar *CNFGrammar,
+	table [][]*_CYKNode,
+	root *Node,
+	query []string) *Node {
+	var illegal *Node
+
+	// walk visits the inner nodes in post-order, start is the index of the
+	// first leaf of n. Returns the index after the last leaf of n
+	var walk func(n *Node, start int) int
+	walk = func(n *Node, start int) int {
+		if n.Children == nil {
+			return start + 1
+		}
+		end := start
+		for _, child := range n.Children {
+			end = walk(child, end)
+		}
+		if illegal == nil && n != root && !isDerived(grammar, table[end - start][start], n, query) {
+			illegal = n
+		}
+		return end
+	}
+	walk(root, 0)
+	return illegal
+}
+
+// isDerived returns true if any node in the linked list nodes constructs n,
+// either itself or a symbol in the path of its rule
+func isDerived(grammar *CNFGrammar, nodes *_CYKNode, n *Node, query []string) bool {
+	for ; nodes != nil; nodes = nodes.next {
+		treeNodes := constructNodes(grammar, nodes, 0, true, false, 0, query)
+		for len(treeNodes) == 1 {
+			if treeNodes[0].Equal(n) {
+				return true
+			}
+			treeNodes = treeNodes[0].Children
+		}
+	}
+	return false
+}
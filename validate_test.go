@@ -0,0 +1,73 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidateTree(t *testing.T) {
+	parser, err := NewParser(intentGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-1: tree from Parse
+	candidate := parser.parse(strings.Fields("weather in seattle"))
+	logp, err := parser.ValidateTree(candidate.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(logp - candidate.LogProb) > 1e-9 {
+		t.Fatalf("%f != %f", logp, candidate.LogProb)
+	}
+
+	// TestCase-2: tree constructed by hand
+	tree := &Tree{Node: &Node{Symbol: "<root>", Children: []*Node{
+		{Symbol: "<music>", Children: []*Node{
+			{Symbol: "play"},
+			{Symbol: "<song>", Children: []*Node{{Symbol: "hello"}}},
+		}},
+	}}}
+	if _, err := parser.ValidateTree(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	// TestCase-3: illegal expansion of <song>
+	tree.Children[0].Children[1].Children[0].Symbol = "seattle"
+	_, err = parser.ValidateTree(tree)
+	expected := "Parser.ValidateTree: illegal expansion <song> -> seattle"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("'%v' != '%s'", err, expected)
+	}
+
+	// TestCase-4: illegal expansion of <music>, whose children are legal
+	tree = &Tree{Node: &Node{Symbol: "<root>", Children: []*Node{
+		{Symbol: "<music>", Children: []*Node{
+			{Symbol: "<song>", Children: []*Node{{Symbol: "hello"}}},
+			{Symbol: "play"},
+		}},
+	}}}
+	_, err = parser.ValidateTree(tree)
+	expected = "Parser.ValidateTree: illegal expansion <music> -> <song> play"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("'%v' != '%s'", err, expected)
+	}
+
+	// TestCase-5: illegal expansion of <root>
+	tree = &Tree{Node: &Node{Symbol: "<root>", Children: []*Node{
+		{Symbol: "<city>", Children: []*Node{{Symbol: "seattle"}}},
+	}}}
+	_, err = parser.ValidateTree(tree)
+	expected = "Parser.ValidateTree: illegal expansion <root> -> <city>"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("'%v' != '%s'", err, expected)
+	}
+
+	// TestCase-6: stripped <root>
+	parser.StripRoot(true)
+	tree = parser.Parse(strings.Fields("play yesterday"))
+	if _, err := parser.ValidateTree(tree); err != nil {
+		t.Fatal(err)
+	}
+}
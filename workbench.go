@@ -0,0 +1,91 @@
+package pcfg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Workbench holds a grammar under development. Rules and exports are edited in
+// place, and the grammar is converted to CNF lazily by the next Parse after
+// edits, so that a sequence of edits converts it only once
+type Workbench struct {
+	grammar *Grammar
+
+	// Parser of the current grammar, nil if the grammar is edited after it's
+	// converted
+	parser *Parser
+}
+
+// NewWorkbench creates a new instance of Workbench with the grammar text,
+// which could be empty
+func NewWorkbench(grammarText string) (*Workbench, error) {
+	grammar, err := ParseGrammar(grammarText)
+	if err != nil {
+		return nil, err
+	}
+	return &Workbench{grammar: grammar}, nil
+}
+
+// AddRule adds the rules in a line of grammar text, like
+// "<city> ::= seattle | beijing"
+func (w *Workbench) AddRule(ruleText string) error {
+	rules, err := ParseRule(strings.TrimSpace(ruleText))
+	if err != nil {
+		return err
+	}
+	for i, rule := range rules {
+		rule.Order = len(w.grammar.Rules) + i
+	}
+	w.grammar.Rules = append(w.grammar.Rules, rules...)
+	w.parser = nil
+	return nil
+}
+
+// RemoveRule removes the rules with the identifier id (see Rule.Id), like
+// "<city> ::= seattle". Returns error if no rule is removed
+func (w *Workbench) RemoveRule(id string) error {
+	rules := []*Rule{}
+	for _, rule := range w.grammar.Rules {
+		if rule.Id() != id {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(w.grammar.Rules) {
+		return errors.New(fmt.Sprintf("Workbench.RemoveRule: rule not found: '%s'", id))
+	}
+	w.grammar.Rules = rules
+	w.parser = nil
+	return nil
+}
+
+// SetExports replaces the export symbols of grammar with symbols
+func (w *Workbench) SetExports(symbols ...Symbol) error {
+	for _, symbol := range symbols {
+		if !symbol.IsValid() || symbol.IsTerminal() {
+			return errors.New(fmt.Sprintf(
+				"Workbench.SetExports: unexpected export symbol: '%s'",
+				symbol))
+		}
+	}
+	w.grammar.Exports = map[Symbol]bool{}
+	for _, symbol := range symbols {
+		w.grammar.Exports[symbol] = true
+	}
+	w.parser = nil
+	return nil
+}
+
+// Parse parses query with the current grammar, and returns the best parsing
+// tree with its log-probability, nil if not matched. The grammar is converted
+// to CNF first if it's edited, and the error of conversion is returned
+func (w *Workbench) Parse(query []string) (*Candidate, error) {
+	if w.parser == nil {
+		parser, err := NewParserFromGrammar(w.grammar.clone())
+		if err != nil {
+			return nil, err
+		}
+		w.parser = parser
+	}
+	return w.parser.parse(query), nil
+}
@@ -0,0 +1,78 @@
+package pcfg
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWorkbench(t *testing.T) {
+	workbench, err := NewWorkbench(`
+		<city> ::= seattle | beijing
+		<root> ::= weather in <city>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := strings.Fields("weather in shanghai")
+
+	// TestCase-1: not matched
+	candidate, err := workbench.Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidate != nil {
+		t.Fatalf("nil expected, but got '%s'", candidate.Tree.String())
+	}
+
+	// TestCase-2: add rule
+	if err := workbench.AddRule("<city> ::= shanghai ; 2"); err != nil {
+		t.Fatal(err)
+	}
+	candidate, err = workbench.Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "(<root> \n  weather \n  in \n  shanghai)"
+	if candidate == nil || candidate.Tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", candidate, expected)
+	}
+	if math.Abs(candidate.LogProb - math.Log(0.5)) > probTolerance {
+		t.Fatalf("%f != %f", candidate.LogProb, math.Log(0.5))
+	}
+
+	// TestCase-3: set exports
+	if err := workbench.SetExports("<city>"); err != nil {
+		t.Fatal(err)
+	}
+	candidate, err = workbench.Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "(<root> \n  weather \n  in \n  (<city> \n    shanghai))"
+	if candidate == nil || candidate.Tree.String() != expected {
+		t.Fatalf("'%v' != '%s'", candidate, expected)
+	}
+
+	// TestCase-4: remove rule
+	if err := workbench.RemoveRule("<city> ::= shanghai"); err != nil {
+		t.Fatal(err)
+	}
+	candidate, err = workbench.Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidate != nil {
+		t.Fatalf("nil expected, but got '%s'", candidate.Tree.String())
+	}
+	if err := workbench.RemoveRule("<city> ::= shanghai"); err == nil {
+		t.Fatalf("err != nil expected")
+	}
+
+	// TestCase-5: bad edits
+	if err := workbench.AddRule("<city> = tokyo"); err == nil {
+		t.Fatalf("err != nil expected")
+	}
+	if err := workbench.SetExports("tokyo"); err == nil {
+		t.Fatalf("err != nil expected")
+	}
+}